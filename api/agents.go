@@ -0,0 +1,146 @@
+package api
+
+import "time"
+
+// Tests a job can run.
+const (
+	// JobTestFull is the full test, as run on schedule
+	JobTestFull = "full"
+	// JobTestDownload and JobTestUpload transfer data in one direction
+	// for the job's duration, probing latency under load
+	JobTestDownload = "download"
+	JobTestUpload   = "upload"
+	// JobTestLatency probes latency on an idle connection for the job's
+	// duration
+	JobTestLatency = "latency"
+)
+
+// JobTests are the tests a job can run.
+var JobTests = []string{JobTestFull, JobTestDownload, JobTestUpload, JobTestLatency}
+
+// States of a job's task on one agent.
+const (
+	// TaskQueued is waiting for the agent to poll
+	TaskQueued = "queued"
+	// TaskDispatched has been handed to the agent
+	TaskDispatched = "dispatched"
+	// TaskRunning has been reported started by the agent
+	TaskRunning = "running"
+	// TaskDone has its result stored
+	TaskDone = "done"
+	// TaskFailed was reported failed by the agent
+	TaskFailed = "failed"
+	// TaskExpired was not picked up, or not reported back, in time
+	TaskExpired = "expired"
+	// TaskCanceled was canceled before the agent picked it up
+	TaskCanceled = "canceled"
+)
+
+// JobSpec says what test a job runs.
+type JobSpec struct {
+	// Node is the base URL of the node to test against; empty means the
+	// node that dispatched the job
+	Node string `json:"node,omitempty"`
+	// Test is one of JobTests
+	Test string `json:"test"`
+	// Profile names the transfer size of a download or upload test, e.g.
+	// "25MB" (see speedtest.DownloadProfiles)
+	Profile string `json:"profile,omitempty"`
+	// Duration is how long a download, upload or latency test runs, e.g.
+	// "30s"
+	Duration string `json:"duration,omitempty"`
+}
+
+// AgentHeartbeat is the request body for /api/agents/heartbeat.
+type AgentHeartbeat struct {
+	// Labels describe the agent for selecting it in jobs, e.g.
+	// {"region": "europe"}
+	Labels  map[string]string `json:"labels,omitempty"`
+	Version string            `json:"version,omitempty"`
+	// Nodes are the nodes the agent tests on its schedule
+	Nodes []string `json:"nodes,omitempty"`
+	// Schedule is the agent's schedule
+	Schedule string `json:"schedule,omitempty"`
+}
+
+// AgentJob is a job handed to an agent by /api/agents/jobs.
+type AgentJob struct {
+	ID   string  `json:"id"`
+	Spec JobSpec `json:"spec"`
+	// Deadline is when the task expires unless a result or failure has
+	// been reported
+	Deadline time.Time `json:"deadline"`
+}
+
+// JobReport is the request body for /api/agents/jobs/{id}/status, sent by
+// the agent when it starts a job and when the job fails. Success is
+// reported by pushing the result with the job's ID.
+type JobReport struct {
+	// State is TaskRunning or TaskFailed
+	State string `json:"state"`
+	Error string `json:"error,omitempty"`
+}
+
+// AgentStatus is an agent as listed by /api/admin/agents.
+type AgentStatus struct {
+	ID       string            `json:"id"`
+	Labels   map[string]string `json:"labels"`
+	Version  string            `json:"version,omitempty"`
+	Nodes    []string          `json:"nodes,omitempty"`
+	Schedule string            `json:"schedule,omitempty"`
+	// Client is what the node knew about the agent's connection on its
+	// last contact. It and the times are left out for an agent that has a
+	// key but has not been in touch yet.
+	Client        *ClientMeta `json:"client,omitempty"`
+	FirstSeen     *time.Time  `json:"firstSeen,omitempty"`
+	LastSeen      *time.Time  `json:"lastSeen,omitempty"`
+	LastHeartbeat *time.Time  `json:"lastHeartbeat,omitempty"`
+	// Online is set if the agent was seen recently; Polling if it is
+	// waiting for a job right now
+	Online  bool `json:"online"`
+	Polling bool `json:"polling"`
+	// QueuedJobs counts the tasks waiting for the agent to poll
+	QueuedJobs int `json:"queuedJobs"`
+}
+
+// JobRequest is the request body for POST /api/admin/jobs. The job runs on
+// every agent listed in Agents and every agent whose labels include all of
+// Labels; at least one of them must be given.
+type JobRequest struct {
+	JobSpec
+	Agents []string          `json:"agents,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Job is a test dispatched to a set of agents, as served by
+// /api/admin/jobs.
+type Job struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	Spec      JobSpec   `json:"spec"`
+	// Labels is the selector the job was created with
+	Labels map[string]string `json:"labels,omitempty"`
+	Tasks  []JobTask         `json:"tasks"`
+}
+
+// JobTask is a job on one agent.
+type JobTask struct {
+	Agent string `json:"agent"`
+	// State is one of the Task constants
+	State        string     `json:"state"`
+	DispatchedAt *time.Time `json:"dispatchedAt,omitempty"`
+	FinishedAt   *time.Time `json:"finishedAt,omitempty"`
+	// ResultID is the stored result, once done
+	ResultID string `json:"resultId,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// AgentList is the response for GET /api/admin/agents.
+type AgentList struct {
+	Agents []AgentStatus `json:"agents"`
+}
+
+// JobList is the response for GET /api/admin/jobs.
+type JobList struct {
+	Jobs []Job `json:"jobs"`
+}
@@ -0,0 +1,166 @@
+// Package api defines the JSON bodies of the netspeedd HTTP API. The server
+// and the speedtest client both use these types, so a Go program talking
+// to a node does not need its own copies.
+package api
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+// ClientMeta is what a node knows about the client, as served by /meta.
+type ClientMeta struct {
+	Hostname     string  `json:"hostname"`
+	ClientIP     string  `json:"clientIp"`
+	HTTPProtocol string  `json:"httpProtocol"`
+	ASN          int     `json:"asn"`
+	ASOrg        string  `json:"asOrganization"`
+	Colo         string  `json:"colo"`
+	Country      string  `json:"country"`
+	City         string  `json:"city"`
+	Region       string  `json:"region"`
+	PostalCode   string  `json:"postalCode"`
+	Latitude     float64 `json:"latitude"`
+	Longitude    float64 `json:"longitude"`
+	Timezone     string  `json:"timezone,omitempty"`
+}
+
+// Location is a test server location / data center, as listed by
+// /locations. The first six fields are the shape speed.cloudflare.com
+// serves; the others are optional and left out when unset.
+type Location struct {
+	IATA   string  `json:"iata"`
+	Lat    float64 `json:"lat"`
+	Lon    float64 `json:"lon"`
+	CCA2   string  `json:"cca2"`
+	Region string  `json:"region"`
+	City   string  `json:"city"`
+	// URL is the base URL of the location's node
+	URL string `json:"url,omitempty"`
+	// IPv4Host and IPv6Host name the node over one address family only,
+	// to test each separately
+	IPv4Host string `json:"ipv4Host,omitempty"`
+	IPv6Host string `json:"ipv6Host,omitempty"`
+	// Tests lists the tests the node serves, of the LocationTest
+	// constants; empty means download, upload and packet loss
+	Tests []string `json:"tests,omitempty"`
+	// Capacity is the capacity class of the node, one of the Capacity
+	// constants
+	Capacity string   `json:"capacity,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	// Status is one of the Location status constants; empty means active
+	Status string `json:"status,omitempty"`
+}
+
+// Tests a location can serve.
+const (
+	LocationTestDownload   = "download"
+	LocationTestUpload     = "upload"
+	LocationTestPacketLoss = "packetLoss"
+	LocationTestHTTP3      = "http3"
+)
+
+// Capacity classes of a location.
+const (
+	CapacitySmall  = "small"
+	CapacityMedium = "medium"
+	CapacityLarge  = "large"
+)
+
+// Location statuses. A location in maintenance is listed but should not be
+// tested; a disabled one is not listed.
+const (
+	LocationActive      = "active"
+	LocationMaintenance = "maintenance"
+	LocationDisabled    = "disabled"
+)
+
+// Supports reports whether the location serves test.
+func (l *Location) Supports(test string) bool {
+	if len(l.Tests) == 0 {
+		return test != LocationTestHTTP3
+	}
+	return slices.Contains(l.Tests, test)
+}
+
+// Available reports whether the location can be tested: it is neither in
+// maintenance nor disabled.
+func (l *Location) Available() bool {
+	return l.Status == "" || l.Status == LocationActive
+}
+
+// TestTokenResponse is the response for POST /api/tests.
+type TestTokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	TTLSec    int64     `json:"ttlSec"`
+	Bytes     int64     `json:"bytes"`
+	Algorithm string    `json:"algorithm"`
+}
+
+// TurnCredentialsResponse is the response for /api/turn/credentials.
+type TurnCredentialsResponse struct {
+	Username   string   `json:"username"`
+	Credential string   `json:"credential"`
+	TTLSec     int64    `json:"ttlSec"`
+	Servers    []string `json:"servers"`
+	Realm      string   `json:"realm"`
+}
+
+// PacketTestOfferRequest is the request body for /api/packet-test/offer.
+type PacketTestOfferRequest struct {
+	SDP         string `json:"sdp"`
+	Type        string `json:"type"`
+	TestProfile string `json:"testProfile,omitempty"`
+}
+
+// PacketTestOfferResponse is the response for /api/packet-test/offer.
+type PacketTestOfferResponse struct {
+	SDP    string `json:"sdp"`
+	Type   string `json:"type"`
+	TestID string `json:"testId"`
+}
+
+// PacketTestReportRequest is the request body for /api/packet-test/report.
+type PacketTestReportRequest struct {
+	TestID            string  `json:"testId"`
+	Sent              int     `json:"sent"`
+	Received          int     `json:"received"`
+	LossPercent       float64 `json:"lossPercent"`
+	RTTMin            float64 `json:"rttMinMs"`
+	RTTMedian         float64 `json:"rttMedianMs"`
+	RTTP90            float64 `json:"rttP90Ms"`
+	JitterMs          float64 `json:"jitterMs"`
+	TurnServer        string  `json:"turnServer,omitempty"`
+	TransportProtocol string  `json:"transportProtocol,omitempty"`
+}
+
+// IngestRequest is the request body for /api/results/ingest: a result a
+// monitoring agent measured against a node and pushes, possibly much
+// later, to the node that stores results.
+type IngestRequest struct {
+	// ID is chosen by the agent, unique among its pushes; a push repeated
+	// with the same ID is stored only once
+	ID string `json:"id"`
+	// Node is the base URL of the node the test ran against
+	Node string `json:"node,omitempty"`
+	// Colo is the colo that node reported; the receiving node's own colo
+	// is recorded if it is empty
+	Colo string `json:"colo,omitempty"`
+	// MeasuredAt is when the test started
+	MeasuredAt time.Time `json:"measuredAt"`
+	// Job is the ID of the job the test ran for, empty for scheduled tests
+	Job string `json:"job,omitempty"`
+	// Result is the test result in the web UI's export format
+	Result json.RawMessage `json:"result"`
+}
+
+// IngestResponse is the response for /api/results/ingest.
+type IngestResponse struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	Agent     string    `json:"agent"`
+	// Duplicate is set if the push had been stored before
+	Duplicate bool `json:"duplicate,omitempty"`
+}
@@ -0,0 +1,54 @@
+package api
+
+// NearestLocation is a location with its great-circle distance from the
+// client.
+type NearestLocation struct {
+	Location
+	DistanceKm float64 `json:"distanceKm"`
+}
+
+// NearestResponse is the response for /api/locations/nearest: the
+// available locations, nearest first.
+type NearestResponse struct {
+	// Latitude and Longitude are the client position the locations are
+	// ranked from
+	Latitude  float64           `json:"latitude"`
+	Longitude float64           `json:"longitude"`
+	Locations []NearestLocation `json:"locations"`
+}
+
+// SelectCandidate is a node a client may test against.
+type SelectCandidate struct {
+	IATA string `json:"iata"`
+	City string `json:"city"`
+	// URL is the base URL of the node
+	URL string `json:"url"`
+	// ProbeURL answers latency probes with an empty response; it needs
+	// no test token and is not rate limited
+	ProbeURL string `json:"probeUrl"`
+	// DistanceKm is left out if the client's position is unknown
+	DistanceKm *float64 `json:"distanceKm,omitempty"`
+}
+
+// ProbePlan tells a client how to choose between candidates: send Probes
+// GET requests to each candidate's probe URL, Interval apart, drop the
+// first Discard, which pay for connection setup, and take the candidate
+// with the lowest median round trip. Probes that take longer than
+// TimeoutMs count as lost; a candidate with every probe lost is out.
+type ProbePlan struct {
+	Probes     int `json:"probes"`
+	Discard    int `json:"discard"`
+	IntervalMs int `json:"intervalMs"`
+	TimeoutMs  int `json:"timeoutMs"`
+}
+
+// SelectResponse is the response for /api/locations/select.
+type SelectResponse struct {
+	// Candidates are ordered by distance when the client's position is
+	// known, and as listed otherwise
+	Candidates []SelectCandidate `json:"candidates"`
+	// Recommended is the IATA code of the first candidate, to use without
+	// probing
+	Recommended string    `json:"recommended"`
+	Plan        ProbePlan `json:"plan"`
+}
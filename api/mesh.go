@@ -0,0 +1,49 @@
+package api
+
+import "time"
+
+// MeshLink is one measurement from a node to a peer.
+type MeshLink struct {
+	// From and To are the IATA codes of the measuring node and the peer
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+	MeasuredAt time.Time `json:"measuredAt"`
+	// LatencyMs and JitterMs are measured on an idle connection
+	LatencyMs float64 `json:"latencyMs"`
+	JitterMs  float64 `json:"jitterMs"`
+	// The throughput fields are left out when the daily byte budget was
+	// spent and only latency was measured
+	DownloadMbps      *float64 `json:"downloadMbps,omitempty"`
+	UploadMbps        *float64 `json:"uploadMbps,omitempty"`
+	LatencyDownloadMs *float64 `json:"latencyDownloadMs,omitempty"`
+	LatencyUploadMs   *float64 `json:"latencyUploadMs,omitempty"`
+	// PacketLossPercent is left out unless the packet loss test ran
+	PacketLossPercent *float64 `json:"packetLossPercent,omitempty"`
+	// Bytes is what the measurement transferred
+	Bytes int64 `json:"bytes"`
+	// Error is set if the peer could not be measured; the other fields
+	// hold what was measured before it failed
+	Error string `json:"error,omitempty"`
+}
+
+// MeshNode is a node's own row of the mesh, as served by /api/mesh/node.
+type MeshNode struct {
+	Colo string `json:"colo"`
+	// Links holds the latest measurement to each peer, ordered by peer
+	Links []MeshLink `json:"links"`
+}
+
+// MeshResponse is the response for /api/mesh: the latest measurement
+// between every pair of nodes. Matrix[i][j] is the link from Nodes[i] to
+// Nodes[j], null if there is none.
+type MeshResponse struct {
+	Nodes  []string      `json:"nodes"`
+	Matrix [][]*MeshLink `json:"matrix"`
+	// Unreachable lists the peers whose own rows could not be fetched
+	Unreachable []string `json:"unreachable,omitempty"`
+}
+
+// MeshHistoryResponse is the response for /api/mesh/history.
+type MeshHistoryResponse struct {
+	Links []MeshLink `json:"links"`
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/yellowman/netspeed/internal/agent"
+	"github.com/yellowman/netspeed/speedtest"
+)
+
+// runAgent implements "netspeed agent": it tests one or more nodes on a
+// schedule and pushes the results to a node's /api/results/ingest.
+func runAgent(args []string) int {
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	var nodes []string
+	fs.Func("server", "netspeedd server URL to test against (repeatable, or comma-separated)", func(v string) error {
+		for _, n := range strings.Split(v, ",") {
+			if n = strings.TrimSpace(n); n != "" {
+				nodes = append(nodes, n)
+			}
+		}
+		return nil
+	})
+	labels := map[string]string{}
+	fs.Func("label", "Label the agent for selecting it in jobs, as key=value (repeatable)", func(v string) error {
+		k, val, ok := strings.Cut(v, "=")
+		if !ok || k == "" {
+			return fmt.Errorf("label %q is not key=value", v)
+		}
+		labels[k] = val
+		return nil
+	})
+	push := fs.String("push", "", "netspeedd server URL to push results to (default the first -server)")
+	keyFile := fs.String("key-file", "", "File holding the agent key (default $NETSPEED_AGENT_KEY)")
+	schedule := fs.String("schedule", "*/30 * * * *", "When to run: a cron expression, @hourly, @daily or @every <duration>")
+	jitter := fs.Duration("jitter", 5*time.Minute, "Start each run up to this much later, at random")
+	spoolDir := fs.String("spool", defaultSpoolDir(), "Directory for results not pushed yet")
+	spoolMax := fs.Int("spool-max", 10000, "Most results to keep in the spool; the oldest are dropped (0 for no limit)")
+	noPacketLoss := fs.Bool("no-packet-loss", false, "Skip the WebRTC packet loss test")
+	testTimeout := fs.Duration("timeout", 2*time.Minute, "Give up on one test after this long")
+	once := fs.Bool("once", false, "Run the tests once, push and exit")
+	noJobs := fs.Bool("no-jobs", false, "Do not poll the push server for jobs")
+	verbose := fs.Bool("v", false, "Log debug messages")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "netspeed agent - Run the speed test on a schedule and push the results\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: netspeed agent -server URL [options]\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nResults are spooled to disk first and pushed oldest first, so nothing is\n")
+		fmt.Fprintf(os.Stderr, "lost while the push server is unreachable. Between runs the agent sends\n")
+		fmt.Fprintf(os.Stderr, "heartbeats to the push server and runs the jobs it dispatches.\n")
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() > 0 || len(nodes) == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	level := slog.LevelInfo
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	key := os.Getenv("NETSPEED_AGENT_KEY")
+	if *keyFile != "" {
+		data, err := os.ReadFile(*keyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "agent: %v\n", err)
+			return exitError
+		}
+		key = strings.TrimSpace(string(data))
+	}
+	if key == "" {
+		fmt.Fprintf(os.Stderr, "agent: no agent key (use -key-file or set NETSPEED_AGENT_KEY)\n")
+		return exitUsage
+	}
+
+	sched, err := agent.ParseSchedule(*schedule)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "agent: %v\n", err)
+		return exitUsage
+	}
+	pushURL := *push
+	if pushURL == "" {
+		pushURL = nodes[0]
+	}
+	ingest, err := speedtest.NewClient(pushURL, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "agent: %v\n", err)
+		return exitUsage
+	}
+	spool, err := agent.OpenSpool(*spoolDir, *spoolMax)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "agent: %v\n", err)
+		return exitError
+	}
+
+	cfg := speedtest.DefaultConfig()
+	cfg.SkipPacketLoss = *noPacketLoss
+	a, err := agent.New(agent.Config{
+		Nodes:        nodes,
+		Ingest:       ingest,
+		Key:          key,
+		Schedule:     sched,
+		Jitter:       *jitter,
+		Spool:        spool,
+		Test:         cfg,
+		Timeout:      *testTimeout,
+		Jobs:         !*noJobs,
+		Labels:       labels,
+		Version:      version,
+		ScheduleSpec: *schedule,
+		Logger:       logger,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "agent: %v\n", err)
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *once {
+		if pending, err := a.RunOnce(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "agent: %d results left in %s: %v\n", pending, spool.Dir(), err)
+			return exitError
+		}
+		return exitOK
+	}
+	logger.Info("Agent started", "nodes", len(nodes), "push", ingest.URL(), "schedule", *schedule, "spool", spool.Dir(), "jobs", !*noJobs)
+	if err := a.Run(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "agent: %v\n", err)
+		return exitError
+	}
+	logger.Info("Agent stopped")
+	return exitOK
+}
+
+// defaultSpoolDir is the spool under the user's cache directory, or in
+// the working directory if there is none.
+func defaultSpoolDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "netspeed-agent"
+	}
+	return filepath.Join(dir, "netspeed", "agent")
+}
@@ -0,0 +1,205 @@
+// netspeed runs the netspeedd speed test from the command line: the same
+// measurements as the web UI, for servers, routers and scripts without a
+// browser.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/yellowman/netspeed/speedtest"
+)
+
+var (
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
+)
+
+// Exit codes
+const (
+	exitOK        = 0
+	exitError     = 1
+	exitUsage     = 2
+	exitThreshold = 3
+)
+
+// Command-line flags
+var (
+	serverURL    = flag.String("server", "http://localhost:8080", "netspeedd server URL")
+	jsonOutput   = flag.Bool("json", false, "Print the full result as JSON (the web UI's export format)")
+	noPacketLoss = flag.Bool("no-packet-loss", false, "Skip the WebRTC packet loss test")
+	minDownload  = flag.Float64("min-download", 0, "Fail if download is below this many Mbps")
+	minUpload    = flag.Float64("min-upload", 0, "Fail if upload is below this many Mbps")
+	maxLatency   = flag.Float64("max-latency", 0, "Fail if unloaded latency is above this many ms")
+	maxJitter    = flag.Float64("max-jitter", 0, "Fail if jitter is above this many ms")
+	maxLoss      = flag.Float64("max-loss", 0, "Fail if packet loss is above this percentage, or could not be measured")
+	timeout      = flag.Duration("timeout", 2*time.Minute, "Give up on the test after this long")
+	verbose      = flag.Bool("v", false, "Print progress to stderr")
+	showVersion  = flag.Bool("version", false, "Show version information")
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "agent" {
+		os.Exit(runAgent(os.Args[2:]))
+	}
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "netspeed - Command-line speed test client for netspeedd\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: netspeed [options]\n")
+		fmt.Fprintf(os.Stderr, "       netspeed agent [options]   (see netspeed agent -h)\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nThresholds are off unless set. Exit status is %d on success, %d if the test\n", exitOK, exitError)
+		fmt.Fprintf(os.Stderr, "failed, %d for bad usage and %d if a threshold was missed.\n", exitUsage, exitThreshold)
+	}
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Printf("netspeed version %s (commit: %s, built: %s)\n", version, commit, date)
+		os.Exit(exitOK)
+	}
+	if flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+
+	client, err := speedtest.NewClient(*serverURL, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "netspeed: %v\n", err)
+		os.Exit(exitUsage)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	cfg := speedtest.DefaultConfig()
+	cfg.SkipPacketLoss = *noPacketLoss
+	if *verbose {
+		cfg.Progress = progress()
+	}
+
+	res, err := client.Run(ctx, cfg)
+	if err != nil {
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			err = fmt.Errorf("test did not finish within %s", *timeout)
+		case errors.Is(err, context.Canceled):
+			err = errors.New("interrupted")
+		}
+		fmt.Fprintf(os.Stderr, "netspeed: %v\n", err)
+		os.Exit(exitError)
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(res)
+	} else {
+		printSummary(client.URL(), res)
+	}
+
+	if missed := checkThresholds(res); len(missed) > 0 {
+		for _, m := range missed {
+			fmt.Fprintf(os.Stderr, "netspeed: %s\n", m)
+		}
+		os.Exit(exitThreshold)
+	}
+}
+
+// progress reports each stage and measurement on stderr.
+func progress() speedtest.Progress {
+	return speedtest.Progress{
+		Stage: func(stage string) {
+			fmt.Fprintf(os.Stderr, "== %s\n", stage)
+		},
+		Throughput: func(s speedtest.ThroughputSample) {
+			fmt.Fprintf(os.Stderr, "%-8s %-6s run %d: %8.1f Mbps (%.0f ms)\n", s.Direction, s.Profile, s.RunIndex+1, s.Mbps, s.DurationMs)
+		},
+		Latency: func(s speedtest.LatencySample) {
+			fmt.Fprintf(os.Stderr, "latency  %-8s %.1f ms\n", s.Phase, s.RTTMs)
+		},
+		PacketLoss: func(sent, acked int) {
+			if sent%100 == 0 {
+				fmt.Fprintf(os.Stderr, "packets  %d sent, %d acked\n", sent, acked)
+			}
+		},
+	}
+}
+
+// printSummary prints the headline numbers for a person to read.
+func printSummary(server string, res *speedtest.Result) {
+	s, m := res.Summary, res.Meta
+	where := server
+	if m.Colo != "" {
+		where += " (" + m.Colo + ")"
+	}
+	client := m.ClientIP
+	if m.ASN != 0 {
+		client += fmt.Sprintf(", AS%d %s", m.ASN, m.ASOrg)
+	}
+	if m.Country != "" {
+		client += " (" + m.Country + ")"
+	}
+
+	fmt.Printf("Server:    %s\n", where)
+	fmt.Printf("Client:    %s\n", client)
+	fmt.Printf("Download:  %.1f Mbps\n", s.DownloadMbps)
+	fmt.Printf("Upload:    %.1f Mbps\n", s.UploadMbps)
+	fmt.Printf("Latency:   %.1f ms idle, %.1f ms downloading, %.1f ms uploading\n", s.LatencyUnloadedMs, s.LatencyDownloadMs, s.LatencyUploadMs)
+	fmt.Printf("Jitter:    %.1f ms\n", s.JitterMs)
+	switch pl := res.PacketLoss; {
+	case pl == nil:
+		fmt.Printf("Loss:      not tested\n")
+	case pl.Unavailable:
+		fmt.Printf("Loss:      unavailable (%s)\n", pl.Reason)
+	default:
+		fmt.Printf("Loss:      %.2f%% (%d/%d packets)\n", pl.LossPercent, pl.Sent-pl.Received, pl.Sent)
+	}
+	q := res.Quality
+	fmt.Printf("Quality:   streaming %s, gaming %s, video chat %s\n", q.VideoStreaming, q.Gaming, q.VideoChatting)
+}
+
+// checkThresholds returns a message for each threshold res misses.
+func checkThresholds(res *speedtest.Result) []string {
+	s := res.Summary
+	var missed []string
+	if *minDownload > 0 && s.DownloadMbps < *minDownload {
+		missed = append(missed, fmt.Sprintf("download %.1f Mbps is below %g Mbps", s.DownloadMbps, *minDownload))
+	}
+	if *minUpload > 0 && s.UploadMbps < *minUpload {
+		missed = append(missed, fmt.Sprintf("upload %.1f Mbps is below %g Mbps", s.UploadMbps, *minUpload))
+	}
+	if *maxLatency > 0 && s.LatencyUnloadedMs > *maxLatency {
+		missed = append(missed, fmt.Sprintf("latency %.1f ms is above %g ms", s.LatencyUnloadedMs, *maxLatency))
+	}
+	if *maxJitter > 0 && s.JitterMs > *maxJitter {
+		missed = append(missed, fmt.Sprintf("jitter %.1f ms is above %g ms", s.JitterMs, *maxJitter))
+	}
+	lossSet := false
+	flag.Visit(func(f *flag.Flag) { lossSet = lossSet || f.Name == "max-loss" })
+	if lossSet {
+		switch pl := res.PacketLoss; {
+		case pl == nil:
+			missed = append(missed, "packet loss was not tested (-no-packet-loss)")
+		case pl.Unavailable:
+			missed = append(missed, "packet loss could not be measured: "+strings.TrimSpace(pl.Reason))
+		case pl.LossPercent > *maxLoss:
+			missed = append(missed, fmt.Sprintf("packet loss %.2f%% is above %g%%", pl.LossPercent, *maxLoss))
+		}
+	}
+	return missed
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/yellowman/netspeed/internal/config"
+	"github.com/yellowman/netspeed/internal/results"
+)
+
+// runExport implements "netspeedd export": it writes stored results to a
+// file or stdout without starting the server. bbolt allows one process per
+// database, so it cannot run next to a netspeedd that has the file open;
+// use GET /api/results/export there instead.
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	configFile := fs.String("config", "", "YAML config file to read results_db from")
+	db := fs.String("db", "", "Results database file (default results_db from the config)")
+	format := fs.String("format", results.FormatCSV, "Output format: "+strings.Join(results.Formats, ", "))
+	output := fs.String("o", "", "Output file (default stdout)")
+	filter := url.Values{}
+	for _, name := range []string{"from", "to", "asn", "country", "colo", "prefix", "agent", "job"} {
+		fs.Func(name, exportFilterUsage[name], func(v string) error {
+			filter.Set(name, v)
+			return nil
+		})
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "netspeedd export - Write stored test results as CSV, NDJSON or Parquet\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: netspeedd export [options]\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if !results.ValidFormat(*format) {
+		fmt.Fprintf(os.Stderr, "export: unknown format %q (want one of %s)\n", *format, strings.Join(results.Formats, ", "))
+		return 2
+	}
+	f, err := results.ParseFilter(filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export: %v\n", err)
+		return 2
+	}
+
+	path := *db
+	if path == "" {
+		cfgPath := *configFile
+		if cfgPath == "" {
+			cfgPath = os.Getenv("NETSPEEDD_CONFIG")
+		}
+		cfg, err := config.Load(cfgPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "export: %v\n", err)
+			return 1
+		}
+		path = cfg.ResultsDB
+	}
+	if path == "" {
+		fmt.Fprintf(os.Stderr, "export: no results database (use -db or set results_db)\n")
+		return 2
+	}
+
+	store, err := results.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export: %v (is netspeedd running? use /api/results/export instead)\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	var out io.Writer = os.Stdout
+	var file *os.File
+	if *output != "" && *output != "-" {
+		if file, err = os.Create(*output); err != nil {
+			fmt.Fprintf(os.Stderr, "export: %v\n", err)
+			return 1
+		}
+		out = file
+	}
+	bw := bufio.NewWriter(out)
+
+	n, err := store.Export(bw, f, *format)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if file != nil {
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export: %v\n", err)
+		return 1
+	}
+	if file != nil {
+		fmt.Fprintf(os.Stderr, "Exported %d results to %s\n", n, *output)
+	}
+	return 0
+}
+
+// exportFilterUsage describes the filter flags, which take the same values
+// as the query parameters of /api/results.
+var exportFilterUsage = map[string]string{
+	"from":    "Only results created at or after this time (RFC 3339 or YYYY-MM-DD)",
+	"to":      "Only results created before this time (RFC 3339 or YYYY-MM-DD)",
+	"asn":     "Only results from this client ASN",
+	"country": "Only results from this client country (two-letter code)",
+	"colo":    "Only results from this colo",
+	"prefix":  "Only results from clients in this CIDR prefix",
+	"agent":   "Only results pushed by this monitoring agent",
+	"job":     "Only results pushed for this agent job",
+}
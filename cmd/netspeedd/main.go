@@ -6,16 +6,19 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/yellowman/netspeed/internal/config"
+	"github.com/yellowman/netspeed/internal/logging"
 	"github.com/yellowman/netspeed/internal/server"
+	"github.com/yellowman/netspeed/internal/tracing"
 	turnserver "github.com/yellowman/netspeed/internal/turn"
 )
 
@@ -25,40 +28,173 @@ var (
 	date    = "unknown"
 )
 
+var logger = logging.For(logging.Server)
+
+// Command-line flags
+var (
+	configFile       = flag.String("config", "", "Path to YAML config file")
+	listenAddr       = flag.String("listen", "", "Listen address (default :8080)")
+	tlsCert          = flag.String("tls-cert", "", "TLS certificate file path")
+	tlsKey           = flag.String("tls-key", "", "TLS key file path")
+	acmeEnabled      = flag.Bool("acme", false, "Obtain TLS certificates for -hostname via ACME")
+	acmeDirectory    = flag.String("acme-directory", "", "ACME directory URL (default Let's Encrypt)")
+	acmeEmail        = flag.String("acme-email", "", "ACME account contact email")
+	acmeCacheDir     = flag.String("acme-cache-dir", "", "Directory for ACME account and certificates (default acme-cache)")
+	acmeHTTPAddr     = flag.String("acme-http-addr", ":80", "Address for ACME HTTP-01 challenges (empty disables)")
+	acmeCAFile       = flag.String("acme-ca-file", "", "Extra root CA for the ACME directory (e.g. Pebble)")
+	enableH2C        = flag.Bool("h2c", true, "Accept cleartext HTTP/2 on the plain listener")
+	http1Listen      = flag.String("http1-listen", "", "Extra listen address that only speaks HTTP/1.1")
+	http2Listen      = flag.String("http2-listen", "", "Extra listen address that only speaks HTTP/2")
+	tcpNoDelay       = flag.Bool("tcp-nodelay", true, "Disable Nagle's algorithm on accepted connections")
+	tcpCongestion    = flag.String("tcp-congestion", "", "Default TCP congestion control, e.g. cubic or bbr (Linux)")
+	tcpCCAllowed     = flag.String("tcp-congestion-allowed", "", "Congestion control algorithms clients may pick with cc= (comma-separated)")
+	tcpInfoInterval  = flag.Duration("tcp-info-interval", 100*time.Millisecond, "TCP_INFO sampling interval during transfers, 0 disables (Linux)")
+	http3Enabled     = flag.Bool("http3", false, "Enable HTTP/3 (QUIC) listener (requires TLS)")
+	http3Addr        = flag.String("http3-addr", "", "UDP address for HTTP/3 (default first TLS listener)")
+	maxBytes         = flag.Int64("max-bytes", 0, "Maximum bytes for download/upload (default 1GiB)")
+	locationsFile    = flag.String("locations", "", "Path to locations JSON file")
+	geoipDB          = flag.String("geoip-db", "", "Path to MaxMind GeoLite2-ASN.mmdb file")
+	hostname         = flag.String("hostname", "", "Hostname to return in /meta")
+	colo             = flag.String("colo", "", "Server colo/datacenter IATA code")
+	trustProxy       = flag.Bool("trust-proxy", false, "Trust X-Forwarded-For headers")
+	enableCORS       = flag.Bool("cors", true, "Enable CORS headers")
+	corsOrigins      = flag.String("cors-origins", "*", "Allowed CORS origins (comma-separated)")
+	serverTiming     = flag.Bool("server-timing", true, "Enable Server-Timing headers")
+	turnSecret       = flag.String("turn-secret", "", "TURN server shared secret")
+	turnServers      = flag.String("turn-servers", "", "TURN servers (comma-separated)")
+	turnRealm        = flag.String("turn-realm", "", "TURN realm")
+	embeddedTurn     = flag.Bool("embedded-turn", true, "Enable embedded TURN server")
+	embeddedTurnAddr = flag.String("embedded-turn-addr", "", "Embedded TURN server address (default 0.0.0.0:3478)")
+	embeddedTurnIP   = flag.String("embedded-turn-ip", "", "Public IP for embedded TURN server")
+	rateLimit        = flag.Bool("rate-limit", false, "Enable per-client rate limits on the measurement endpoints")
+	rateLimitExempt  = flag.String("rate-limit-exempt", "", "CIDRs exempt from rate limits (comma-separated)")
+	maxTests         = flag.Int("max-concurrent-tests", 0, "Maximum concurrent download/upload transfers, 0 for no limit")
+	maxEgressMbps    = flag.Int64("max-egress-mbps", 0, "Total download bandwidth cap in Mbps, 0 for no limit")
+	maxIngressMbps   = flag.Int64("max-ingress-mbps", 0, "Total upload bandwidth cap in Mbps, 0 for no limit")
+	queueTimeout     = flag.Duration("queue-timeout", 0, "How long a transfer may wait for room before a 503, 0 rejects immediately")
+	maxQueuedTests   = flag.Int("max-queued-tests", 0, "Maximum transfers waiting for room, 0 for no limit")
+	testTokens       = flag.Bool("test-tokens", false, "Require signed tokens from POST /api/tests on the measurement endpoints")
+	metricsEnabled   = flag.Bool("metrics", false, "Serve Prometheus metrics on /metrics")
+	metricsAddr      = flag.String("metrics-addr", "", "Separate listen address for /metrics (default the main listeners)")
+	tracingEndpoint  = flag.String("tracing-endpoint", "", "OTLP/HTTP endpoint for traces, e.g. http://localhost:4318")
+	tracingRatio     = flag.Float64("tracing-sample-ratio", 1, "Fraction of requests to trace (0-1)")
+	resultsDB        = flag.String("results-db", "", "Database file for shared test results (empty disables /api/results)")
+	meshEnabled      = flag.Bool("mesh", false, "Test the other nodes of the locations store on a schedule")
+	meshURLTemplate  = flag.String("mesh-url-template", "", "Base URL of a location's node, e.g. https://{iata}.speed.example.com")
+	logFormat        = flag.String("log-format", "", "Log format: text or json (default text)")
+	webDir           = flag.String("web-dir", "", "Directory containing static web files")
+	showVersion      = flag.Bool("version", false, "Show version information")
+	listenerSpecs    listenerList
+	tcpSendBuf       sizeFlag
+	tcpRecvBuf       sizeFlag
+	logLevel         logLevelFlag
+)
+
+func init() {
+	flag.Var(&tcpSendBuf, "tcp-send-buffer", "TCP send buffer size, e.g. 8MiB (default 4MiB)")
+	flag.Var(&tcpRecvBuf, "tcp-recv-buffer", "TCP receive buffer size, e.g. 8MiB (default 4MiB)")
+	flag.Var(&logLevel, "log-level", "Log level, optionally per subsystem, e.g. info,webrtc=debug (default info)")
+	flag.Var(&listenerSpecs, "listener", "Listener spec [network://]addr[?tls=true&proto=http1|http2&sndbuf=4MiB&rcvbuf=4MiB]\n(repeatable or comma-separated; replaces -listen, -http1-listen and -http2-listen)")
+}
+
+// listenerList collects -listener flags.
+type listenerList []config.Listener
+
+func (l *listenerList) String() string {
+	specs := make([]string, len(*l))
+	for i, ln := range *l {
+		specs[i] = ln.String()
+	}
+	return strings.Join(specs, ",")
+}
+
+func (l *listenerList) Set(value string) error {
+	parsed, err := config.ParseListeners(value)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, parsed...)
+	return nil
+}
+
+// sizeFlag is a byte size flag accepting units ("8MiB").
+type sizeFlag int64
+
+func (f *sizeFlag) String() string {
+	return strconv.FormatInt(int64(*f), 10)
+}
+
+func (f *sizeFlag) Set(value string) error {
+	v, err := config.ParseSize(value)
+	if err != nil {
+		return err
+	}
+	if v <= 0 {
+		return fmt.Errorf("size must be positive")
+	}
+	*f = sizeFlag(v)
+	return nil
+}
+
+// logLevelFlag is a log level spec ("info,webrtc=debug").
+type logLevelFlag struct {
+	spec   string
+	level  *slog.Level
+	levels map[string]slog.Level
+}
+
+func (f *logLevelFlag) String() string {
+	return f.spec
+}
+
+func (f *logLevelFlag) Set(value string) error {
+	level, levels, err := config.ParseLogLevel(value)
+	if err != nil {
+		return err
+	}
+	f.spec, f.level, f.levels = value, level, levels
+	return nil
+}
+
 func main() {
-	// Define command-line flags
-	var (
-		listenAddr     = flag.String("listen", "", "Listen address (default :8080)")
-		tlsCert        = flag.String("tls-cert", "", "TLS certificate file path")
-		tlsKey         = flag.String("tls-key", "", "TLS key file path")
-		maxBytes       = flag.Int64("max-bytes", 0, "Maximum bytes for download/upload (default 1GiB)")
-		locationsFile  = flag.String("locations", "", "Path to locations JSON file")
-		geoipDB        = flag.String("geoip-db", "", "Path to MaxMind GeoLite2-ASN.mmdb file")
-		hostname       = flag.String("hostname", "", "Hostname to return in /meta")
-		colo           = flag.String("colo", "", "Server colo/datacenter IATA code")
-		trustProxy     = flag.Bool("trust-proxy", false, "Trust X-Forwarded-For headers")
-		enableCORS     = flag.Bool("cors", true, "Enable CORS headers")
-		corsOrigins    = flag.String("cors-origins", "*", "Allowed CORS origins (comma-separated)")
-		serverTiming   = flag.Bool("server-timing", true, "Enable Server-Timing headers")
-		turnSecret     = flag.String("turn-secret", "", "TURN server shared secret")
-		turnServers    = flag.String("turn-servers", "", "TURN servers (comma-separated)")
-		turnRealm      = flag.String("turn-realm", "", "TURN realm")
-		embeddedTurn   = flag.Bool("embedded-turn", true, "Enable embedded TURN server")
-		embeddedTurnAddr = flag.String("embedded-turn-addr", "", "Embedded TURN server address (default 0.0.0.0:3478)")
-		embeddedTurnIP = flag.String("embedded-turn-ip", "", "Public IP for embedded TURN server")
-		webDir         = flag.String("web-dir", "", "Directory containing static web files")
-		showVersion    = flag.Bool("version", false, "Show version information")
-	)
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		os.Exit(runExport(os.Args[2:]))
+	}
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "netspeedd - Speedtest backend server\n\n")
-		fmt.Fprintf(os.Stderr, "Usage: netspeedd [options]\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: netspeedd [options]\n")
+		fmt.Fprintf(os.Stderr, "       netspeedd export [options]   (see netspeedd export -h)\n\n")
 		fmt.Fprintf(os.Stderr, "Options:\n")
 		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nConfiguration precedence: defaults < config file < environment < flags\n")
+		fmt.Fprintf(os.Stderr, "Send SIGHUP to reload the config file, locations, GeoIP database and TLS certificate.\n")
 		fmt.Fprintf(os.Stderr, "\nEnvironment variables:\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_CONFIG          YAML config file (if -config is not given)\n")
 		fmt.Fprintf(os.Stderr, "  NETSPEEDD_LISTEN_ADDR     Listen address\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_LISTENERS       Comma-separated listener specs (see -listener)\n")
 		fmt.Fprintf(os.Stderr, "  NETSPEEDD_TLS_CERT        TLS certificate file\n")
 		fmt.Fprintf(os.Stderr, "  NETSPEEDD_TLS_KEY         TLS key file\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_ACME            Enable ACME (true/false)\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_ACME_DIRECTORY  ACME directory URL\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_ACME_EMAIL      ACME contact email\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_ACME_CACHE_DIR  ACME cache directory\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_ACME_HTTP_ADDR  ACME HTTP-01 address (empty disables)\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_ACME_CA_FILE    Extra root CA for the ACME directory\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_H2C             Accept cleartext HTTP/2 (true/false)\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_HTTP1_ADDR      HTTP/1.1-only listen address\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_HTTP2_ADDR      HTTP/2-only listen address\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_TCP_SEND_BUFFER TCP send buffer size\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_TCP_RECV_BUFFER TCP receive buffer size\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_TCP_NODELAY     Disable Nagle's algorithm (true/false)\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_TCP_CONGESTION  Default TCP congestion control\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_TCP_CONGESTION_ALLOWED Algorithms selectable with cc=\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_TCP_INFO_INTERVAL TCP_INFO sampling interval (0 disables)\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_HTTP3           Enable HTTP/3 (true/false)\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_HTTP3_ADDR      UDP address for HTTP/3\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_QUIC_UDP_BUFFER QUIC UDP socket buffer size\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_QUIC_STREAM_WINDOW QUIC max stream receive window\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_QUIC_CONN_WINDOW QUIC max connection receive window\n")
 		fmt.Fprintf(os.Stderr, "  NETSPEEDD_MAX_BYTES       Maximum bytes\n")
 		fmt.Fprintf(os.Stderr, "  NETSPEEDD_LOCATIONS_FILE  Locations JSON file\n")
 		fmt.Fprintf(os.Stderr, "  NETSPEEDD_GEOIP_DB        MaxMind GeoLite2-ASN.mmdb file\n")
@@ -75,6 +211,29 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  NETSPEEDD_EMBEDDED_TURN_ADDR Embedded TURN address\n")
 		fmt.Fprintf(os.Stderr, "  NETSPEEDD_EMBEDDED_TURN_PUBLIC_IP Public IP for TURN\n")
 		fmt.Fprintf(os.Stderr, "  NETSPEEDD_WEB_DIR         Static web files directory\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_RATE_LIMIT      Enable per-client rate limits (true/false)\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_RATE_LIMIT_EXEMPT CIDRs exempt from rate limits\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_MAX_CONCURRENT_TESTS Maximum concurrent transfers\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_MAX_EGRESS_MBPS Total download bandwidth cap (Mbps)\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_MAX_INGRESS_MBPS Total upload bandwidth cap (Mbps)\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_QUEUE_TIMEOUT   How long transfers wait for room\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_MAX_QUEUED_TESTS Maximum waiting transfers\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_TEST_TOKENS     Require signed test tokens (true/false)\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_TEST_TOKEN_SECRET HMAC secret for test tokens\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_METRICS         Serve Prometheus metrics (true/false)\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_METRICS_ADDR    Separate listen address for /metrics\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_TRACING_ENDPOINT OTLP/HTTP endpoint for traces\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_TRACING_SAMPLE_RATIO Fraction of requests to trace\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_RESULTS_DB      Database file for shared test results\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_RESULTS_API_TOKEN Bearer token for querying stored results\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_AGENT_KEYS      Monitoring agent keys (id=key,id=key)\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_ADMIN_TOKEN     Bearer token for the agent registry and jobs\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_MESH            Test the other nodes on a schedule (true/false)\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_MESH_URL_TEMPLATE Base URL of a location's node ({iata} is replaced)\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_MESH_INTERVAL   Time between mesh rounds\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_MESH_HISTORY_DB Database file for mesh measurements\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_LOG_FORMAT      Log format (text/json)\n")
+		fmt.Fprintf(os.Stderr, "  NETSPEEDD_LOG_LEVEL       Log level, optionally per subsystem\n")
 	}
 
 	flag.Parse()
@@ -90,19 +249,193 @@ func main() {
 		flagsSet[f.Name] = true
 	})
 
-	// Load config from environment, then override with flags
-	cfg := config.FromEnv()
+	// Load config from file and environment, then override with flags
+	cfgPath := *configFile
+	if cfgPath == "" {
+		cfgPath = os.Getenv("NETSPEEDD_CONFIG")
+	}
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		fatal("Failed to load config", "error", err)
+	}
 
 	// Override with command-line flags
+	applyFlags(cfg, flagsSet)
+
+	if err := server.ConfigureLogging(cfg); err != nil {
+		fatal("Failed to set up logging", "error", err)
+	}
+	if cfgPath != "" {
+		logger.Info("Loaded config", "file", cfgPath)
+	}
+
+	// Set up tracing before anything that starts spans
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Endpoint:       cfg.TracingEndpoint,
+		SampleRatio:    cfg.TracingSampleRatio,
+		ServiceVersion: version,
+		Hostname:       cfg.Hostname,
+		Colo:           cfg.Colo,
+	})
+	if err != nil {
+		fatal("Failed to set up tracing", "error", err)
+	}
+	if cfg.TracingEndpoint != "" {
+		logger.Info("Exporting traces", "endpoint", cfg.TracingEndpoint, "sampleRatio", cfg.TracingSampleRatio)
+	}
+
+	// Start embedded TURN server if enabled and no external TURN configured
+	var turnSrv *turnserver.Server
+	var publicIP string
+	if cfg.EmbeddedTurn && cfg.TurnSecret == "" && len(cfg.TurnServers) == 0 {
+		// Determine public IP for TURN server
+		publicIP = cfg.EmbeddedTurnPublicIP
+		if publicIP == "" {
+			// Try to get local IP
+			publicIP = getLocalIP()
+		}
+
+		turnCfg := turnserver.Config{
+			ListenAddr: cfg.EmbeddedTurnAddr,
+			Realm:      cfg.TurnRealm,
+			PublicIP:   publicIP,
+		}
+
+		turnSrv, err = turnserver.New(turnCfg)
+		if err != nil {
+			logger.Warn("Failed to start embedded TURN server", "error", err)
+		} else {
+			turnSrv.Start()
+			applyEmbeddedTurn(cfg, turnSrv, publicIP)
+			// If public IP is set, use static URL; otherwise handler uses request host
+			if publicIP != "" {
+				logger.Info("Embedded TURN configured", "servers", cfg.TurnServers)
+			} else {
+				logger.Info("Embedded TURN configured, URL derived from request host", "port", cfg.EmbeddedTurnPort)
+			}
+		}
+	}
+
+	// Create server
+	srv, err := server.New(cfg)
+	if err != nil {
+		fatal("Failed to create server", "error", err)
+	}
+
+	// Set up signal handling for graceful shutdown and reload
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	// Start server in a goroutine
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- srv.Run()
+	}()
+
+	// Wait for signal or error
+	for running := true; running; {
+		select {
+		case sig := <-sigChan:
+			if sig == syscall.SIGHUP {
+				logger.Info("Received signal, reloading", "signal", sig.String())
+				reload(srv, cfgPath, flagsSet, turnSrv, publicIP)
+				continue
+			}
+			logger.Info("Received signal, shutting down", "signal", sig.String())
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			if err := srv.Shutdown(ctx); err != nil {
+				logger.Error("Error during shutdown", "error", err)
+			}
+			cancel()
+			// Shutdown embedded TURN server if running
+			if turnSrv != nil {
+				if err := turnSrv.Close(); err != nil {
+					logger.Error("Error shutting down TURN server", "error", err)
+				}
+			}
+			// Flush spans still buffered for export
+			ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+			if err := shutdownTracing(ctx); err != nil {
+				logger.Error("Error flushing traces", "error", err)
+			}
+			cancel()
+			running = false
+		case err := <-errChan:
+			if err != nil {
+				fatal("Server error", "error", err)
+			}
+			running = false
+		}
+	}
+
+	logger.Info("Server stopped")
+}
+
+// applyFlags overrides cfg with every command-line flag that was set.
+func applyFlags(cfg *config.Config, flagsSet map[string]bool) {
 	if *listenAddr != "" {
 		cfg.ListenAddr = *listenAddr
 	}
+	if len(listenerSpecs) > 0 {
+		cfg.Listeners = listenerSpecs
+	}
 	if *tlsCert != "" {
 		cfg.TLSCertFile = *tlsCert
 	}
 	if *tlsKey != "" {
 		cfg.TLSKeyFile = *tlsKey
 	}
+	if flagsSet["acme"] {
+		cfg.ACME = *acmeEnabled
+	}
+	if *acmeDirectory != "" {
+		cfg.ACMEDirectoryURL = *acmeDirectory
+	}
+	if *acmeEmail != "" {
+		cfg.ACMEEmail = *acmeEmail
+	}
+	if *acmeCacheDir != "" {
+		cfg.ACMECacheDir = *acmeCacheDir
+	}
+	if flagsSet["acme-http-addr"] {
+		cfg.ACMEHTTPAddr = *acmeHTTPAddr
+	}
+	if *acmeCAFile != "" {
+		cfg.ACMECAFile = *acmeCAFile
+	}
+	if flagsSet["h2c"] {
+		cfg.EnableH2C = *enableH2C
+	}
+	if *http1Listen != "" {
+		cfg.HTTP1Addr = *http1Listen
+	}
+	if *http2Listen != "" {
+		cfg.HTTP2Addr = *http2Listen
+	}
+	if tcpSendBuf > 0 {
+		cfg.TCPSendBufSize = int64(tcpSendBuf)
+	}
+	if tcpRecvBuf > 0 {
+		cfg.TCPRecvBufSize = int64(tcpRecvBuf)
+	}
+	if flagsSet["tcp-nodelay"] {
+		cfg.TCPNoDelay = *tcpNoDelay
+	}
+	if flagsSet["tcp-congestion"] {
+		cfg.TCPCongestion = *tcpCongestion
+	}
+	if *tcpCCAllowed != "" {
+		cfg.TCPCongestionAllowed = strings.Split(*tcpCCAllowed, ",")
+	}
+	if flagsSet["tcp-info-interval"] {
+		cfg.TCPInfoInterval = *tcpInfoInterval
+	}
+	if flagsSet["http3"] {
+		cfg.HTTP3 = *http3Enabled
+	}
+	if *http3Addr != "" {
+		cfg.HTTP3Addr = *http3Addr
+	}
 	if *maxBytes > 0 {
 		cfg.MaxBytes = *maxBytes
 	}
@@ -143,6 +476,57 @@ func main() {
 	if *webDir != "" {
 		cfg.WebDir = *webDir
 	}
+	if flagsSet["rate-limit"] {
+		cfg.RateLimit.Enabled = *rateLimit
+	}
+	if *rateLimitExempt != "" {
+		cfg.RateLimit.Exempt = strings.Split(*rateLimitExempt, ",")
+	}
+	if flagsSet["max-concurrent-tests"] {
+		cfg.MaxConcurrentTests = *maxTests
+	}
+	if flagsSet["max-egress-mbps"] {
+		cfg.MaxEgressMbps = *maxEgressMbps
+	}
+	if flagsSet["max-ingress-mbps"] {
+		cfg.MaxIngressMbps = *maxIngressMbps
+	}
+	if flagsSet["queue-timeout"] {
+		cfg.QueueTimeout = *queueTimeout
+	}
+	if flagsSet["max-queued-tests"] {
+		cfg.MaxQueuedTests = *maxQueuedTests
+	}
+	if flagsSet["test-tokens"] {
+		cfg.TestTokens.Enabled = *testTokens
+	}
+	if flagsSet["metrics"] {
+		cfg.Metrics = *metricsEnabled
+	}
+	if *metricsAddr != "" {
+		cfg.MetricsAddr = *metricsAddr
+	}
+	if *tracingEndpoint != "" {
+		cfg.TracingEndpoint = *tracingEndpoint
+	}
+	if flagsSet["tracing-sample-ratio"] {
+		cfg.TracingSampleRatio = *tracingRatio
+	}
+	if *resultsDB != "" {
+		cfg.ResultsDB = *resultsDB
+	}
+	if flagsSet["mesh"] {
+		cfg.Mesh.Enabled = *meshEnabled
+	}
+	if *meshURLTemplate != "" {
+		cfg.Mesh.URLTemplate = *meshURLTemplate
+	}
+	if *logFormat != "" {
+		cfg.Log.Format = *logFormat
+	}
+	if logLevel.spec != "" {
+		cfg.Log.SetLevel(logLevel.level, logLevel.levels)
+	}
 	// Only override embedded-turn if explicitly set on command line
 	if flagsSet["embedded-turn"] {
 		cfg.EmbeddedTurn = *embeddedTurn
@@ -154,89 +538,56 @@ func main() {
 		cfg.EmbeddedTurnPublicIP = *embeddedTurnIP
 	}
 
-	// Start embedded TURN server if enabled and no external TURN configured
-	var turnSrv *turnserver.Server
-	if cfg.EmbeddedTurn && cfg.TurnSecret == "" && len(cfg.TurnServers) == 0 {
-		// Determine public IP for TURN server
-		publicIP := cfg.EmbeddedTurnPublicIP
-		if publicIP == "" {
-			// Try to get local IP
-			publicIP = getLocalIP()
-		}
-
-		turnCfg := turnserver.Config{
-			ListenAddr: cfg.EmbeddedTurnAddr,
-			Realm:      cfg.TurnRealm,
-			PublicIP:   publicIP,
-		}
+	// TLS-ALPN-01 challenges arrive on port 443, so ACME implies :443
+	// unless a listen address was chosen in any layer
+	if cfg.ACME && *listenAddr == "" && !cfg.ListenAddrSet() {
+		cfg.ListenAddr = ":443"
+	}
+}
 
-		var err error
-		turnSrv, err = turnserver.New(turnCfg)
-		if err != nil {
-			log.Printf("Warning: Failed to start embedded TURN server: %v", err)
-		} else {
-			turnSrv.Start()
-			// Configure the HTTP server to use the embedded TURN server
-			cfg.TurnSecret = turnSrv.Secret()
-			cfg.TurnRealm = turnSrv.Realm()
-			// Extract port from listen address for dynamic URL generation
-			turnAddr := turnSrv.ListenAddr()
-			_, port, _ := net.SplitHostPort(turnAddr)
-			if port == "" {
-				port = "3478"
-			}
-			cfg.EmbeddedTurnPort = port
-			// If public IP is set, use static URL; otherwise handler uses request host
-			if publicIP != "" {
-				cfg.TurnServers = []string{
-					fmt.Sprintf("stun:%s:%s", publicIP, port),
-					fmt.Sprintf("turn:%s:%s?transport=udp", publicIP, port),
-				}
-				log.Printf("Embedded TURN configured: servers=%v", cfg.TurnServers)
-			} else {
-				log.Printf("Embedded TURN configured on port %s (URL derived from request host)", port)
-			}
+// applyEmbeddedTurn points the TURN settings in cfg at the embedded TURN server.
+func applyEmbeddedTurn(cfg *config.Config, turnSrv *turnserver.Server, publicIP string) {
+	cfg.TurnSecret = turnSrv.Secret()
+	cfg.TurnRealm = turnSrv.Realm()
+	// Extract port from listen address for dynamic URL generation
+	_, port, _ := net.SplitHostPort(turnSrv.ListenAddr())
+	if port == "" {
+		port = "3478"
+	}
+	cfg.EmbeddedTurnPort = port
+	if publicIP != "" {
+		cfg.TurnServers = []string{
+			fmt.Sprintf("stun:%s:%s", publicIP, port),
+			fmt.Sprintf("turn:%s:%s?transport=udp", publicIP, port),
 		}
 	}
+}
 
-	// Create server
-	srv, err := server.New(cfg)
+// reload rebuilds the configuration from the config file, environment and
+// flags and applies it to the running server. Invalid config files are
+// rejected and the server keeps running with its current configuration.
+func reload(srv *server.Server, cfgPath string, flagsSet map[string]bool, turnSrv *turnserver.Server, turnPublicIP string) {
+	cfg, err := config.Load(cfgPath)
 	if err != nil {
-		log.Fatalf("Failed to create server: %v", err)
+		logger.Error("Reload failed, keeping current config", "error", err)
+		return
+	}
+	applyFlags(cfg, flagsSet)
+	if turnSrv != nil {
+		applyEmbeddedTurn(cfg, turnSrv, turnPublicIP)
 	}
 
-	// Set up signal handling for graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-	// Start server in a goroutine
-	errChan := make(chan error, 1)
-	go func() {
-		errChan <- srv.Run()
-	}()
-
-	// Wait for signal or error
-	select {
-	case sig := <-sigChan:
-		log.Printf("Received signal %v, shutting down...", sig)
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-		if err := srv.Shutdown(ctx); err != nil {
-			log.Printf("Error during shutdown: %v", err)
-		}
-		// Shutdown embedded TURN server if running
-		if turnSrv != nil {
-			if err := turnSrv.Close(); err != nil {
-				log.Printf("Error shutting down TURN server: %v", err)
-			}
-		}
-	case err := <-errChan:
-		if err != nil {
-			log.Fatalf("Server error: %v", err)
-		}
+	if err := srv.Reload(cfg); err != nil {
+		logger.Warn("Reload completed with errors", "error", err)
+		return
 	}
+	logger.Info("Reload complete")
+}
 
-	log.Println("Server stopped")
+// fatal logs msg as an error and exits.
+func fatal(msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
 }
 
 // getLocalIP returns the local IP address of the machine.
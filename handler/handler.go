@@ -0,0 +1,256 @@
+// Package handler mounts the netspeedd endpoints inside another Go service.
+//
+// New returns an http.Handler serving /meta, /__down, /__up, /locations,
+// the TURN and packet-test endpoints and the rest of the netspeedd API,
+// with the same rate limits and metrics as the daemon. It does not listen
+// on its own, terminate TLS or run a TURN server; the service it is mounted
+// in does that:
+//
+//	h, err := handler.New(
+//		handler.WithColo("AMS"),
+//		handler.WithMaxBytes(100<<20),
+//		handler.WithTURN("secret", "turn:turn.example.com:3478"),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	defer h.Close()
+//	mux.Handle("/speed/", http.StripPrefix("/speed", h))
+//
+// Close ends the WebRTC sessions and closes the GeoIP database opened by
+// WithGeoIP. Call it after the http.Server serving the handler has shut
+// down.
+//
+// Handlers in one process share the metrics: counters and gauges such as
+// active tests add up over all of them, and Close takes a handler's share
+// of the gauges out.
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/yellowman/netspeed/api"
+	"github.com/yellowman/netspeed/internal/config"
+	"github.com/yellowman/netspeed/internal/locations"
+	"github.com/yellowman/netspeed/internal/meta"
+	"github.com/yellowman/netspeed/internal/server"
+)
+
+// MetaProvider answers /meta: what the server knows about the client that
+// sent r.
+type MetaProvider interface {
+	MetaFor(r *http.Request) api.ClientMeta
+}
+
+// LocationStore answers /locations. The handler does not modify the
+// slice All returns, so it may be the store's own.
+type LocationStore interface {
+	All() []api.Location
+}
+
+// Locations is a fixed LocationStore.
+type Locations []api.Location
+
+// All returns the locations.
+func (l Locations) All() []api.Location {
+	return l
+}
+
+// Option configures a Handler.
+type Option func(*settings) error
+
+// settings collects the options before New builds the server from them.
+type settings struct {
+	cfg       *config.Config
+	opts      server.Options
+	geoipPath string
+}
+
+// WithMetaProvider answers /meta from p instead of the built-in provider.
+// The Handler does not close p.
+func WithMetaProvider(p MetaProvider) Option {
+	return func(s *settings) error {
+		if p == nil {
+			return errors.New("nil meta provider")
+		}
+		s.opts.MetaProvider = p
+		return nil
+	}
+}
+
+// WithGeoIP looks up client ASNs in the MaxMind database at path. The
+// Handler opens it in New and closes it in Close. It has no effect with
+// WithMetaProvider.
+func WithGeoIP(path string) Option {
+	return func(s *settings) error {
+		s.geoipPath = path
+		return nil
+	}
+}
+
+// WithHostname sets the hostname reported by /meta (default "localhost").
+func WithHostname(hostname string) Option {
+	return func(s *settings) error {
+		s.cfg.Hostname = hostname
+		return nil
+	}
+}
+
+// WithColo sets the colo code reported by /meta (default "LOCAL").
+func WithColo(colo string) Option {
+	return func(s *settings) error {
+		s.cfg.Colo = colo
+		return nil
+	}
+}
+
+// WithTrustProxy takes the client IP from X-Forwarded-For and
+// CF-Connecting-IP. Only use it behind a proxy that sets them.
+func WithTrustProxy(trust bool) Option {
+	return func(s *settings) error {
+		s.cfg.TrustProxyHeaders = trust
+		return nil
+	}
+}
+
+// WithLocations answers /locations from store instead of the built-in
+// defaults. Unlike netspeedd, the Handler does not look for a
+// locations.json in the working directory.
+func WithLocations(store LocationStore) Option {
+	return func(s *settings) error {
+		if store == nil {
+			return errors.New("nil location store")
+		}
+		s.opts.Locations = store
+		return nil
+	}
+}
+
+// WithMaxBytes caps the size of a single download or upload (default
+// 1 GiB).
+func WithMaxBytes(n int64) Option {
+	return func(s *settings) error {
+		if n <= 0 {
+			return fmt.Errorf("max bytes must be positive, got %d", n)
+		}
+		s.cfg.MaxBytes = n
+		return nil
+	}
+}
+
+// WithTURN hands out credentials for the given STUN and TURN servers,
+// signed with the shared secret they use for the TURN REST API. Without
+// it the packet-loss test only works on networks where direct WebRTC
+// connections do.
+func WithTURN(secret string, servers ...string) Option {
+	return func(s *settings) error {
+		if secret == "" || len(servers) == 0 {
+			return errors.New("TURN needs a secret and at least one server")
+		}
+		s.cfg.TurnSecret = secret
+		s.cfg.TurnServers = servers
+		return nil
+	}
+}
+
+// WithTURNRealm sets the realm of the TURN credentials (default
+// "netspeed").
+func WithTURNRealm(realm string) Option {
+	return func(s *settings) error {
+		s.cfg.TurnRealm = realm
+		return nil
+	}
+}
+
+// WithWebRTC turns the WebRTC packet-loss test on or off (default on).
+// When off, offers are answered with 503 and clients skip the test.
+func WithWebRTC(enabled bool) Option {
+	return func(s *settings) error {
+		s.opts.DisableWebRTC = !enabled
+		return nil
+	}
+}
+
+// WithCORS allows cross-origin requests from the given origins, or from
+// any origin if none are given. CORS is off by default.
+func WithCORS(origins ...string) Option {
+	return func(s *settings) error {
+		if len(origins) == 0 {
+			origins = []string{"*"}
+		}
+		s.cfg.EnableCORS = true
+		s.cfg.AllowedOrigins = origins
+		return nil
+	}
+}
+
+// Handler serves the netspeedd endpoints.
+type Handler struct {
+	srv   *server.Server
+	geoip *meta.GeoIPProvider // opened by WithGeoIP, nil otherwise
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// New returns a Handler configured by opts.
+func New(opts ...Option) (*Handler, error) {
+	cfg := config.Default()
+	cfg.EnableCORS = false
+	cfg.EmbeddedTurn = false
+	s := &settings{cfg: cfg}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, fmt.Errorf("handler: %w", err)
+		}
+	}
+
+	if s.opts.Locations == nil {
+		s.opts.Locations = locations.NewMemoryStore(locations.DefaultLocations())
+	}
+
+	h := &Handler{}
+	if s.geoipPath != "" && s.opts.MetaProvider == nil {
+		gp, err := meta.NewGeoIPProvider(s.geoipPath, cfg.Hostname, cfg.Colo, cfg.TrustProxyHeaders)
+		if err != nil {
+			return nil, fmt.Errorf("handler: GeoIP database: %w", err)
+		}
+		h.geoip = gp
+		s.opts.MetaProvider = gp
+	}
+
+	srv, err := server.NewEmbedded(cfg, s.opts)
+	if err != nil {
+		if h.geoip != nil {
+			h.geoip.Close()
+		}
+		return nil, fmt.Errorf("handler: %w", err)
+	}
+	h.srv = srv
+	return h, nil
+}
+
+// ServeHTTP serves a netspeedd request.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.srv.Handler().ServeHTTP(w, r)
+}
+
+// Close ends the WebRTC sessions, releases transfers waiting for
+// admission, unregisters the handler's gauges and closes the GeoIP
+// database opened by WithGeoIP. Requests served after Close may fail.
+// Calls after the first return the same error.
+func (h *Handler) Close() error {
+	h.closeOnce.Do(func() {
+		err := h.srv.Close()
+		if h.geoip != nil {
+			if gerr := h.geoip.Close(); gerr != nil {
+				err = errors.Join(err, fmt.Errorf("GeoIP database: %w", gerr))
+			}
+		}
+		h.closeErr = err
+	})
+	return h.closeErr
+}
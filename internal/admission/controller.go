@@ -0,0 +1,290 @@
+// Package admission caps how many measurement transfers run at once and how
+// much bandwidth they may use in total, so concurrent tests on one node do
+// not skew each other's results.
+//
+// Every /__down or /__up transfer holds a Ticket while it runs. When the
+// node is full, new transfers wait in a bounded queue for up to the queue
+// timeout, or are rejected straight away if queueing is disabled. Transfer
+// rates are measured from the bytes actually moved, sampled every
+// sampleInterval.
+package admission
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Directions a transfer can go, from the server's point of view.
+const (
+	Egress  = "egress"  // /__down
+	Ingress = "ingress" // /__up
+)
+
+// sampleInterval is how often transfer rates are recomputed.
+const sampleInterval = 250 * time.Millisecond
+
+// rateSmoothing is the weight of the newest sample in the moving average.
+const rateSmoothing = 0.5
+
+// pollInterval is how often queued transfers re-check for room.
+const pollInterval = 50 * time.Millisecond
+
+// ErrSaturated is returned by Acquire when the node is full and the request
+// could not be queued or timed out in the queue.
+var ErrSaturated = errors.New("server is saturated")
+
+// Limits configures a Controller; zero values are unlimited.
+type Limits struct {
+	MaxConcurrent int
+	MaxEgressBps  int64 // bits per second
+	MaxIngressBps int64 // bits per second
+	// QueueTimeout is how long a transfer may wait for room; 0 rejects
+	// immediately
+	QueueTimeout time.Duration
+	// MaxQueue caps the number of waiting transfers; 0 means no cap
+	MaxQueue int
+}
+
+// Load is a snapshot of the node's current load.
+type Load struct {
+	Active        int   `json:"activeTests"`
+	MaxActive     int   `json:"maxTests,omitempty"`
+	Queued        int   `json:"queuedTests"`
+	EgressBps     int64 `json:"egressBps"`
+	MaxEgressBps  int64 `json:"maxEgressBps,omitempty"`
+	IngressBps    int64 `json:"ingressBps"`
+	MaxIngressBps int64 `json:"maxIngressBps,omitempty"`
+	Saturated     bool  `json:"saturated"`
+}
+
+// Controller admits transfers and tracks load. It is safe for concurrent use.
+type Controller struct {
+	egressBytes  atomic.Int64
+	ingressBytes atomic.Int64
+
+	mu             sync.Mutex
+	limits         Limits
+	active         int
+	queued         int
+	egressBps      int64
+	ingressBps     int64
+	lastEgress     int64
+	lastIngress    int64
+	lastSample     time.Time
+	oversubscribed time.Time // last sample with the bandwidth cap hit by 2+ transfers
+	closed         bool      // set by Close; nothing is admitted after it
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New creates a Controller and starts sampling transfer rates.
+// Close stops the sampler and turns queued transfers away.
+func New(limits Limits) *Controller {
+	c := &Controller{
+		limits:     limits,
+		lastSample: time.Now(),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go c.sampleLoop()
+	return c
+}
+
+// SetLimits replaces the limits; running transfers are not affected.
+func (c *Controller) SetLimits(limits Limits) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.limits = limits
+}
+
+// Close stops the rate sampler. Queued transfers and those that arrive
+// afterwards get ErrSaturated, so that they don't hold up a shutdown.
+func (c *Controller) Close() {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	close(c.stop)
+	<-c.done
+}
+
+// Meter returns the byte counter for direction; callers add the bytes
+// they move so rates reflect actual traffic.
+func (c *Controller) Meter(direction string) *atomic.Int64 {
+	if direction == Ingress {
+		return &c.ingressBytes
+	}
+	return &c.egressBytes
+}
+
+func (c *Controller) sampleLoop() {
+	defer close(c.done)
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			c.sample(now)
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// sample updates the smoothed transfer rates.
+func (c *Controller) sample(now time.Time) {
+	egress, ingress := c.egressBytes.Load(), c.ingressBytes.Load()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	secs := now.Sub(c.lastSample).Seconds()
+	if secs <= 0 {
+		return
+	}
+	rate := func(prev, cur, last int64) int64 {
+		inst := float64(cur-last) * 8 / secs
+		return int64(rateSmoothing*inst + (1-rateSmoothing)*float64(prev))
+	}
+	c.egressBps = rate(c.egressBps, egress, c.lastEgress)
+	c.ingressBps = rate(c.ingressBps, ingress, c.lastIngress)
+	c.lastEgress, c.lastIngress, c.lastSample = egress, ingress, now
+
+	if c.active > 1 && (c.bandwidthFull(Egress) || c.bandwidthFull(Ingress)) {
+		c.oversubscribed = now
+	}
+}
+
+// bandwidthFull reports whether the cap for direction is reached.
+func (c *Controller) bandwidthFull(direction string) bool {
+	if direction == Ingress {
+		return c.limits.MaxIngressBps > 0 && c.ingressBps >= c.limits.MaxIngressBps
+	}
+	return c.limits.MaxEgressBps > 0 && c.egressBps >= c.limits.MaxEgressBps
+}
+
+// full reports whether a new transfer in direction must wait.
+func (c *Controller) full(direction string) bool {
+	if c.limits.MaxConcurrent > 0 && c.active >= c.limits.MaxConcurrent {
+		return true
+	}
+	return c.bandwidthFull(direction)
+}
+
+// tryAcquire admits a transfer if there is room.
+func (c *Controller) tryAcquire(direction string) bool {
+	if c.full(direction) {
+		return false
+	}
+	c.active++
+	return true
+}
+
+// Acquire admits a transfer in direction, waiting in the queue if the node
+// is full and queueing is enabled. It returns ErrSaturated if there is no
+// room or the Controller is closed, or the context's error if ctx ends
+// while queued.
+func (c *Controller) Acquire(ctx context.Context, direction string) (*Ticket, error) {
+	start := time.Now()
+
+	// Don't overtake transfers that are already waiting
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrSaturated
+	}
+	if c.queued == 0 && c.tryAcquire(direction) {
+		c.mu.Unlock()
+		return &Ticket{c: c, start: start}, nil
+	}
+	timeout := c.limits.QueueTimeout
+	if timeout <= 0 || (c.limits.MaxQueue > 0 && c.queued >= c.limits.MaxQueue) {
+		c.mu.Unlock()
+		return nil, ErrSaturated
+	}
+	c.queued++
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.queued--
+		c.mu.Unlock()
+	}()
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	poll := time.NewTicker(pollInterval)
+	defer poll.Stop()
+
+	for {
+		select {
+		case <-poll.C:
+			c.mu.Lock()
+			ok := c.tryAcquire(direction)
+			c.mu.Unlock()
+			if ok {
+				return &Ticket{c: c, start: start, queued: true}, nil
+			}
+		case <-deadline.C:
+			return nil, ErrSaturated
+		case <-c.stop:
+			return nil, ErrSaturated
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Load returns the current load.
+func (c *Controller) Load() Load {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Load{
+		Active:        c.active,
+		MaxActive:     c.limits.MaxConcurrent,
+		Queued:        c.queued,
+		EgressBps:     c.egressBps,
+		MaxEgressBps:  c.limits.MaxEgressBps,
+		IngressBps:    c.ingressBps,
+		MaxIngressBps: c.limits.MaxIngressBps,
+		Saturated:     c.queued > 0 || c.full(Egress) || c.full(Ingress),
+	}
+}
+
+// Ticket is an admitted transfer.
+type Ticket struct {
+	c        *Controller
+	start    time.Time
+	queued   bool
+	released atomic.Bool
+}
+
+// Queued reports whether the transfer had to wait for room.
+func (t *Ticket) Queued() bool {
+	return t.queued
+}
+
+// Constrained reports whether the transfer's result is likely skewed by
+// server load: it was queued, or the bandwidth cap was hit while it shared
+// the node with other transfers.
+func (t *Ticket) Constrained() bool {
+	if t.queued {
+		return true
+	}
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	return !t.c.oversubscribed.Before(t.start)
+}
+
+// Release frees the transfer's slot. It is safe to call more than once.
+func (t *Ticket) Release() {
+	if t.released.Swap(true) {
+		return
+	}
+	t.c.mu.Lock()
+	t.c.active--
+	t.c.mu.Unlock()
+}
@@ -0,0 +1,276 @@
+// Package agent runs the speed test on a schedule and pushes the results to
+// a netspeedd node, for monitoring a site continuously rather than testing
+// on demand.
+//
+// Each run tests every configured node in turn and writes the results to a
+// Spool on disk before pushing them to /api/results/ingest with the
+// agent's key. Whatever cannot be pushed, because the network or the
+// ingest node is down, stays in the spool and is retried with backoff and
+// after every run, oldest first.
+//
+// Between runs the agent sends the ingest node heartbeats and, if enabled,
+// long-polls it for jobs: tests an operator dispatched to it over the
+// node's admin API. A job's result is spooled and pushed like the others,
+// under the job's ID.
+package agent
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	mrand "math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/yellowman/netspeed/api"
+	"github.com/yellowman/netspeed/speedtest"
+)
+
+// Retry backoff for pushes that failed between runs.
+const (
+	minRetry = time.Minute
+	maxRetry = 30 * time.Minute
+)
+
+// Config configures an Agent.
+type Config struct {
+	// Nodes are the base URLs of the nodes to test against
+	Nodes []string
+	// Ingest is the node results are pushed to, with Key
+	Ingest *speedtest.Client
+	Key    string
+	// Schedule says when to run; each run starts up to Jitter later, so
+	// agents sharing a schedule don't all test at once
+	Schedule Schedule
+	Jitter   time.Duration
+	Spool    *Spool
+	// Test configures each test; Timeout bounds one, if non-zero
+	Test    speedtest.Config
+	Timeout time.Duration
+	// Jobs enables polling the ingest node for jobs
+	Jobs bool
+	// Labels, Version and ScheduleSpec, the schedule as given, are sent
+	// with heartbeats
+	Labels       map[string]string
+	Version      string
+	ScheduleSpec string
+	Logger       *slog.Logger
+}
+
+// Agent runs scheduled tests.
+type Agent struct {
+	cfg   Config
+	nodes []*speedtest.Client
+	log   *slog.Logger
+	// testMu keeps scheduled tests and jobs from running at once and
+	// skewing each other; flushMu serializes pushes
+	testMu  sync.Mutex
+	flushMu sync.Mutex
+}
+
+// New returns an Agent for cfg.
+func New(cfg Config) (*Agent, error) {
+	if len(cfg.Nodes) == 0 {
+		return nil, errors.New("no nodes to test")
+	}
+	if cfg.Ingest == nil || cfg.Key == "" || cfg.Schedule == nil || cfg.Spool == nil {
+		return nil, errors.New("incomplete agent config")
+	}
+	a := &Agent{cfg: cfg, log: cfg.Logger}
+	if a.log == nil {
+		a.log = slog.Default()
+	}
+	for _, node := range cfg.Nodes {
+		c, err := speedtest.NewClient(node, nil)
+		if err != nil {
+			return nil, err
+		}
+		a.nodes = append(a.nodes, c)
+	}
+	return a, nil
+}
+
+// Run pushes what is left in the spool, then tests on schedule until ctx is
+// done. Meanwhile it sends heartbeats and runs jobs.
+func (a *Agent) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		a.heartbeats(ctx)
+	}()
+	if a.cfg.Jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.pollJobs(ctx)
+		}()
+	}
+
+	pending, _ := a.Flush(ctx)
+	retry := minRetry
+	for {
+		next := a.cfg.Schedule.Next(time.Now())
+		if next.IsZero() {
+			return errors.New("schedule has no further runs")
+		}
+		if a.cfg.Jitter > 0 {
+			next = next.Add(mrand.N(a.cfg.Jitter))
+		}
+		a.log.Info("Next run scheduled", "at", next.Format(time.RFC3339), "pending", pending)
+
+		// Wait for the run, retrying pushes in the meantime
+		timer := time.NewTimer(time.Until(next))
+		for waiting := true; waiting; {
+			var retryC <-chan time.Time
+			if pending > 0 {
+				retryC = time.After(retry)
+			}
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil
+			case <-retryC:
+				var err error
+				if pending, err = a.Flush(ctx); err != nil {
+					retry = min(2*retry, maxRetry)
+				} else {
+					retry = minRetry
+				}
+			case <-timer.C:
+				waiting = false
+			}
+		}
+
+		pending, _ = a.RunOnce(ctx)
+		retry = minRetry
+	}
+}
+
+// RunOnce tests every node, then pushes. It returns the number of results
+// still in the spool and the error that stopped the push, if any.
+func (a *Agent) RunOnce(ctx context.Context) (int, error) {
+	a.testMu.Lock()
+	defer a.testMu.Unlock()
+	for _, c := range a.nodes {
+		if ctx.Err() != nil {
+			break
+		}
+		a.test(ctx, c)
+	}
+	return a.Flush(ctx)
+}
+
+// test runs one test against c and spools the result.
+func (a *Agent) test(ctx context.Context, c *speedtest.Client) {
+	if a.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.cfg.Timeout)
+		defer cancel()
+	}
+	a.log.Info("Testing", "node", c.URL())
+	res, err := c.Run(ctx, a.cfg.Test)
+	if err != nil {
+		a.log.Warn("Test failed", "node", c.URL(), "error", err)
+		return
+	}
+	a.spool(res, c.URL(), "")
+}
+
+// spool writes the result of a test against node to the spool, under job
+// if the test ran for one.
+func (a *Agent) spool(res *speedtest.Result, node, job string) error {
+	doc, err := json.Marshal(res)
+	if err != nil {
+		a.log.Error("Failed to encode result", "node", node, "error", err)
+		return err
+	}
+	push := &api.IngestRequest{
+		ID:         newPushID(),
+		Node:       node,
+		MeasuredAt: time.UnixMilli(res.StartTime).UTC(),
+		Job:        job,
+		Result:     doc,
+	}
+	if res.Meta != nil {
+		push.Colo = res.Meta.Colo
+	}
+	name, dropped, err := a.cfg.Spool.Add(push)
+	if err != nil {
+		a.log.Error("Failed to spool result", "node", node, "error", err)
+		return err
+	}
+	if len(dropped) > 0 {
+		a.log.Warn("Spool full, dropped oldest results", "dropped", len(dropped))
+	}
+	s := res.Summary
+	a.log.Info("Test finished", "node", node, "colo", push.Colo, "spooled", name,
+		"downloadMbps", s.DownloadMbps, "uploadMbps", s.UploadMbps, "latencyMs", s.LatencyUnloadedMs)
+	return nil
+}
+
+// Flush pushes the spooled results, oldest first, until one fails. Results
+// the ingest node refuses as invalid are set aside instead of blocking the
+// rest. It returns the number of results left and the error that stopped
+// it, if any.
+func (a *Agent) Flush(ctx context.Context) (int, error) {
+	a.flushMu.Lock()
+	defer a.flushMu.Unlock()
+	names, err := a.cfg.Spool.List()
+	if err != nil {
+		a.log.Error("Failed to read spool", "error", err)
+		return 0, err
+	}
+	for i, name := range names {
+		push, err := a.cfg.Spool.Load(name)
+		if err != nil {
+			a.log.Warn("Unreadable spooled result set aside", "file", name, "error", err)
+			a.cfg.Spool.Reject(name)
+			continue
+		}
+		resp, err := a.cfg.Ingest.PushResult(ctx, a.cfg.Key, *push)
+		if err != nil {
+			if rejected(err) {
+				a.log.Warn("Result refused by ingest node, set aside", "file", name, "error", err)
+				a.cfg.Spool.Reject(name)
+				continue
+			}
+			left := len(names) - i
+			a.log.Warn("Push failed, will retry", "pending", left, "error", err)
+			return left, fmt.Errorf("push failed: %w", err)
+		}
+		if err := a.cfg.Spool.Remove(name); err != nil {
+			a.log.Error("Failed to remove pushed result from spool", "file", name, "error", err)
+		}
+		a.log.Info("Result pushed", "id", resp.ID, "node", push.Node, "duplicate", resp.Duplicate)
+	}
+	return 0, nil
+}
+
+// rejected reports whether err means the ingest node will never take the
+// push, as opposed to a failure worth retrying. An unknown key is retried:
+// the fix is on the server, and the results are still good.
+func rejected(err error) bool {
+	var se *speedtest.StatusError
+	if !errors.As(err, &se) {
+		return false
+	}
+	switch se.StatusCode {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+		return true
+	}
+	return false
+}
+
+// newPushID returns a random ID for a push.
+func newPushID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,173 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/yellowman/netspeed/api"
+	"github.com/yellowman/netspeed/speedtest"
+)
+
+// Heartbeat and job polling intervals.
+const (
+	heartbeatEvery = time.Minute
+	// jobWait is how long one poll waits for a job; the node allows at
+	// most a minute
+	jobWait = 50 * time.Second
+	// minPollRetry and maxPollRetry bound the backoff after a failed poll
+	minPollRetry = 5 * time.Second
+	maxPollRetry = 5 * time.Minute
+	// jobsOffRetry is how often a node that does not dispatch jobs is
+	// asked again
+	jobsOffRetry = 10 * time.Minute
+)
+
+// heartbeats tells the ingest node the agent is up every minute until ctx
+// is done.
+func (a *Agent) heartbeats(ctx context.Context) {
+	hb := api.AgentHeartbeat{
+		Labels:   a.cfg.Labels,
+		Version:  a.cfg.Version,
+		Nodes:    a.cfg.Nodes,
+		Schedule: a.cfg.ScheduleSpec,
+	}
+	tick := time.NewTicker(heartbeatEvery)
+	defer tick.Stop()
+	failing := false
+	for {
+		err := a.cfg.Ingest.Heartbeat(ctx, a.cfg.Key, hb)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil && !failing:
+			a.log.Warn("Heartbeat failed", "error", err)
+		case err == nil && failing:
+			a.log.Info("Heartbeat sent again")
+		}
+		failing = err != nil
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// pollJobs waits for jobs from the ingest node and runs them, one at a
+// time, until ctx is done.
+func (a *Agent) pollJobs(ctx context.Context) {
+	retry := minPollRetry
+	for {
+		job, err := a.cfg.Ingest.NextJob(ctx, a.cfg.Key, jobWait)
+		wait := time.Duration(0)
+		var se *speedtest.StatusError
+		switch {
+		case ctx.Err() != nil:
+			return
+		case errors.As(err, &se) && se.StatusCode == http.StatusNotFound:
+			a.log.Debug("Ingest node does not dispatch jobs", "retry", jobsOffRetry)
+			wait = jobsOffRetry
+		case err != nil:
+			a.log.Warn("Polling for jobs failed", "retry", retry, "error", err)
+			wait = retry
+			retry = min(2*retry, maxPollRetry)
+		default:
+			retry = minPollRetry
+			if job != nil {
+				a.runJob(ctx, job)
+			}
+		}
+		if wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+	}
+}
+
+// runJob runs job once no scheduled test is running, pushes its result
+// and reports a failure back to the ingest node.
+func (a *Agent) runJob(ctx context.Context, job *api.AgentJob) {
+	node := job.Spec.Node
+	if node == "" {
+		node = a.cfg.Ingest.URL()
+	}
+	log := a.log.With("job", job.ID, "test", job.Spec.Test, "node", node)
+	log.Info("Job received", "deadline", job.Deadline.Format(time.RFC3339))
+
+	a.testMu.Lock()
+	defer a.testMu.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+	if !time.Now().Before(job.Deadline) {
+		log.Warn("Job expired while waiting for a scheduled test")
+		return
+	}
+	if err := a.cfg.Ingest.ReportJob(ctx, a.cfg.Key, job.ID, api.JobReport{State: api.TaskRunning}); err != nil {
+		var se *speedtest.StatusError
+		if errors.As(err, &se) && (se.StatusCode == http.StatusNotFound || se.StatusCode == http.StatusConflict) {
+			log.Warn("Job dropped by ingest node", "error", err)
+			return
+		}
+		// Run it anyway; the result is what counts
+		log.Warn("Failed to report job started", "error", err)
+	}
+
+	tctx, cancel := context.WithDeadline(ctx, job.Deadline)
+	res, err := runSpec(tctx, node, job.Spec, a.cfg.Test)
+	cancel()
+	if err == nil {
+		err = a.spool(res, node, job.ID)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		log.Warn("Job failed", "error", err)
+		rep := api.JobReport{State: api.TaskFailed, Error: err.Error()}
+		if err := a.cfg.Ingest.ReportJob(ctx, a.cfg.Key, job.ID, rep); err != nil {
+			log.Warn("Failed to report job failure", "error", err)
+		}
+		return
+	}
+	a.Flush(ctx)
+}
+
+// runSpec runs the test spec describes against node. A full test uses cfg.
+func runSpec(ctx context.Context, node string, spec api.JobSpec, cfg speedtest.Config) (*speedtest.Result, error) {
+	c, err := speedtest.NewClient(node, nil)
+	if err != nil {
+		return nil, err
+	}
+	if spec.Test == api.JobTestFull {
+		return c.Run(ctx, cfg)
+	}
+
+	tc := speedtest.TimedConfig{Progress: cfg.Progress}
+	if spec.Duration != "" {
+		if tc.Duration, err = time.ParseDuration(spec.Duration); err != nil {
+			return nil, fmt.Errorf("invalid duration: %w", err)
+		}
+	}
+	switch spec.Test {
+	case api.JobTestDownload, api.JobTestUpload:
+		tc.Direction = spec.Test
+		if spec.Profile != "" {
+			p, ok := speedtest.ProfileByName(spec.Test, spec.Profile)
+			if !ok {
+				return nil, fmt.Errorf("unknown %s profile %q", spec.Test, spec.Profile)
+			}
+			tc.Profile = p
+		}
+	case api.JobTestLatency:
+	default:
+		return nil, fmt.Errorf("unknown test %q", spec.Test)
+	}
+	return c.RunTimed(ctx, tc)
+}
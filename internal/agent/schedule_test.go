@@ -0,0 +1,66 @@
+package agent
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestCronNext(t *testing.T) {
+	tests := []struct {
+		name string
+		zone string
+		spec string
+		from string
+		want string
+	}{
+		{"utc daily", "UTC", "0 3 * * *", "2026-10-16 08:22", "2026-10-17 03:00"},
+		{"utc same day", "UTC", "0 3 * * *", "2026-10-16 01:10", "2026-10-16 03:00"},
+		{"whole hour offset", "Europe/Amsterdam", "0 3 * * *", "2026-10-16 08:22", "2026-10-17 03:00"},
+		{"half hour offset", "Asia/Kolkata", "0 3 * * *", "2026-10-16 08:22", "2026-10-17 03:00"},
+		{"half hour offset dst", "Australia/Adelaide", "0 3 * * *", "2026-10-16 08:22", "2026-10-17 03:00"},
+		{"three quarter offset", "Asia/Kathmandu", "0 3 * * *", "2026-10-16 08:22", "2026-10-17 03:00"},
+		{"three quarter offset hourly", "Asia/Kathmandu", "@hourly", "2026-10-16 08:22", "2026-10-16 09:00"},
+		{"minute list", "Asia/Kolkata", "15,45 */6 * * *", "2026-10-16 06:50", "2026-10-16 12:15"},
+		{"day of week", "Asia/Kolkata", "30 9 * * 1", "2026-10-16 08:22", "2026-10-19 09:30"},
+		{"every", "Asia/Kathmandu", "@every 90m", "2026-10-16 08:22", "2026-10-16 09:52"},
+		// Clocks go forward at 02:00: 03:00 comes an hour after 01:00,
+		// and 02:30 does not exist that day
+		{"spring forward", "America/New_York", "0 3 * * *", "2026-03-08 00:30", "2026-03-08 03:00"},
+		{"spring forward gap", "America/New_York", "30 2 * * *", "2026-03-08 00:30", "2026-03-09 02:30"},
+		// Clocks go back at 02:00, to 01:00
+		{"fall back", "America/New_York", "0 2 * * *", "2026-11-01 01:30", "2026-11-01 02:00"},
+		{"fall back daily", "America/New_York", "0 3 * * *", "2026-10-31 08:00", "2026-11-01 03:00"},
+		{"never", "UTC", "0 0 31 2 *", "2026-10-16 08:22", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc, err := time.LoadLocation(tt.zone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := ParseSchedule(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			from, err := time.ParseInLocation("2006-01-02 15:04", tt.from, loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := s.Next(from)
+			if tt.want == "" {
+				if !got.IsZero() {
+					t.Errorf("Next(%s) = %s, want none", from, got)
+				}
+				return
+			}
+			want, err := time.ParseInLocation("2006-01-02 15:04", tt.want, loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(want) {
+				t.Errorf("Next(%s) = %s, want %s", from, got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/yellowman/netspeed/api"
+)
+
+// Spool keeps results that have not been pushed yet on disk, one JSON file
+// per push, so they survive the agent being offline or restarted. File
+// names start with the time they were spooled, so sorting them puts the
+// oldest first.
+type Spool struct {
+	dir string
+	// max is the most pushes kept; the oldest are dropped beyond it
+	max int
+}
+
+// spoolExt marks a pending push; files being written have ".tmp" appended,
+// rejected ones ".rejected".
+const spoolExt = ".json"
+
+// OpenSpool opens the spool in dir, creating it if need be, keeping at most
+// max pushes (0 for no limit).
+func OpenSpool(dir string, max int) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create spool: %w", err)
+	}
+	return &Spool{dir: dir, max: max}, nil
+}
+
+// Dir returns the spool directory.
+func (s *Spool) Dir() string {
+	return s.dir
+}
+
+// Add writes push to the spool and returns its name. If the spool is over
+// its limit afterwards, the oldest pushes are dropped and their names
+// returned too.
+func (s *Spool) Add(push *api.IngestRequest) (name string, dropped []string, err error) {
+	data, err := json.Marshal(push)
+	if err != nil {
+		return "", nil, err
+	}
+	name = fmt.Sprintf("%020d-%s%s", time.Now().UnixNano(), push.ID, spoolExt)
+	tmp := filepath.Join(s.dir, name+".tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		os.Remove(tmp)
+		return "", nil, fmt.Errorf("failed to spool result: %w", err)
+	}
+	// Rename so a crash never leaves a half-written push behind
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		os.Remove(tmp)
+		return "", nil, fmt.Errorf("failed to spool result: %w", err)
+	}
+
+	if s.max > 0 {
+		names, err := s.List()
+		if err != nil {
+			return name, nil, err
+		}
+		for len(names) > s.max {
+			if err := s.Remove(names[0]); err != nil {
+				return name, dropped, err
+			}
+			dropped = append(dropped, names[0])
+			names = names[1:]
+		}
+	}
+	return name, dropped, nil
+}
+
+// List returns the names of the pending pushes, oldest first.
+func (s *Spool) List() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool: %w", err)
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && strings.HasSuffix(e.Name(), spoolExt) {
+			names = append(names, e.Name())
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// Load reads the push called name.
+func (s *Spool) Load(name string) (*api.IngestRequest, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	var push api.IngestRequest
+	if err := json.Unmarshal(data, &push); err != nil {
+		return nil, fmt.Errorf("spooled result %s: %w", name, err)
+	}
+	return &push, nil
+}
+
+// Remove deletes the push called name, once it has been pushed.
+func (s *Spool) Remove(name string) error {
+	if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Reject sets the push called name aside, for one the server refused: it
+// stays on disk for a look but is not retried.
+func (s *Spool) Reject(name string) error {
+	path := filepath.Join(s.dir, name)
+	return os.Rename(path, path+".rejected")
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// minAgentKey is the shortest agent key accepted, as for results_api_token.
+const minAgentKey = 16
+
+// maxAgentID bounds an agent ID, which is stored with every result the
+// agent pushes.
+const maxAgentID = 64
+
+// ValidAgentID reports whether id can name an agent: 1 to 64 letters,
+// digits, dots, dashes and underscores.
+func ValidAgentID(id string) bool {
+	if id == "" || len(id) > maxAgentID {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateAgentKeys checks that every agent ID is valid and that every key
+// is long enough and used by one agent only.
+func ValidateAgentKeys(keys map[string]string) error {
+	seen := make(map[string]string, len(keys))
+	for id, key := range keys {
+		if !ValidAgentID(id) {
+			return fmt.Errorf("invalid agent ID %q (want 1-%d letters, digits, '.', '-' or '_')", id, maxAgentID)
+		}
+		if len(key) < minAgentKey {
+			return fmt.Errorf("key of agent %q must be at least %d bytes", id, minAgentKey)
+		}
+		if other, ok := seen[key]; ok {
+			return fmt.Errorf("agents %q and %q share a key", other, id)
+		}
+		seen[key] = id
+	}
+	return nil
+}
+
+// ParseAgentKeys parses a comma-separated list of id=key pairs, the format
+// of NETSPEEDD_AGENT_KEYS.
+func ParseAgentKeys(spec string) (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, key, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("agent key %q is not id=key", pair)
+		}
+		id = strings.TrimSpace(id)
+		if _, dup := keys[id]; dup {
+			return nil, fmt.Errorf("agent %q is listed twice", id)
+		}
+		keys[id] = strings.TrimSpace(key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no agent keys")
+	}
+	if err := ValidateAgentKeys(keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
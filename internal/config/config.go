@@ -13,10 +13,71 @@ type Config struct {
 	// ListenAddr is the address to listen on (e.g., ":8080" or "0.0.0.0:443")
 	ListenAddr string
 
+	// Listeners, if non-empty, replaces ListenAddr, HTTP1Addr and HTTP2Addr
+	// with an explicit list of sockets (TCP, split IPv4/IPv6, Unix)
+	Listeners []Listener
+
 	// TLS configuration - if both are empty, server runs in HTTP-only mode
 	TLSCertFile string
 	TLSKeyFile  string
 
+	// ACME enables automatic TLS certificates for Hostname (mutually
+	// exclusive with TLSCertFile/TLSKeyFile)
+	ACME bool
+	// ACMEDirectoryURL is the ACME directory (default: Let's Encrypt production)
+	ACMEDirectoryURL string
+	// ACMEEmail is the contact address registered with the ACME account
+	ACMEEmail string
+	// ACMECacheDir stores the account key and issued certificates
+	ACMECacheDir string
+	// ACMEHTTPAddr serves HTTP-01 challenges; empty disables HTTP-01 and
+	// leaves TLS-ALPN-01 on the main listener as the only challenge type
+	ACMEHTTPAddr string
+	// ACMECAFile is an extra PEM root CA trusted when talking to the ACME
+	// directory, e.g. the Pebble test CA
+	ACMECAFile string
+
+	// EnableH2C accepts cleartext HTTP/2 (prior knowledge and Upgrade: h2c)
+	// on the main listener when TLS is off
+	EnableH2C bool
+	// HTTP1Addr, if set, binds an extra listener that only speaks HTTP/1.1
+	HTTP1Addr string
+	// HTTP2Addr, if set, binds an extra listener that only speaks HTTP/2
+	// (h2 with TLS, h2c prior knowledge without)
+	HTTP2Addr string
+
+	// TCP socket tuning applied to every accepted connection; listeners
+	// may override the buffer sizes and congestion control
+	TCPSendBufSize int64 // send buffer size
+	TCPRecvBufSize int64 // receive buffer size
+	TCPNoDelay     bool  // disable Nagle's algorithm
+	// TCPCongestion is the default TCP_CONGESTION algorithm (e.g. "cubic",
+	// "bbr"); empty uses the kernel default. Linux only.
+	TCPCongestion string
+	// TCPCongestionAllowed lists the algorithms a client may pick per
+	// request with cc= on /__down and /__up; empty disables cc=
+	TCPCongestionAllowed []string
+	// TCPInfoInterval is how often TCP_INFO is sampled during /__down and
+	// /__up transfers; 0 disables sampling. Linux only.
+	TCPInfoInterval time.Duration
+
+	// HTTP3 enables an HTTP/3 (QUIC) listener next to the TCP listener (requires TLS)
+	HTTP3 bool
+	// HTTP3Addr is the UDP address for HTTP/3 (default: first TLS listener)
+	HTTP3Addr string
+	// QUIC tuning; zero values use the server defaults
+	QUICUDPBufSize   int64 // UDP socket send/receive buffer size
+	QUICStreamWindow int64 // maximum per-stream receive window
+	QUICConnWindow   int64 // maximum per-connection receive window
+
+	// Admission control for /__down and /__up transfers; zero values are
+	// unlimited. Each transfer counts as one test.
+	MaxConcurrentTests int
+	MaxEgressMbps      int64         // total download bandwidth cap
+	MaxIngressMbps     int64         // total upload bandwidth cap
+	QueueTimeout       time.Duration // how long to queue when saturated; 0 rejects at once
+	MaxQueuedTests     int           // maximum queue length; 0 is unbounded
+
 	// MaxBytes is the hard cap for bytes parameter in /__down and upload body size
 	MaxBytes int64
 
@@ -63,6 +124,47 @@ type Config struct {
 	// WebDir is the path to the directory containing static web files
 	// If set, the server will serve static files from this directory
 	WebDir string
+
+	// RateLimit limits requests and bytes per client on the measurement endpoints
+	RateLimit RateLimit
+
+	// TestTokens requires signed tokens on the measurement endpoints
+	TestTokens TestTokens
+
+	// Metrics serves Prometheus metrics on /metrics
+	Metrics bool
+	// MetricsAddr, if set, serves /metrics on its own listener instead of
+	// the main ones, so it can be kept off the public interface
+	MetricsAddr string
+
+	// TracingEndpoint is the OTLP/HTTP collector URL traces are exported
+	// to (e.g. "http://localhost:4318"); empty disables tracing
+	TracingEndpoint string
+	// TracingSampleRatio is the fraction of requests traced (0..1)
+	TracingSampleRatio float64
+
+	// Log sets the log format, levels and sampling
+	Log Log
+
+	// ResultsDB is the database file that shared test results are stored
+	// in; empty disables /api/results
+	ResultsDB string
+	// ResultsAPIToken is the bearer token required to list and aggregate
+	// stored results; empty disables those endpoints
+	ResultsAPIToken string
+	// AgentKeys maps the ID of a monitoring agent to the key it pushes
+	// results to /api/results/ingest with; empty disables that endpoint
+	AgentKeys map[string]string
+	// AdminToken is the bearer token required for the agent registry and
+	// job endpoints under /api/admin; empty disables them
+	AdminToken string
+
+	// Mesh tests the other nodes of the locations store on a schedule
+	Mesh Mesh
+
+	// listenAddrSet records that the config file or the environment set
+	// ListenAddr
+	listenAddrSet bool
 }
 
 // Default returns a Config with sensible defaults.
@@ -74,6 +176,11 @@ func Default() *Config {
 		WriteTimeout:       60 * time.Second,
 		IdleTimeout:        120 * time.Second,
 		EnableServerTiming: true,
+		EnableH2C:          true,
+		TCPSendBufSize:     4 << 20, // 4 MiB
+		TCPRecvBufSize:     4 << 20, // 4 MiB
+		TCPNoDelay:         true,
+		TCPInfoInterval:    100 * time.Millisecond,
 		EnableCORS:         true,
 		AllowedOrigins:     []string{"*"},
 		Hostname:           "localhost",
@@ -82,119 +189,355 @@ func Default() *Config {
 		EmbeddedTurn:       true,
 		EmbeddedTurnAddr:   "0.0.0.0:3478",
 		TurnRealm:          "netspeed",
+		ACMEDirectoryURL:   "https://acme-v02.api.letsencrypt.org/directory",
+		ACMECacheDir:       "acme-cache",
+		ACMEHTTPAddr:       ":80",
+		RateLimit:          defaultRateLimit(),
+		TestTokens:         defaultTestTokens(),
+		TracingSampleRatio: 1,
+		Log:                defaultLog(),
+		Mesh:               defaultMesh(),
 	}
 }
 
 // FromEnv loads configuration from environment variables, falling back to defaults.
 func FromEnv() *Config {
 	cfg := Default()
+	cfg.applyEnv()
+	return cfg
+}
 
+// applyEnv overrides fields with any NETSPEEDD_* environment variables that are set.
+func (c *Config) applyEnv() {
 	if addr := os.Getenv("NETSPEEDD_LISTEN_ADDR"); addr != "" {
-		cfg.ListenAddr = addr
+		c.ListenAddr = addr
+		c.listenAddrSet = true
+	}
+
+	if listeners := os.Getenv("NETSPEEDD_LISTENERS"); listeners != "" {
+		if v, err := ParseListeners(listeners); err == nil && len(v) > 0 {
+			c.Listeners = v
+		}
 	}
 
 	if certFile := os.Getenv("NETSPEEDD_TLS_CERT"); certFile != "" {
-		cfg.TLSCertFile = certFile
+		c.TLSCertFile = certFile
 	}
 
 	if keyFile := os.Getenv("NETSPEEDD_TLS_KEY"); keyFile != "" {
-		cfg.TLSKeyFile = keyFile
+		c.TLSKeyFile = keyFile
+	}
+
+	if acme := os.Getenv("NETSPEEDD_ACME"); acme != "" {
+		c.ACME = acme == "true" || acme == "1"
+	}
+
+	if acmeDir := os.Getenv("NETSPEEDD_ACME_DIRECTORY"); acmeDir != "" {
+		c.ACMEDirectoryURL = acmeDir
+	}
+
+	if acmeEmail := os.Getenv("NETSPEEDD_ACME_EMAIL"); acmeEmail != "" {
+		c.ACMEEmail = acmeEmail
+	}
+
+	if acmeCache := os.Getenv("NETSPEEDD_ACME_CACHE_DIR"); acmeCache != "" {
+		c.ACMECacheDir = acmeCache
+	}
+
+	if acmeHTTPAddr, ok := os.LookupEnv("NETSPEEDD_ACME_HTTP_ADDR"); ok {
+		c.ACMEHTTPAddr = acmeHTTPAddr
+	}
+
+	if acmeCAFile := os.Getenv("NETSPEEDD_ACME_CA_FILE"); acmeCAFile != "" {
+		c.ACMECAFile = acmeCAFile
+	}
+
+	if h2c := os.Getenv("NETSPEEDD_H2C"); h2c != "" {
+		c.EnableH2C = h2c == "true" || h2c == "1"
+	}
+
+	if http1Addr := os.Getenv("NETSPEEDD_HTTP1_ADDR"); http1Addr != "" {
+		c.HTTP1Addr = http1Addr
+	}
+
+	if http2Addr := os.Getenv("NETSPEEDD_HTTP2_ADDR"); http2Addr != "" {
+		c.HTTP2Addr = http2Addr
+	}
+
+	if sendBuf := os.Getenv("NETSPEEDD_TCP_SEND_BUFFER"); sendBuf != "" {
+		if v, err := ParseSize(sendBuf); err == nil && v > 0 {
+			c.TCPSendBufSize = v
+		}
+	}
+
+	if recvBuf := os.Getenv("NETSPEEDD_TCP_RECV_BUFFER"); recvBuf != "" {
+		if v, err := ParseSize(recvBuf); err == nil && v > 0 {
+			c.TCPRecvBufSize = v
+		}
+	}
+
+	if noDelay := os.Getenv("NETSPEEDD_TCP_NODELAY"); noDelay != "" {
+		c.TCPNoDelay = noDelay == "true" || noDelay == "1"
+	}
+
+	if cc, ok := os.LookupEnv("NETSPEEDD_TCP_CONGESTION"); ok {
+		c.TCPCongestion = cc
+	}
+
+	if ccAllowed := os.Getenv("NETSPEEDD_TCP_CONGESTION_ALLOWED"); ccAllowed != "" {
+		c.TCPCongestionAllowed = strings.Split(ccAllowed, ",")
+	}
+
+	if tcpInfo := os.Getenv("NETSPEEDD_TCP_INFO_INTERVAL"); tcpInfo != "" {
+		if d, err := time.ParseDuration(tcpInfo); err == nil && d >= 0 {
+			c.TCPInfoInterval = d
+		}
+	}
+
+	if http3 := os.Getenv("NETSPEEDD_HTTP3"); http3 != "" {
+		c.HTTP3 = http3 == "true" || http3 == "1"
+	}
+
+	if http3Addr := os.Getenv("NETSPEEDD_HTTP3_ADDR"); http3Addr != "" {
+		c.HTTP3Addr = http3Addr
+	}
+
+	if udpBuf := os.Getenv("NETSPEEDD_QUIC_UDP_BUFFER"); udpBuf != "" {
+		if v, err := ParseSize(udpBuf); err == nil && v > 0 {
+			c.QUICUDPBufSize = v
+		}
+	}
+
+	if streamWindow := os.Getenv("NETSPEEDD_QUIC_STREAM_WINDOW"); streamWindow != "" {
+		if v, err := ParseSize(streamWindow); err == nil && v > 0 {
+			c.QUICStreamWindow = v
+		}
+	}
+
+	if connWindow := os.Getenv("NETSPEEDD_QUIC_CONN_WINDOW"); connWindow != "" {
+		if v, err := ParseSize(connWindow); err == nil && v > 0 {
+			c.QUICConnWindow = v
+		}
+	}
+
+	if maxTests := os.Getenv("NETSPEEDD_MAX_CONCURRENT_TESTS"); maxTests != "" {
+		if v, err := strconv.Atoi(maxTests); err == nil && v >= 0 {
+			c.MaxConcurrentTests = v
+		}
+	}
+
+	if maxEgress := os.Getenv("NETSPEEDD_MAX_EGRESS_MBPS"); maxEgress != "" {
+		if v, err := strconv.ParseInt(maxEgress, 10, 64); err == nil && v >= 0 {
+			c.MaxEgressMbps = v
+		}
+	}
+
+	if maxIngress := os.Getenv("NETSPEEDD_MAX_INGRESS_MBPS"); maxIngress != "" {
+		if v, err := strconv.ParseInt(maxIngress, 10, 64); err == nil && v >= 0 {
+			c.MaxIngressMbps = v
+		}
+	}
+
+	if queueTimeout := os.Getenv("NETSPEEDD_QUEUE_TIMEOUT"); queueTimeout != "" {
+		if d, err := time.ParseDuration(queueTimeout); err == nil && d >= 0 {
+			c.QueueTimeout = d
+		}
+	}
+
+	if maxQueued := os.Getenv("NETSPEEDD_MAX_QUEUED_TESTS"); maxQueued != "" {
+		if v, err := strconv.Atoi(maxQueued); err == nil && v >= 0 {
+			c.MaxQueuedTests = v
+		}
 	}
 
 	if maxBytes := os.Getenv("NETSPEEDD_MAX_BYTES"); maxBytes != "" {
-		if v, err := strconv.ParseInt(maxBytes, 10, 64); err == nil && v > 0 {
-			cfg.MaxBytes = v
+		if v, err := ParseSize(maxBytes); err == nil && v > 0 {
+			c.MaxBytes = v
 		}
 	}
 
 	if readTimeout := os.Getenv("NETSPEEDD_READ_TIMEOUT"); readTimeout != "" {
 		if d, err := time.ParseDuration(readTimeout); err == nil {
-			cfg.ReadTimeout = d
+			c.ReadTimeout = d
 		}
 	}
 
 	if writeTimeout := os.Getenv("NETSPEEDD_WRITE_TIMEOUT"); writeTimeout != "" {
 		if d, err := time.ParseDuration(writeTimeout); err == nil {
-			cfg.WriteTimeout = d
+			c.WriteTimeout = d
 		}
 	}
 
 	if idleTimeout := os.Getenv("NETSPEEDD_IDLE_TIMEOUT"); idleTimeout != "" {
 		if d, err := time.ParseDuration(idleTimeout); err == nil {
-			cfg.IdleTimeout = d
+			c.IdleTimeout = d
 		}
 	}
 
 	if serverTiming := os.Getenv("NETSPEEDD_SERVER_TIMING"); serverTiming != "" {
-		cfg.EnableServerTiming = serverTiming == "true" || serverTiming == "1"
+		c.EnableServerTiming = serverTiming == "true" || serverTiming == "1"
 	}
 
 	if enableCORS := os.Getenv("NETSPEEDD_ENABLE_CORS"); enableCORS != "" {
-		cfg.EnableCORS = enableCORS == "true" || enableCORS == "1"
+		c.EnableCORS = enableCORS == "true" || enableCORS == "1"
 	}
 
 	if origins := os.Getenv("NETSPEEDD_ALLOWED_ORIGINS"); origins != "" {
-		cfg.AllowedOrigins = strings.Split(origins, ",")
+		c.AllowedOrigins = strings.Split(origins, ",")
 	}
 
 	if locFile := os.Getenv("NETSPEEDD_LOCATIONS_FILE"); locFile != "" {
-		cfg.LocationsFile = locFile
+		c.LocationsFile = locFile
 	}
 
 	if geoDB := os.Getenv("NETSPEEDD_GEOIP_DB"); geoDB != "" {
-		cfg.GeoIPDatabasePath = geoDB
+		c.GeoIPDatabasePath = geoDB
 	}
 
 	if trustProxy := os.Getenv("NETSPEEDD_TRUST_PROXY"); trustProxy != "" {
-		cfg.TrustProxyHeaders = trustProxy == "true" || trustProxy == "1"
+		c.TrustProxyHeaders = trustProxy == "true" || trustProxy == "1"
 	}
 
 	if hostname := os.Getenv("NETSPEEDD_HOSTNAME"); hostname != "" {
-		cfg.Hostname = hostname
+		c.Hostname = hostname
 	}
 
 	if colo := os.Getenv("NETSPEEDD_COLO"); colo != "" {
-		cfg.Colo = colo
+		c.Colo = colo
 	}
 
 	if turnSecret := os.Getenv("NETSPEEDD_TURN_SECRET"); turnSecret != "" {
-		cfg.TurnSecret = turnSecret
+		c.TurnSecret = turnSecret
 	}
 
 	if turnRealm := os.Getenv("NETSPEEDD_TURN_REALM"); turnRealm != "" {
-		cfg.TurnRealm = turnRealm
+		c.TurnRealm = turnRealm
 	}
 
 	if turnServers := os.Getenv("NETSPEEDD_TURN_SERVERS"); turnServers != "" {
-		cfg.TurnServers = strings.Split(turnServers, ",")
+		c.TurnServers = strings.Split(turnServers, ",")
 	}
 
 	if maxTurnTTL := os.Getenv("NETSPEEDD_MAX_TURN_TTL"); maxTurnTTL != "" {
 		if v, err := strconv.ParseInt(maxTurnTTL, 10, 64); err == nil && v > 0 {
-			cfg.MaxTurnTTL = v
+			c.MaxTurnTTL = v
 		}
 	}
 
 	if embeddedTurn := os.Getenv("NETSPEEDD_EMBEDDED_TURN"); embeddedTurn != "" {
-		cfg.EmbeddedTurn = embeddedTurn == "true" || embeddedTurn == "1"
+		c.EmbeddedTurn = embeddedTurn == "true" || embeddedTurn == "1"
 	}
 
 	if embeddedTurnAddr := os.Getenv("NETSPEEDD_EMBEDDED_TURN_ADDR"); embeddedTurnAddr != "" {
-		cfg.EmbeddedTurnAddr = embeddedTurnAddr
+		c.EmbeddedTurnAddr = embeddedTurnAddr
 	}
 
 	if embeddedTurnPublicIP := os.Getenv("NETSPEEDD_EMBEDDED_TURN_PUBLIC_IP"); embeddedTurnPublicIP != "" {
-		cfg.EmbeddedTurnPublicIP = embeddedTurnPublicIP
+		c.EmbeddedTurnPublicIP = embeddedTurnPublicIP
 	}
 
 	if webDir := os.Getenv("NETSPEEDD_WEB_DIR"); webDir != "" {
-		cfg.WebDir = webDir
+		c.WebDir = webDir
 	}
 
-	return cfg
+	if rateLimit := os.Getenv("NETSPEEDD_RATE_LIMIT"); rateLimit != "" {
+		c.RateLimit.Enabled = rateLimit == "true" || rateLimit == "1"
+	}
+
+	if exempt := os.Getenv("NETSPEEDD_RATE_LIMIT_EXEMPT"); exempt != "" {
+		c.RateLimit.Exempt = strings.Split(exempt, ",")
+	}
+
+	if tokens := os.Getenv("NETSPEEDD_TEST_TOKENS"); tokens != "" {
+		c.TestTokens.Enabled = tokens == "true" || tokens == "1"
+	}
+
+	if secret := os.Getenv("NETSPEEDD_TEST_TOKEN_SECRET"); secret != "" {
+		c.TestTokens.Secret = secret
+	}
+
+	if metrics := os.Getenv("NETSPEEDD_METRICS"); metrics != "" {
+		c.Metrics = metrics == "true" || metrics == "1"
+	}
+
+	if metricsAddr := os.Getenv("NETSPEEDD_METRICS_ADDR"); metricsAddr != "" {
+		c.MetricsAddr = metricsAddr
+	}
+
+	if endpoint := os.Getenv("NETSPEEDD_TRACING_ENDPOINT"); endpoint != "" {
+		c.TracingEndpoint = endpoint
+	}
+
+	if ratio := os.Getenv("NETSPEEDD_TRACING_SAMPLE_RATIO"); ratio != "" {
+		if v, err := strconv.ParseFloat(ratio, 64); err == nil && v >= 0 && v <= 1 {
+			c.TracingSampleRatio = v
+		}
+	}
+
+	if resultsDB := os.Getenv("NETSPEEDD_RESULTS_DB"); resultsDB != "" {
+		c.ResultsDB = resultsDB
+	}
+
+	if token := os.Getenv("NETSPEEDD_RESULTS_API_TOKEN"); token != "" {
+		c.ResultsAPIToken = token
+	}
+
+	if agentKeys := os.Getenv("NETSPEEDD_AGENT_KEYS"); agentKeys != "" {
+		if v, err := ParseAgentKeys(agentKeys); err == nil {
+			c.AgentKeys = v
+		}
+	}
+
+	if token := os.Getenv("NETSPEEDD_ADMIN_TOKEN"); token != "" {
+		c.AdminToken = token
+	}
+
+	if mesh := os.Getenv("NETSPEEDD_MESH"); mesh != "" {
+		c.Mesh.Enabled = mesh == "true" || mesh == "1"
+	}
+
+	if tmpl := os.Getenv("NETSPEEDD_MESH_URL_TEMPLATE"); tmpl != "" {
+		c.Mesh.URLTemplate = tmpl
+	}
+
+	if interval := os.Getenv("NETSPEEDD_MESH_INTERVAL"); interval != "" {
+		if v, err := time.ParseDuration(interval); err == nil && v >= time.Minute {
+			c.Mesh.Interval = v
+		}
+	}
+
+	if db := os.Getenv("NETSPEEDD_MESH_HISTORY_DB"); db != "" {
+		c.Mesh.HistoryDB = db
+	}
+
+	if format := os.Getenv("NETSPEEDD_LOG_FORMAT"); format == LogFormatText || format == LogFormatJSON {
+		c.Log.Format = format
+	}
+
+	if spec := os.Getenv("NETSPEEDD_LOG_LEVEL"); spec != "" {
+		if level, levels, err := ParseLogLevel(spec); err == nil {
+			c.Log.SetLevel(level, levels)
+		}
+	}
 }
 
-// TLSEnabled returns true if TLS certificate and key are configured.
+// CongestionAllowed reports whether clients may select algorithm with cc=.
+func (c *Config) CongestionAllowed(algorithm string) bool {
+	for _, a := range c.TCPCongestionAllowed {
+		if strings.TrimSpace(a) == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// TLSEnabled returns true if TLS certificate and key are configured or ACME is enabled.
 func (c *Config) TLSEnabled() bool {
-	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+	return c.ACME || (c.TLSCertFile != "" && c.TLSKeyFile != "")
+}
+
+// ListenAddrSet reports whether the config file or the environment set
+// ListenAddr, rather than leaving it at its default.
+func (c *Config) ListenAddrSet() bool {
+	return c.listenAddrSet
 }
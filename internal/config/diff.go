@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// secretFields are reported as changed without revealing their values.
+var secretFields = map[string]bool{
+	"TurnSecret":      true,
+	"TestTokens":      true,
+	"ResultsAPIToken": true,
+	"AgentKeys":       true,
+	"AdminToken":      true,
+}
+
+// Diff returns a human-readable line for every field that differs between
+// old and new, e.g. `Hostname: "a.example.com" -> "b.example.com"`.
+func Diff(old, new *Config) []string {
+	var changes []string
+
+	ov := reflect.ValueOf(old).Elem()
+	nv := reflect.ValueOf(new).Elem()
+	t := ov.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		if !t.Field(i).IsExported() {
+			continue
+		}
+		name := t.Field(i).Name
+		a, b := ov.Field(i).Interface(), nv.Field(i).Interface()
+		if reflect.DeepEqual(a, b) {
+			continue
+		}
+		if secretFields[name] {
+			changes = append(changes, fmt.Sprintf("%s: changed", name))
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("%s: %s -> %s", name, formatValue(a), formatValue(b)))
+	}
+
+	return changes
+}
+
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return fmt.Sprintf("%q", v)
+	case []string:
+		return fmt.Sprintf("%q", v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -0,0 +1,522 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig mirrors Config using the snake_case keys documented in
+// configs/config.example.yaml. Pointer fields distinguish "not set" from
+// the zero value so a file only overrides the keys it actually contains.
+type fileConfig struct {
+	ListenAddr           *string           `yaml:"listen_addr"`
+	Listeners            []fileListener    `yaml:"listeners"`
+	TLSCertFile          *string           `yaml:"tls_cert_file"`
+	TLSKeyFile           *string           `yaml:"tls_key_file"`
+	ACME                 *bool             `yaml:"acme"`
+	ACMEDirectoryURL     *string           `yaml:"acme_directory_url"`
+	ACMEEmail            *string           `yaml:"acme_email"`
+	ACMECacheDir         *string           `yaml:"acme_cache_dir"`
+	ACMEHTTPAddr         *string           `yaml:"acme_http_addr"`
+	ACMECAFile           *string           `yaml:"acme_ca_file"`
+	EnableH2C            *bool             `yaml:"enable_h2c"`
+	HTTP1Addr            *string           `yaml:"http1_addr"`
+	HTTP2Addr            *string           `yaml:"http2_addr"`
+	TCPSendBufSize       *ByteSize         `yaml:"tcp_send_buffer"`
+	TCPRecvBufSize       *ByteSize         `yaml:"tcp_recv_buffer"`
+	TCPNoDelay           *bool             `yaml:"tcp_nodelay"`
+	TCPCongestion        *string           `yaml:"tcp_congestion"`
+	TCPCongestionAllowed []string          `yaml:"tcp_congestion_allowed"`
+	TCPInfoInterval      *Duration         `yaml:"tcp_info_interval"`
+	HTTP3                *bool             `yaml:"http3"`
+	HTTP3Addr            *string           `yaml:"http3_addr"`
+	QUICUDPBufSize       *ByteSize         `yaml:"quic_udp_buffer"`
+	QUICStreamWindow     *ByteSize         `yaml:"quic_stream_window"`
+	QUICConnWindow       *ByteSize         `yaml:"quic_conn_window"`
+	MaxConcurrentTests   *int              `yaml:"max_concurrent_tests"`
+	MaxEgressMbps        *int64            `yaml:"max_egress_mbps"`
+	MaxIngressMbps       *int64            `yaml:"max_ingress_mbps"`
+	QueueTimeout         *Duration         `yaml:"queue_timeout"`
+	MaxQueuedTests       *int              `yaml:"max_queued_tests"`
+	MaxBytes             *ByteSize         `yaml:"max_bytes"`
+	ReadTimeout          *Duration         `yaml:"read_timeout"`
+	WriteTimeout         *Duration         `yaml:"write_timeout"`
+	IdleTimeout          *Duration         `yaml:"idle_timeout"`
+	EnableServerTiming   *bool             `yaml:"enable_server_timing"`
+	EnableCORS           *bool             `yaml:"enable_cors"`
+	AllowedOrigins       []string          `yaml:"allowed_origins"`
+	LocationsFile        *string           `yaml:"locations_file"`
+	GeoIPDatabasePath    *string           `yaml:"geoip_database_path"`
+	TrustProxyHeaders    *bool             `yaml:"trust_proxy_headers"`
+	Hostname             *string           `yaml:"hostname"`
+	Colo                 *string           `yaml:"colo"`
+	TurnSecret           *string           `yaml:"turn_secret"`
+	TurnServers          []string          `yaml:"turn_servers"`
+	TurnRealm            *string           `yaml:"turn_realm"`
+	MaxTurnTTL           *Duration         `yaml:"max_turn_ttl"`
+	EmbeddedTurn         *bool             `yaml:"embedded_turn"`
+	EmbeddedTurnAddr     *string           `yaml:"embedded_turn_addr"`
+	EmbeddedTurnPublicIP *string           `yaml:"embedded_turn_public_ip"`
+	WebDir               *string           `yaml:"web_dir"`
+	RateLimit            *fileRateLimit    `yaml:"rate_limit"`
+	TestTokens           *fileTestTokens   `yaml:"test_tokens"`
+	Metrics              *bool             `yaml:"metrics"`
+	MetricsAddr          *string           `yaml:"metrics_addr"`
+	TracingEndpoint      *string           `yaml:"tracing_endpoint"`
+	TracingSampleRatio   *float64          `yaml:"tracing_sample_ratio"`
+	Log                  *fileLog          `yaml:"log"`
+	ResultsDB            *string           `yaml:"results_db"`
+	ResultsAPIToken      *string           `yaml:"results_api_token"`
+	AgentKeys            map[string]string `yaml:"agent_keys"`
+	AdminToken           *string           `yaml:"admin_token"`
+	Mesh                 *fileMesh         `yaml:"mesh"`
+}
+
+// fileListener is a Listener as written in the config file, either as a
+// mapping or as a ParseListener spec string.
+type fileListener Listener
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (l *fileListener) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		parsed, err := ParseListener(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		*l = fileListener(parsed)
+		return nil
+	}
+
+	var raw struct {
+		Addr        string   `yaml:"addr"`
+		Network     string   `yaml:"network"`
+		TLS         bool     `yaml:"tls"`
+		Protocol    string   `yaml:"protocol"`
+		SendBufSize ByteSize `yaml:"send_buffer"`
+		RecvBufSize ByteSize `yaml:"recv_buffer"`
+		Congestion  string   `yaml:"congestion"`
+	}
+	if err := node.Decode(&raw); err != nil {
+		return err
+	}
+	if raw.Network == "" {
+		raw.Network = "tcp"
+	}
+	*l = fileListener{
+		Addr:        raw.Addr,
+		Network:     raw.Network,
+		TLS:         raw.TLS,
+		Protocol:    raw.Protocol,
+		SendBufSize: int64(raw.SendBufSize),
+		RecvBufSize: int64(raw.RecvBufSize),
+		Congestion:  raw.Congestion,
+	}
+	return nil
+}
+
+// Duration is a time.Duration that unmarshals from YAML as either a Go
+// duration string ("15s", "2m30s") or a bare integer number of seconds.
+type Duration time.Duration
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: expected a duration, got %s", node.Line, kindName(node))
+	}
+	v, err := ParseDuration(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// ByteSize is a byte count that unmarshals from YAML as either a plain
+// integer or a size string with a unit suffix ("512MB", "1GiB").
+type ByteSize int64
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (b *ByteSize) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: expected a size, got %s", node.Line, kindName(node))
+	}
+	v, err := ParseSize(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*b = ByteSize(v)
+	return nil
+}
+
+// ParseDuration parses a Go duration string, treating a bare integer as seconds.
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, errors.New("empty duration")
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n < 0 {
+			return 0, fmt.Errorf("invalid duration %q: must not be negative", s)
+		}
+		return time.Duration(n) * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid duration %q: must not be negative", s)
+	}
+	return d, nil
+}
+
+// sizeUnits maps lower-cased unit suffixes to their multiplier.
+// Decimal units (KB, MB, GB) are powers of 1000, binary units (KiB, MiB, GiB)
+// are powers of 1024.
+var sizeUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"k":   1 << 10,
+	"kb":  1000,
+	"kib": 1 << 10,
+	"m":   1 << 20,
+	"mb":  1000 * 1000,
+	"mib": 1 << 20,
+	"g":   1 << 30,
+	"gb":  1000 * 1000 * 1000,
+	"gib": 1 << 30,
+	"t":   1 << 40,
+	"tb":  1000 * 1000 * 1000 * 1000,
+	"tib": 1 << 40,
+}
+
+// ParseSize parses a byte size such as "1073741824", "512MB" or "1GiB".
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return !unicode.IsDigit(r) && r != '.'
+	})
+	num, unit := s, ""
+	if i >= 0 {
+		num, unit = s[:i], strings.TrimSpace(s[i:])
+	}
+	if num == "" {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	mult, ok := sizeUnits[strings.ToLower(unit)]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q", s, unit)
+	}
+	if !strings.Contains(num, ".") {
+		n, err := strconv.ParseInt(num, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid size %q", s)
+		}
+		if n > math.MaxInt64/mult {
+			return 0, fmt.Errorf("invalid size %q: out of range", s)
+		}
+		return n * mult, nil
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	// float64(math.MaxInt64) rounds up to 2^63, the first value that
+	// does not fit
+	if f*float64(mult) >= float64(math.MaxInt64) {
+		return 0, fmt.Errorf("invalid size %q: out of range", s)
+	}
+	return int64(f * float64(mult)), nil
+}
+
+// FromFile loads configuration from a YAML file on top of the defaults.
+// Unknown keys and malformed values are reported as errors.
+func FromFile(path string) (*Config, error) {
+	cfg := Default()
+	if err := cfg.applyFile(path); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Load builds the effective configuration: defaults, then the YAML file at
+// path (if non-empty), then NETSPEEDD_* environment variables. Command-line
+// flags are applied by the caller on top of the result.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		if err := cfg.applyFile(path); err != nil {
+			return nil, err
+		}
+	}
+	cfg.applyEnv()
+	return cfg, nil
+}
+
+// applyFile reads a YAML config file and overrides the fields it sets.
+func (c *Config) applyFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var fc fileConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&fc); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	if err := fc.validate(); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	fc.apply(c)
+	return nil
+}
+
+// validate checks values that parse fine but make no sense.
+func (fc *fileConfig) validate() error {
+	if fc.MaxBytes != nil && *fc.MaxBytes <= 0 {
+		return errors.New("max_bytes must be positive")
+	}
+	for name, v := range map[string]*int{
+		"max_concurrent_tests": fc.MaxConcurrentTests,
+		"max_queued_tests":     fc.MaxQueuedTests,
+	} {
+		if v != nil && *v < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	for name, v := range map[string]*int64{
+		"max_egress_mbps":  fc.MaxEgressMbps,
+		"max_ingress_mbps": fc.MaxIngressMbps,
+	} {
+		if v != nil && *v < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	for _, l := range fc.Listeners {
+		if err := (*Listener)(&l).Validate(); err != nil {
+			return err
+		}
+	}
+	if fc.TCPCongestion != nil && *fc.TCPCongestion != "" {
+		if err := ValidateCongestion(*fc.TCPCongestion); err != nil {
+			return fmt.Errorf("tcp_congestion: %w", err)
+		}
+	}
+	for _, cc := range fc.TCPCongestionAllowed {
+		if err := ValidateCongestion(cc); err != nil {
+			return fmt.Errorf("tcp_congestion_allowed: %w", err)
+		}
+	}
+	for name, v := range map[string]*ByteSize{
+		"tcp_send_buffer":    fc.TCPSendBufSize,
+		"tcp_recv_buffer":    fc.TCPRecvBufSize,
+		"quic_udp_buffer":    fc.QUICUDPBufSize,
+		"quic_stream_window": fc.QUICStreamWindow,
+		"quic_conn_window":   fc.QUICConnWindow,
+	} {
+		if v != nil && *v < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	if fc.RateLimit != nil {
+		rl := defaultRateLimit()
+		fc.RateLimit.apply(&rl)
+		if err := rl.Validate(); err != nil {
+			return fmt.Errorf("rate_limit: %w", err)
+		}
+	}
+	if fc.TestTokens != nil {
+		tt := defaultTestTokens()
+		fc.TestTokens.apply(&tt)
+		if err := tt.Validate(); err != nil {
+			return fmt.Errorf("test_tokens: %w", err)
+		}
+	}
+	if fc.TracingSampleRatio != nil && (*fc.TracingSampleRatio < 0 || *fc.TracingSampleRatio > 1) {
+		return errors.New("tracing_sample_ratio must be between 0 and 1")
+	}
+	if fc.Log != nil {
+		if _, _, err := fc.Log.parseLevels(); err != nil {
+			return fmt.Errorf("log: %w", err)
+		}
+		l := defaultLog()
+		fc.Log.apply(&l)
+		if err := l.Validate(); err != nil {
+			return fmt.Errorf("log: %w", err)
+		}
+	}
+	if fc.MaxTurnTTL != nil && time.Duration(*fc.MaxTurnTTL) < time.Second {
+		return errors.New("max_turn_ttl must be at least 1s")
+	}
+	if fc.ResultsAPIToken != nil && *fc.ResultsAPIToken != "" && len(*fc.ResultsAPIToken) < 16 {
+		return errors.New("results_api_token must be at least 16 bytes")
+	}
+	if err := ValidateAgentKeys(fc.AgentKeys); err != nil {
+		return fmt.Errorf("agent_keys: %w", err)
+	}
+	if fc.AdminToken != nil && *fc.AdminToken != "" && len(*fc.AdminToken) < 16 {
+		return errors.New("admin_token must be at least 16 bytes")
+	}
+	if fc.Mesh != nil {
+		m := defaultMesh()
+		fc.Mesh.apply(&m)
+		if err := m.Validate(); err != nil {
+			return fmt.Errorf("mesh: %w", err)
+		}
+	}
+	return nil
+}
+
+// apply copies every field set in the file onto cfg.
+func (fc *fileConfig) apply(cfg *Config) {
+	if fc.ListenAddr != nil {
+		cfg.ListenAddr = *fc.ListenAddr
+		cfg.listenAddrSet = true
+	}
+	if fc.Listeners != nil {
+		cfg.Listeners = make([]Listener, len(fc.Listeners))
+		for i, l := range fc.Listeners {
+			cfg.Listeners[i] = Listener(l)
+		}
+	}
+	setString(&cfg.TLSCertFile, fc.TLSCertFile)
+	setString(&cfg.TLSKeyFile, fc.TLSKeyFile)
+	setBool(&cfg.ACME, fc.ACME)
+	setString(&cfg.ACMEDirectoryURL, fc.ACMEDirectoryURL)
+	setString(&cfg.ACMEEmail, fc.ACMEEmail)
+	setString(&cfg.ACMECacheDir, fc.ACMECacheDir)
+	setString(&cfg.ACMEHTTPAddr, fc.ACMEHTTPAddr)
+	setString(&cfg.ACMECAFile, fc.ACMECAFile)
+	setBool(&cfg.EnableH2C, fc.EnableH2C)
+	setString(&cfg.HTTP1Addr, fc.HTTP1Addr)
+	setString(&cfg.HTTP2Addr, fc.HTTP2Addr)
+	setSize(&cfg.TCPSendBufSize, fc.TCPSendBufSize)
+	setSize(&cfg.TCPRecvBufSize, fc.TCPRecvBufSize)
+	setBool(&cfg.TCPNoDelay, fc.TCPNoDelay)
+	setString(&cfg.TCPCongestion, fc.TCPCongestion)
+	if fc.TCPCongestionAllowed != nil {
+		cfg.TCPCongestionAllowed = fc.TCPCongestionAllowed
+	}
+	setDuration(&cfg.TCPInfoInterval, fc.TCPInfoInterval)
+	setBool(&cfg.HTTP3, fc.HTTP3)
+	setString(&cfg.HTTP3Addr, fc.HTTP3Addr)
+	setSize(&cfg.QUICUDPBufSize, fc.QUICUDPBufSize)
+	setSize(&cfg.QUICStreamWindow, fc.QUICStreamWindow)
+	setSize(&cfg.QUICConnWindow, fc.QUICConnWindow)
+	setInt(&cfg.MaxConcurrentTests, fc.MaxConcurrentTests)
+	setInt64(&cfg.MaxEgressMbps, fc.MaxEgressMbps)
+	setInt64(&cfg.MaxIngressMbps, fc.MaxIngressMbps)
+	setDuration(&cfg.QueueTimeout, fc.QueueTimeout)
+	setInt(&cfg.MaxQueuedTests, fc.MaxQueuedTests)
+	setSize(&cfg.MaxBytes, fc.MaxBytes)
+	setDuration(&cfg.ReadTimeout, fc.ReadTimeout)
+	setDuration(&cfg.WriteTimeout, fc.WriteTimeout)
+	setDuration(&cfg.IdleTimeout, fc.IdleTimeout)
+	setBool(&cfg.EnableServerTiming, fc.EnableServerTiming)
+	setBool(&cfg.EnableCORS, fc.EnableCORS)
+	if fc.AllowedOrigins != nil {
+		cfg.AllowedOrigins = fc.AllowedOrigins
+	}
+	setString(&cfg.LocationsFile, fc.LocationsFile)
+	setString(&cfg.GeoIPDatabasePath, fc.GeoIPDatabasePath)
+	setBool(&cfg.TrustProxyHeaders, fc.TrustProxyHeaders)
+	setString(&cfg.Hostname, fc.Hostname)
+	setString(&cfg.Colo, fc.Colo)
+	setString(&cfg.TurnSecret, fc.TurnSecret)
+	if fc.TurnServers != nil {
+		cfg.TurnServers = fc.TurnServers
+	}
+	setString(&cfg.TurnRealm, fc.TurnRealm)
+	if fc.MaxTurnTTL != nil {
+		cfg.MaxTurnTTL = int64(time.Duration(*fc.MaxTurnTTL) / time.Second)
+	}
+	setBool(&cfg.EmbeddedTurn, fc.EmbeddedTurn)
+	setString(&cfg.EmbeddedTurnAddr, fc.EmbeddedTurnAddr)
+	setString(&cfg.EmbeddedTurnPublicIP, fc.EmbeddedTurnPublicIP)
+	setString(&cfg.WebDir, fc.WebDir)
+	if fc.RateLimit != nil {
+		fc.RateLimit.apply(&cfg.RateLimit)
+	}
+	if fc.TestTokens != nil {
+		fc.TestTokens.apply(&cfg.TestTokens)
+	}
+	setBool(&cfg.Metrics, fc.Metrics)
+	setString(&cfg.MetricsAddr, fc.MetricsAddr)
+	setString(&cfg.TracingEndpoint, fc.TracingEndpoint)
+	if fc.TracingSampleRatio != nil {
+		cfg.TracingSampleRatio = *fc.TracingSampleRatio
+	}
+	if fc.Log != nil {
+		fc.Log.apply(&cfg.Log)
+	}
+	setString(&cfg.ResultsDB, fc.ResultsDB)
+	setString(&cfg.ResultsAPIToken, fc.ResultsAPIToken)
+	if fc.AgentKeys != nil {
+		cfg.AgentKeys = fc.AgentKeys
+	}
+	setString(&cfg.AdminToken, fc.AdminToken)
+	if fc.Mesh != nil {
+		fc.Mesh.apply(&cfg.Mesh)
+	}
+}
+
+func setString(dst *string, src *string) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+func setBool(dst *bool, src *bool) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+func setInt(dst *int, src *int) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+func setInt64(dst *int64, src *int64) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+func setSize(dst *int64, src *ByteSize) {
+	if src != nil {
+		*dst = int64(*src)
+	}
+}
+
+func setDuration(dst *time.Duration, src *Duration) {
+	if src != nil {
+		*dst = time.Duration(*src)
+	}
+}
+
+func kindName(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		return "a list"
+	case yaml.MappingNode:
+		return "a mapping"
+	default:
+		return "a non-scalar value"
+	}
+}
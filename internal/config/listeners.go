@@ -0,0 +1,187 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Listener describes one socket the server accepts connections on.
+type Listener struct {
+	// Addr is host:port for TCP networks, or a filesystem path for unix
+	Addr string
+	// Network is tcp (default), tcp4, tcp6 or unix
+	Network string
+	// TLS serves HTTPS on this listener using the configured certificate
+	// (tls_cert_file/tls_key_file or acme)
+	TLS bool
+	// Protocol pins the HTTP version: "" (HTTP/1.1 and HTTP/2), "http1" or "http2"
+	Protocol string
+	// SendBufSize and RecvBufSize override the TCP socket buffer sizes
+	// for this listener; 0 uses the server defaults
+	SendBufSize int64
+	RecvBufSize int64
+	// Congestion overrides TCPCongestion for this listener
+	Congestion string
+}
+
+// EffectiveListeners returns the listeners the server should bind. An explicit
+// Listeners list wins; otherwise they are derived from ListenAddr,
+// HTTP1Addr and HTTP2Addr.
+func (c *Config) EffectiveListeners() []Listener {
+	if len(c.Listeners) > 0 {
+		return c.Listeners
+	}
+
+	tls := c.TLSEnabled()
+	listeners := []Listener{{Addr: c.ListenAddr, Network: "tcp", TLS: tls}}
+	if c.HTTP1Addr != "" {
+		listeners = append(listeners, Listener{Addr: c.HTTP1Addr, Network: "tcp", TLS: tls, Protocol: "http1"})
+	}
+	if c.HTTP2Addr != "" {
+		listeners = append(listeners, Listener{Addr: c.HTTP2Addr, Network: "tcp", TLS: tls, Protocol: "http2"})
+	}
+	return listeners
+}
+
+// Validate checks that the listener definition is usable.
+func (l *Listener) Validate() error {
+	if l.Addr == "" {
+		return fmt.Errorf("listener address is required")
+	}
+	switch l.Network {
+	case "tcp", "tcp4", "tcp6", "unix":
+	default:
+		return fmt.Errorf("listener %s: unknown network %q (want tcp, tcp4, tcp6 or unix)", l.Addr, l.Network)
+	}
+	switch l.Protocol {
+	case "", "http1", "http2":
+	default:
+		return fmt.Errorf("listener %s: unknown protocol %q (want http1 or http2)", l.Addr, l.Protocol)
+	}
+	if l.SendBufSize < 0 || l.RecvBufSize < 0 {
+		return fmt.Errorf("listener %s: buffer sizes must not be negative", l.Addr)
+	}
+	if l.Congestion != "" {
+		if l.Network == "unix" {
+			return fmt.Errorf("listener %s: congestion control needs a TCP network", l.Addr)
+		}
+		if err := ValidateCongestion(l.Congestion); err != nil {
+			return fmt.Errorf("listener %s: %w", l.Addr, err)
+		}
+	}
+	return nil
+}
+
+// maxCongestionName is TCP_CA_NAME_MAX minus the terminating NUL.
+const maxCongestionName = 15
+
+// ValidateCongestion checks that name looks like a TCP congestion control
+// algorithm. Whether the kernel actually provides it is only known once a
+// socket is configured.
+func ValidateCongestion(name string) error {
+	if name == "" || len(name) > maxCongestionName {
+		return fmt.Errorf("invalid congestion control %q", name)
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return fmt.Errorf("invalid congestion control %q", name)
+		}
+	}
+	return nil
+}
+
+// String formats the listener in the syntax accepted by ParseListener.
+func (l Listener) String() string {
+	var q []string
+	if l.TLS {
+		q = append(q, "tls=true")
+	}
+	if l.Protocol != "" {
+		q = append(q, "proto="+l.Protocol)
+	}
+	if l.SendBufSize > 0 {
+		q = append(q, "sndbuf="+strconv.FormatInt(l.SendBufSize, 10))
+	}
+	if l.RecvBufSize > 0 {
+		q = append(q, "rcvbuf="+strconv.FormatInt(l.RecvBufSize, 10))
+	}
+	if l.Congestion != "" {
+		q = append(q, "cc="+l.Congestion)
+	}
+	s := l.Network + "://" + l.Addr
+	if len(q) > 0 {
+		s += "?" + strings.Join(q, "&")
+	}
+	return s
+}
+
+// ParseListener parses a compact listener spec of the form
+//
+//	[network://]addr[?tls=true&proto=http1&sndbuf=4MiB&rcvbuf=4MiB&cc=bbr]
+//
+// e.g. ":8080", "tcp6://[::]:443?tls=true" or "unix:///run/netspeedd.sock".
+func ParseListener(spec string) (Listener, error) {
+	l := Listener{Network: "tcp"}
+
+	rest := strings.TrimSpace(spec)
+	if i := strings.Index(rest, "://"); i >= 0 {
+		l.Network, rest = rest[:i], rest[i+3:]
+	}
+
+	query := ""
+	if i := strings.Index(rest, "?"); i >= 0 {
+		rest, query = rest[:i], rest[i+1:]
+	}
+	l.Addr = rest
+
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return Listener{}, fmt.Errorf("invalid listener %q: %w", spec, err)
+	}
+	for key, values := range params {
+		v := values[len(values)-1]
+		switch key {
+		case "tls":
+			if l.TLS, err = strconv.ParseBool(v); err != nil {
+				return Listener{}, fmt.Errorf("invalid listener %q: tls must be true or false", spec)
+			}
+		case "proto":
+			l.Protocol = v
+		case "sndbuf":
+			if l.SendBufSize, err = ParseSize(v); err != nil {
+				return Listener{}, fmt.Errorf("invalid listener %q: %w", spec, err)
+			}
+		case "rcvbuf":
+			if l.RecvBufSize, err = ParseSize(v); err != nil {
+				return Listener{}, fmt.Errorf("invalid listener %q: %w", spec, err)
+			}
+		case "cc":
+			l.Congestion = v
+		default:
+			return Listener{}, fmt.Errorf("invalid listener %q: unknown option %q", spec, key)
+		}
+	}
+
+	if err := l.Validate(); err != nil {
+		return Listener{}, err
+	}
+	return l, nil
+}
+
+// ParseListeners parses a comma-separated list of listener specs.
+func ParseListeners(specs string) ([]Listener, error) {
+	var listeners []Listener
+	for _, spec := range strings.Split(specs, ",") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		l, err := ParseListener(spec)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
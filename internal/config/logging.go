@@ -0,0 +1,165 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Log output formats.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// logSubsystems are the subsystems that can have their own log level.
+var logSubsystems = []string{"server", "webrtc", "turn", "meta", "mesh"}
+
+// logEvents are the high-volume events that can be sampled.
+var logEvents = []string{"latency_probe", "turn_auth"}
+
+// Log configures the structured log written to stderr.
+type Log struct {
+	// Format is LogFormatText (default) or LogFormatJSON
+	Format string
+	// Level is the minimum level logged by subsystems without an entry in
+	// Levels
+	Level slog.Level
+	// Levels overrides Level for a subsystem (server, webrtc, turn, meta,
+	// mesh)
+	Levels map[string]slog.Level
+	// Sampling logs one in N occurrences of a high-volume event
+	// (latency_probe, turn_auth); 1 logs every one
+	Sampling map[string]int
+}
+
+// defaultLog keeps one in a hundred latency probes: a browser test sends
+// dozens of them, and each is a line otherwise.
+func defaultLog() Log {
+	return Log{
+		Format: LogFormatText,
+		Level:  slog.LevelInfo,
+		Sampling: map[string]int{
+			"latency_probe": 100,
+			"turn_auth":     10,
+		},
+	}
+}
+
+// Validate checks the format, subsystem names and sampling rates.
+func (l *Log) Validate() error {
+	if l.Format != LogFormatText && l.Format != LogFormatJSON {
+		return fmt.Errorf("unknown format %q (want %q or %q)", l.Format, LogFormatText, LogFormatJSON)
+	}
+	for name := range l.Levels {
+		if !slices.Contains(logSubsystems, name) {
+			return fmt.Errorf("unknown subsystem %q in levels (want one of %s)", name, strings.Join(logSubsystems, ", "))
+		}
+	}
+	for event, n := range l.Sampling {
+		if !slices.Contains(logEvents, event) {
+			return fmt.Errorf("unknown event %q in sampling (want one of %s)", event, strings.Join(logEvents, ", "))
+		}
+		if n < 1 {
+			return fmt.Errorf("sampling rate of %s must be at least 1", event)
+		}
+	}
+	return nil
+}
+
+// ParseLogLevel parses a level spec: a default level followed by optional
+// per-subsystem overrides, e.g. "info,webrtc=debug,turn=warn". Either part
+// may be left out.
+func ParseLogLevel(spec string) (level *slog.Level, levels map[string]slog.Level, err error) {
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			var l slog.Level
+			if err := l.UnmarshalText([]byte(part)); err != nil {
+				return nil, nil, fmt.Errorf("invalid log level %q", part)
+			}
+			level = &l
+			continue
+		}
+		if !slices.Contains(logSubsystems, name) {
+			return nil, nil, fmt.Errorf("unknown log subsystem %q (want one of %s)", name, strings.Join(logSubsystems, ", "))
+		}
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(value)); err != nil {
+			return nil, nil, fmt.Errorf("invalid log level %q for %s", value, name)
+		}
+		if levels == nil {
+			levels = make(map[string]slog.Level)
+		}
+		levels[name] = l
+	}
+	return level, levels, nil
+}
+
+// SetLevel applies a level spec parsed by ParseLogLevel. Subsystem levels
+// are added to those already set.
+func (l *Log) SetLevel(level *slog.Level, levels map[string]slog.Level) {
+	if level != nil {
+		l.Level = *level
+	}
+	if len(levels) > 0 {
+		l.Levels = maps.Clone(l.Levels)
+		if l.Levels == nil {
+			l.Levels = make(map[string]slog.Level)
+		}
+		maps.Copy(l.Levels, levels)
+	}
+}
+
+// fileLog is the log section of the config file.
+type fileLog struct {
+	Format   *string           `yaml:"format"`
+	Level    *string           `yaml:"level"`
+	Levels   map[string]string `yaml:"levels"`
+	Sampling map[string]int    `yaml:"sampling"`
+}
+
+// parseLevels parses the level keys of the section.
+func (f *fileLog) parseLevels() (*slog.Level, map[string]slog.Level, error) {
+	var level *slog.Level
+	if f.Level != nil {
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(*f.Level)); err != nil {
+			return nil, nil, fmt.Errorf("invalid level %q", *f.Level)
+		}
+		level = &l
+	}
+	var levels map[string]slog.Level
+	for name, value := range f.Levels {
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(value)); err != nil {
+			return nil, nil, fmt.Errorf("invalid level %q for %s", value, name)
+		}
+		if levels == nil {
+			levels = make(map[string]slog.Level)
+		}
+		levels[name] = l
+	}
+	return level, levels, nil
+}
+
+// apply copies the keys set in the file onto l. Sampling rates are merged
+// with the defaults, so setting one event keeps the others sampled.
+func (f *fileLog) apply(l *Log) {
+	setString(&l.Format, f.Format)
+	level, levels, _ := f.parseLevels()
+	l.SetLevel(level, levels)
+	if f.Sampling != nil {
+		l.Sampling = maps.Clone(l.Sampling)
+		if l.Sampling == nil {
+			l.Sampling = make(map[string]int)
+		}
+		maps.Copy(l.Sampling, f.Sampling)
+	}
+}
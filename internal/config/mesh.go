@@ -0,0 +1,156 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// MeshIATAPlaceholder is replaced with a location's lowercased IATA code
+// in Mesh.URLTemplate.
+const MeshIATAPlaceholder = "{iata}"
+
+// Mesh configures the measurements this node runs against its peers: the
+// other nodes of the locations store.
+type Mesh struct {
+	Enabled bool
+	// Interval is the time between rounds; each round tests every peer
+	// in turn
+	Interval time.Duration
+	// URLTemplate is the base URL of the node of a location without a
+	// url of its own, e.g. "https://{iata}.speed.example.com"
+	URLTemplate string
+	// Peers maps IATA codes to base URLs, overriding the locations
+	// store. Peers missing from it are tested too.
+	Peers map[string]string
+	// Exclude lists IATA codes not to test
+	Exclude []string
+	// Duration is how long each direction transfers
+	Duration time.Duration
+	// TestBytes caps the bytes one direction may move per peer and round
+	TestBytes int64
+	// DailyBytes caps the bytes moved per UTC day, across all peers; once
+	// it is spent only latency is measured. 0 means no cap.
+	DailyBytes int64
+	// PacketLoss runs the WebRTC packet loss test against each peer too
+	PacketLoss bool
+	// HistoryDB is the file measurements are kept in; empty keeps them
+	// in memory only
+	HistoryDB string
+	// Retention is how long measurements are kept
+	Retention time.Duration
+}
+
+// defaultMesh tests every half hour with a few seconds and at most 100 MB
+// per direction, which keeps a round cheap even with many peers.
+func defaultMesh() Mesh {
+	return Mesh{
+		Interval:   30 * time.Minute,
+		Duration:   5 * time.Second,
+		TestBytes:  100e6,
+		DailyBytes: 50e9,
+		Retention:  30 * 24 * time.Hour,
+	}
+}
+
+// Validate checks the interval, template, peers and budgets.
+func (m *Mesh) Validate() error {
+	if m.Interval < time.Minute {
+		return errors.New("interval must be at least 1m")
+	}
+	if m.URLTemplate != "" {
+		if !strings.Contains(m.URLTemplate, MeshIATAPlaceholder) {
+			return fmt.Errorf("url_template must contain %s", MeshIATAPlaceholder)
+		}
+		if err := validPeerURL(strings.ReplaceAll(m.URLTemplate, MeshIATAPlaceholder, "x")); err != nil {
+			return fmt.Errorf("url_template: %w", err)
+		}
+	}
+	for iata, u := range m.Peers {
+		if iata == "" {
+			return errors.New("peers: empty IATA code")
+		}
+		if err := validPeerURL(u); err != nil {
+			return fmt.Errorf("peer %s: %w", iata, err)
+		}
+	}
+	if m.Duration < time.Second || m.Duration > time.Minute {
+		return errors.New("duration must be between 1s and 1m")
+	}
+	if m.TestBytes <= 0 {
+		return errors.New("test_bytes must be positive")
+	}
+	if m.DailyBytes < 0 {
+		return errors.New("daily_bytes must not be negative")
+	}
+	if m.Retention < time.Hour {
+		return errors.New("retention must be at least 1h")
+	}
+	return nil
+}
+
+// PeerURL returns the base URL of the node at iata, whose location gives
+// base, if any, and false if it has none or is excluded.
+func (m *Mesh) PeerURL(iata, base string) (string, bool) {
+	for _, x := range m.Exclude {
+		if strings.EqualFold(x, iata) {
+			return "", false
+		}
+	}
+	for k, u := range m.Peers {
+		if strings.EqualFold(k, iata) {
+			return u, true
+		}
+	}
+	if base != "" {
+		return base, true
+	}
+	if m.URLTemplate == "" {
+		return "", false
+	}
+	return strings.ReplaceAll(m.URLTemplate, MeshIATAPlaceholder, strings.ToLower(iata)), true
+}
+
+func validPeerURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid URL %q (want http(s)://host[:port])", s)
+	}
+	return nil
+}
+
+// fileMesh is the mesh section of the config file.
+type fileMesh struct {
+	Enabled     *bool             `yaml:"enabled"`
+	Interval    *Duration         `yaml:"interval"`
+	URLTemplate *string           `yaml:"url_template"`
+	Peers       map[string]string `yaml:"peers"`
+	Exclude     []string          `yaml:"exclude"`
+	Duration    *Duration         `yaml:"duration"`
+	TestBytes   *ByteSize         `yaml:"test_bytes"`
+	DailyBytes  *ByteSize         `yaml:"daily_bytes"`
+	PacketLoss  *bool             `yaml:"packet_loss"`
+	HistoryDB   *string           `yaml:"history_db"`
+	Retention   *Duration         `yaml:"retention"`
+}
+
+// apply copies the keys set in the file onto m.
+func (f *fileMesh) apply(m *Mesh) {
+	setBool(&m.Enabled, f.Enabled)
+	setDuration(&m.Interval, f.Interval)
+	setString(&m.URLTemplate, f.URLTemplate)
+	if f.Peers != nil {
+		m.Peers = f.Peers
+	}
+	if f.Exclude != nil {
+		m.Exclude = f.Exclude
+	}
+	setDuration(&m.Duration, f.Duration)
+	setSize(&m.TestBytes, f.TestBytes)
+	setSize(&m.DailyBytes, f.DailyBytes)
+	setBool(&m.PacketLoss, f.PacketLoss)
+	setString(&m.HistoryDB, f.HistoryDB)
+	setDuration(&m.Retention, f.Retention)
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// RateLimitDefault is the Endpoints key whose limits apply to every
+// measurement endpoint without its own entry.
+const RateLimitDefault = "*"
+
+// rateLimitEndpoints are the paths rate limits can be attached to.
+var rateLimitEndpoints = []string{"/__down", "/__up", "/api/packet-test/offer", "/api/tests", "/api/results"}
+
+// RateLimit configures per-client request and data limits on the
+// measurement endpoints.
+type RateLimit struct {
+	Enabled bool
+	// IPv4Prefix and IPv6Prefix set the prefix length that clients are
+	// grouped by for the "prefix" limits
+	IPv4Prefix int
+	IPv6Prefix int
+	// Exempt lists CIDRs (or single addresses) that are never limited,
+	// e.g. monitoring agents
+	Exempt []string
+	// Endpoints maps an endpoint path, or RateLimitDefault, to its limits
+	Endpoints map[string]EndpointLimits
+}
+
+// EndpointLimits are the limits for one endpoint, applied separately to
+// each client IP, each client prefix and each client ASN.
+type EndpointLimits struct {
+	IP     ClientLimits
+	Prefix ClientLimits
+	ASN    ClientLimits
+}
+
+// ClientLimits caps one client key; zero values are unlimited.
+type ClientLimits struct {
+	RequestsPerMinute int64
+	BytesPerHour      int64
+	BytesPerDay       int64
+}
+
+// defaultRateLimit is used when rate limiting is switched on without
+// configuring endpoints. It leaves room for a full browser test every few
+// minutes while stopping a client from pulling /__down in a loop.
+func defaultRateLimit() RateLimit {
+	perIP := ClientLimits{
+		RequestsPerMinute: 600,
+		BytesPerHour:      20 << 30,  // 20 GiB
+		BytesPerDay:       100 << 30, // 100 GiB
+	}
+	return RateLimit{
+		IPv4Prefix: 24,
+		IPv6Prefix: 48,
+		Endpoints: map[string]EndpointLimits{
+			"/__down": {IP: perIP},
+			"/__up":   {IP: perIP},
+			// A token already carries a byte budget; cap how fast new
+			// ones can be fetched
+			"/api/tests": {IP: ClientLimits{RequestsPerMinute: 30}},
+			// Every stored result takes up disk space
+			"/api/results": {IP: ClientLimits{RequestsPerMinute: 10}},
+		},
+	}
+}
+
+// ExemptPrefixes parses Exempt. Single addresses become host prefixes.
+func (r *RateLimit) ExemptPrefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(r.Exempt))
+	for _, s := range r.Exempt {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid exempt address %q", s)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exempt CIDR %q", s)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+// Validate checks prefix lengths, exempt CIDRs, endpoint names and limits.
+func (r *RateLimit) Validate() error {
+	if r.IPv4Prefix < 1 || r.IPv4Prefix > 32 {
+		return errors.New("ipv4_prefix must be between 1 and 32")
+	}
+	if r.IPv6Prefix < 1 || r.IPv6Prefix > 128 {
+		return errors.New("ipv6_prefix must be between 1 and 128")
+	}
+	if _, err := r.ExemptPrefixes(); err != nil {
+		return err
+	}
+	for endpoint, limits := range r.Endpoints {
+		if !validRateLimitEndpoint(endpoint) {
+			return fmt.Errorf("unknown endpoint %q (want %s or %q)",
+				endpoint, strings.Join(rateLimitEndpoints, ", "), RateLimitDefault)
+		}
+		for scope, l := range map[string]ClientLimits{"ip": limits.IP, "prefix": limits.Prefix, "asn": limits.ASN} {
+			if l.RequestsPerMinute < 0 || l.BytesPerHour < 0 || l.BytesPerDay < 0 {
+				return fmt.Errorf("%s %s: limits must not be negative", endpoint, scope)
+			}
+		}
+	}
+	return nil
+}
+
+func validRateLimitEndpoint(endpoint string) bool {
+	if endpoint == RateLimitDefault {
+		return true
+	}
+	for _, e := range rateLimitEndpoints {
+		if e == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// fileRateLimit is the rate_limit section of the config file.
+type fileRateLimit struct {
+	Enabled    *bool                         `yaml:"enabled"`
+	IPv4Prefix *int                          `yaml:"ipv4_prefix"`
+	IPv6Prefix *int                          `yaml:"ipv6_prefix"`
+	Exempt     []string                      `yaml:"exempt"`
+	Endpoints  map[string]fileEndpointLimits `yaml:"endpoints"`
+}
+
+type fileEndpointLimits struct {
+	IP     fileClientLimits `yaml:"ip"`
+	Prefix fileClientLimits `yaml:"prefix"`
+	ASN    fileClientLimits `yaml:"asn"`
+}
+
+type fileClientLimits struct {
+	RequestsPerMinute int64    `yaml:"requests_per_minute"`
+	BytesPerHour      ByteSize `yaml:"bytes_per_hour"`
+	BytesPerDay       ByteSize `yaml:"bytes_per_day"`
+}
+
+func (l fileClientLimits) limits() ClientLimits {
+	return ClientLimits{
+		RequestsPerMinute: l.RequestsPerMinute,
+		BytesPerHour:      int64(l.BytesPerHour),
+		BytesPerDay:       int64(l.BytesPerDay),
+	}
+}
+
+// apply copies the keys set in the file onto r. An endpoints mapping
+// replaces the built-in endpoint limits entirely.
+func (f *fileRateLimit) apply(r *RateLimit) {
+	setBool(&r.Enabled, f.Enabled)
+	if f.IPv4Prefix != nil {
+		r.IPv4Prefix = *f.IPv4Prefix
+	}
+	if f.IPv6Prefix != nil {
+		r.IPv6Prefix = *f.IPv6Prefix
+	}
+	if f.Exempt != nil {
+		r.Exempt = f.Exempt
+	}
+	if f.Endpoints != nil {
+		r.Endpoints = make(map[string]EndpointLimits, len(f.Endpoints))
+		for endpoint, l := range f.Endpoints {
+			r.Endpoints[endpoint] = EndpointLimits{
+				IP:     l.IP.limits(),
+				Prefix: l.Prefix.limits(),
+				ASN:    l.ASN.limits(),
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Test token signing algorithms.
+const (
+	TokenAlgHMAC    = "hmac"
+	TokenAlgEd25519 = "ed25519"
+)
+
+// TestTokens configures signed test tokens. When enabled, clients get a
+// short-lived token from POST /api/tests and must present it on /__down,
+// /__up and /api/packet-test/offer.
+type TestTokens struct {
+	Enabled bool
+	// Algorithm is TokenAlgHMAC (default) or TokenAlgEd25519
+	Algorithm string
+	// Secret is the HMAC key; a random one is generated at startup if empty
+	Secret string
+	// KeyFile is a PEM (PKCS#8) Ed25519 private key; a key is generated at
+	// startup if empty
+	KeyFile string
+	// PublicKeyFiles are PEM Ed25519 public keys whose tokens are accepted
+	// too, e.g. those of a central issuer
+	PublicKeyFiles []string
+	// TTL is how long a token is valid
+	TTL time.Duration
+	// ByteBudget is how many bytes one token may transfer in total
+	ByteBudget int64
+	// Origins are browser origins besides this node's own that may request
+	// tokens, or "*" for any
+	Origins []string
+}
+
+// defaultTestTokens leaves room for a full browser test on a fast line.
+func defaultTestTokens() TestTokens {
+	return TestTokens{
+		Algorithm:  TokenAlgHMAC,
+		TTL:        10 * time.Minute,
+		ByteBudget: 10 << 30, // 10 GiB
+	}
+}
+
+// Validate checks the algorithm, lifetime, budget and origins.
+func (t *TestTokens) Validate() error {
+	switch t.Algorithm {
+	case TokenAlgHMAC:
+		if t.Secret != "" && len(t.Secret) < 16 {
+			return errors.New("secret must be at least 16 bytes")
+		}
+	case TokenAlgEd25519:
+	default:
+		return fmt.Errorf("unknown algorithm %q (want %q or %q)", t.Algorithm, TokenAlgHMAC, TokenAlgEd25519)
+	}
+	if t.TTL < time.Second {
+		return errors.New("ttl must be at least 1s")
+	}
+	if t.ByteBudget <= 0 {
+		return errors.New("byte_budget must be positive")
+	}
+	for _, o := range t.Origins {
+		if o == "*" {
+			continue
+		}
+		if u, err := url.Parse(o); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid origin %q (want scheme://host[:port])", o)
+		}
+	}
+	return nil
+}
+
+// OriginAllowed reports whether a browser at origin may request tokens
+// from a node reached as host.
+func (t *TestTokens) OriginAllowed(origin, host string) bool {
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, host) {
+		return true
+	}
+	for _, o := range t.Origins {
+		if o == "*" || strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileTestTokens is the test_tokens section of the config file.
+type fileTestTokens struct {
+	Enabled        *bool     `yaml:"enabled"`
+	Algorithm      *string   `yaml:"algorithm"`
+	Secret         *string   `yaml:"secret"`
+	KeyFile        *string   `yaml:"key_file"`
+	PublicKeyFiles []string  `yaml:"public_key_files"`
+	TTL            *Duration `yaml:"ttl"`
+	ByteBudget     *ByteSize `yaml:"byte_budget"`
+	Origins        []string  `yaml:"origins"`
+}
+
+// apply copies the keys set in the file onto t.
+func (f *fileTestTokens) apply(t *TestTokens) {
+	setBool(&t.Enabled, f.Enabled)
+	setString(&t.Algorithm, f.Algorithm)
+	setString(&t.Secret, f.Secret)
+	setString(&t.KeyFile, f.KeyFile)
+	if f.PublicKeyFiles != nil {
+		t.PublicKeyFiles = f.PublicKeyFiles
+	}
+	setDuration(&t.TTL, f.TTL)
+	setSize(&t.ByteBudget, f.ByteBudget)
+	if f.Origins != nil {
+		t.Origins = f.Origins
+	}
+}
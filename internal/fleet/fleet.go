@@ -0,0 +1,232 @@
+// Package fleet keeps track of the monitoring agents that report to a node
+// and of the test jobs dispatched to them.
+//
+// Agents announce themselves with heartbeats that carry their labels, and
+// long-poll for jobs. A job is created for a set of agents, picked by ID or
+// by label, and becomes one task per agent; each agent gets its tasks in
+// order the next time it polls and reports the outcome under the job's ID.
+//
+// The registry lives in memory. After a restart it fills up again as
+// agents send their next heartbeat; jobs still waiting are lost.
+package fleet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yellowman/netspeed/api"
+)
+
+// OfflineAfter is how long an agent can go unseen before it is listed as
+// offline; agents send a heartbeat every minute.
+const OfflineAfter = 3 * time.Minute
+
+// Label limits.
+const (
+	maxLabels   = 32
+	maxLabelLen = 64
+)
+
+// ErrClosed is returned by Next once the registry is closed.
+var ErrClosed = errors.New("registry closed")
+
+// Registry holds the agents and jobs. It is safe for concurrent use.
+type Registry struct {
+	mu     sync.Mutex
+	agents map[string]*agent
+	jobs   map[string]*job
+	// order lists job IDs oldest first
+	order  []string
+	closed chan struct{}
+	once   sync.Once
+}
+
+// agent is the registry's view of one agent.
+type agent struct {
+	status api.AgentStatus
+	// queue holds the agent's queued tasks, oldest first
+	queue []*task
+	// wake is closed, and replaced, when a task is queued for the agent
+	wake    chan struct{}
+	polling int
+}
+
+// New returns an empty registry.
+func New() *Registry {
+	return &Registry{
+		agents: make(map[string]*agent),
+		jobs:   make(map[string]*job),
+		closed: make(chan struct{}),
+	}
+}
+
+// Close wakes every agent waiting in Next, so that the server can shut
+// down without waiting out their polls.
+func (r *Registry) Close() {
+	r.once.Do(func() { close(r.closed) })
+}
+
+// ValidateLabels checks the number and length of labels.
+func ValidateLabels(labels map[string]string) error {
+	if len(labels) > maxLabels {
+		return fmt.Errorf("at most %d labels", maxLabels)
+	}
+	for k, v := range labels {
+		if k == "" || len(k) > maxLabelLen || len(v) > maxLabelLen {
+			return fmt.Errorf("label %q: keys must be 1-%d bytes and values at most %d", k, maxLabelLen, maxLabelLen)
+		}
+	}
+	return nil
+}
+
+// agentLocked returns agent id, adding it if need be. An agent added for
+// a job before it has been in touch has no FirstSeen.
+func (r *Registry) agentLocked(id string) *agent {
+	a := r.agents[id]
+	if a == nil {
+		a = &agent{
+			status: api.AgentStatus{ID: id, Labels: map[string]string{}},
+			wake:   make(chan struct{}),
+		}
+		r.agents[id] = a
+	}
+	return a
+}
+
+// seenLocked records contact from the agent at now.
+func (a *agent) seenLocked(client api.ClientMeta, now time.Time) {
+	if a.status.FirstSeen == nil {
+		a.status.FirstSeen = &now
+	}
+	a.status.Client = &client
+	a.status.LastSeen = &now
+}
+
+// onlineLocked reports whether the agent was seen recently.
+func (a *agent) onlineLocked(now time.Time) bool {
+	return a.status.LastSeen != nil && now.Sub(*a.status.LastSeen) < OfflineAfter
+}
+
+// Heartbeat records a heartbeat from agent id, sent from a connection
+// described by client.
+func (r *Registry) Heartbeat(id string, hb api.AgentHeartbeat, client api.ClientMeta) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	a := r.agentLocked(id)
+	a.seenLocked(client, now)
+	a.status.Labels = maps.Clone(hb.Labels)
+	if a.status.Labels == nil {
+		a.status.Labels = map[string]string{}
+	}
+	a.status.Version = hb.Version
+	a.status.Nodes = slices.Clone(hb.Nodes)
+	a.status.Schedule = hb.Schedule
+	a.status.LastHeartbeat = &now
+}
+
+// Seen records other contact from agent id, such as a poll or a push.
+func (r *Registry) Seen(id string, client api.ClientMeta) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.agentLocked(id).seenLocked(client, time.Now())
+}
+
+// Agents returns every agent that has been in touch, by ID.
+func (r *Registry) Agents() []api.AgentStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	r.sweepLocked(now)
+	list := make([]api.AgentStatus, 0, len(r.agents))
+	for _, a := range r.agents {
+		list = append(list, a.statusLocked(now))
+	}
+	slices.SortFunc(list, func(a, b api.AgentStatus) int { return strings.Compare(a.ID, b.ID) })
+	return list
+}
+
+// Agent returns agent id, if it has been in touch.
+func (r *Registry) Agent(id string) (api.AgentStatus, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	r.sweepLocked(now)
+	a := r.agents[id]
+	if a == nil {
+		return api.AgentStatus{}, false
+	}
+	return a.statusLocked(now), true
+}
+
+func (a *agent) statusLocked(now time.Time) api.AgentStatus {
+	s := a.status
+	s.Labels = maps.Clone(s.Labels)
+	s.Nodes = slices.Clone(s.Nodes)
+	s.Online = a.onlineLocked(now)
+	s.Polling = a.polling > 0
+	s.QueuedJobs = len(a.queue)
+	return s
+}
+
+// matches reports whether the agent's labels include all of selector.
+func (a *agent) matches(selector map[string]string) bool {
+	for k, v := range selector {
+		if a.status.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Next returns the next job queued for agent id, waiting up to wait for
+// one to be queued. It returns nil if none came, and ErrClosed once the
+// registry is closed.
+func (r *Registry) Next(ctx context.Context, id string, wait time.Duration, client api.ClientMeta) (*api.AgentJob, error) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		r.mu.Lock()
+		now := time.Now()
+		r.sweepLocked(now)
+		a := r.agentLocked(id)
+		a.seenLocked(client, now)
+		if len(a.queue) > 0 {
+			t := a.queue[0]
+			a.queue = a.queue[1:]
+			aj := t.dispatchLocked(now)
+			r.mu.Unlock()
+			return aj, nil
+		}
+		wake := a.wake
+		a.polling++
+		r.mu.Unlock()
+
+		var err error
+		timedOut := false
+		select {
+		case <-wake:
+		case <-timer.C:
+			timedOut = true
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-r.closed:
+			err = ErrClosed
+		}
+
+		r.mu.Lock()
+		a.polling--
+		seen := time.Now()
+		a.status.LastSeen = &seen
+		r.mu.Unlock()
+		if err != nil || timedOut {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package fleet
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/yellowman/netspeed/api"
+	"github.com/yellowman/netspeed/speedtest"
+)
+
+// Job limits and timeouts.
+const (
+	// DefaultDuration is the duration of a timed test that does not set
+	// one; MaxDuration is the longest allowed
+	DefaultDuration = 10 * time.Second
+	MaxDuration     = 5 * time.Minute
+	// fullTestTime is what a full test is allowed for its deadline
+	fullTestTime = 2 * time.Minute
+	// reportGrace is added to the test time for an agent to report back
+	reportGrace = 2 * time.Minute
+	// queueTTL is how long a task waits for an agent that does not poll
+	queueTTL = time.Hour
+	// maxJobs is how many jobs are kept; the oldest finished ones go
+	// first
+	maxJobs = 1000
+)
+
+// Errors returned for jobs.
+var (
+	ErrUnknownJob = errors.New("no such job")
+	ErrNoAgents   = errors.New("no agents match")
+	ErrTaskState  = errors.New("task is not running")
+)
+
+// job is a test dispatched to a set of agents.
+type job struct {
+	id        string
+	createdAt time.Time
+	spec      api.JobSpec
+	labels    map[string]string
+	// tasks are in the order the agents were picked
+	tasks []*task
+}
+
+// task is a job on one agent.
+type task struct {
+	job          *job
+	agent        string
+	state        string
+	queuedAt     time.Time
+	dispatchedAt time.Time
+	finishedAt   time.Time
+	deadline     time.Time
+	resultID     string
+	err          string
+}
+
+// ValidateSpec checks a job spec and returns the duration of its test, 0
+// for a full test.
+func ValidateSpec(spec api.JobSpec) (time.Duration, error) {
+	if !slices.Contains(api.JobTests, spec.Test) {
+		return 0, fmt.Errorf("test must be one of %v", api.JobTests)
+	}
+	if spec.Node != "" {
+		u, err := url.Parse(spec.Node)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return 0, errors.New("node must be an http(s) URL")
+		}
+	}
+	if spec.Profile != "" {
+		if spec.Test != api.JobTestDownload && spec.Test != api.JobTestUpload {
+			return 0, errors.New("profile only applies to download and upload tests")
+		}
+		if _, ok := speedtest.ProfileByName(spec.Test, spec.Profile); !ok {
+			return 0, fmt.Errorf("unknown %s profile %q", spec.Test, spec.Profile)
+		}
+	}
+	if spec.Test == api.JobTestFull {
+		if spec.Duration != "" {
+			return 0, errors.New("duration does not apply to full tests")
+		}
+		return 0, nil
+	}
+	if spec.Duration == "" {
+		return DefaultDuration, nil
+	}
+	d, err := time.ParseDuration(spec.Duration)
+	if err != nil || d < time.Second || d > MaxDuration {
+		return 0, fmt.Errorf("duration must be between 1s and %s", MaxDuration)
+	}
+	return d, nil
+}
+
+// CreateJob queues req for every agent it picks: those listed by ID, which
+// must pass known, and those online whose labels include req.Labels.
+func (r *Registry) CreateJob(req api.JobRequest, known func(id string) bool) (api.Job, error) {
+	if _, err := ValidateSpec(req.JobSpec); err != nil {
+		return api.Job{}, err
+	}
+	if err := ValidateLabels(req.Labels); err != nil {
+		return api.Job{}, err
+	}
+	if len(req.Agents) == 0 && len(req.Labels) == 0 {
+		return api.Job{}, errors.New("pick agents by ID, by labels or both")
+	}
+	for _, id := range req.Agents {
+		if !known(id) {
+			return api.Job{}, fmt.Errorf("unknown agent %q", id)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	r.sweepLocked(now)
+
+	picked := slices.Clone(req.Agents)
+	if len(req.Labels) > 0 {
+		var matched []string
+		for id, a := range r.agents {
+			if a.matches(req.Labels) && a.onlineLocked(now) && known(id) {
+				matched = append(matched, id)
+			}
+		}
+		slices.Sort(matched)
+		picked = append(picked, matched...)
+	}
+	slices.Sort(picked)
+	picked = slices.Compact(picked)
+	if len(picked) == 0 {
+		return api.Job{}, ErrNoAgents
+	}
+
+	j := &job{id: newJobID(), createdAt: now, spec: req.JobSpec, labels: maps.Clone(req.Labels)}
+	for _, id := range picked {
+		t := &task{job: j, agent: id, state: api.TaskQueued, queuedAt: now}
+		j.tasks = append(j.tasks, t)
+		a := r.agentLocked(id)
+		a.queue = append(a.queue, t)
+		close(a.wake)
+		a.wake = make(chan struct{})
+	}
+	r.jobs[j.id] = j
+	r.order = append(r.order, j.id)
+	r.pruneLocked()
+	return j.viewLocked(), nil
+}
+
+// Jobs returns every job, newest first.
+func (r *Registry) Jobs() []api.Job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sweepLocked(time.Now())
+	list := make([]api.Job, 0, len(r.order))
+	for i := len(r.order) - 1; i >= 0; i-- {
+		list = append(list, r.jobs[r.order[i]].viewLocked())
+	}
+	return list
+}
+
+// Job returns job id.
+func (r *Registry) Job(id string) (api.Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sweepLocked(time.Now())
+	j := r.jobs[id]
+	if j == nil {
+		return api.Job{}, ErrUnknownJob
+	}
+	return j.viewLocked(), nil
+}
+
+// Cancel cancels the tasks of job id that no agent has picked up yet.
+// Tasks already handed out run to the end.
+func (r *Registry) Cancel(id string) (api.Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	j := r.jobs[id]
+	if j == nil {
+		return api.Job{}, ErrUnknownJob
+	}
+	for _, t := range j.tasks {
+		if t.state == api.TaskQueued {
+			t.finishLocked(api.TaskCanceled, now)
+			if a := r.agents[t.agent]; a != nil {
+				a.queue = slices.DeleteFunc(a.queue, func(q *task) bool { return q == t })
+			}
+		}
+	}
+	return j.viewLocked(), nil
+}
+
+// Report records a state change that agent reported for its task of job
+// id: api.TaskRunning when it starts, api.TaskFailed if it fails.
+func (r *Registry) Report(agent, id string, rep api.JobReport) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t := r.taskLocked(agent, id)
+	if t == nil {
+		return ErrUnknownJob
+	}
+	if t.state != api.TaskDispatched && t.state != api.TaskRunning {
+		return ErrTaskState
+	}
+	switch rep.State {
+	case api.TaskRunning:
+		t.state = api.TaskRunning
+	case api.TaskFailed:
+		t.err = rep.Error
+		t.finishLocked(api.TaskFailed, time.Now())
+	default:
+		return fmt.Errorf("state must be %s or %s", api.TaskRunning, api.TaskFailed)
+	}
+	return nil
+}
+
+// Complete records that agent pushed resultID for job id. A result that
+// arrives after the task expired still completes it. It reports whether
+// the agent had such a task.
+func (r *Registry) Complete(agent, id, resultID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t := r.taskLocked(agent, id)
+	if t == nil || t.state == api.TaskQueued || t.state == api.TaskCanceled {
+		return false
+	}
+	t.resultID = resultID
+	t.err = ""
+	t.finishLocked(api.TaskDone, time.Now())
+	return true
+}
+
+func (r *Registry) taskLocked(agent, id string) *task {
+	j := r.jobs[id]
+	if j == nil {
+		return nil
+	}
+	for _, t := range j.tasks {
+		if t.agent == agent {
+			return t
+		}
+	}
+	return nil
+}
+
+// sweepLocked expires tasks that waited too long for their agent, or
+// whose agent did not report back in time.
+func (r *Registry) sweepLocked(now time.Time) {
+	for _, a := range r.agents {
+		a.queue = slices.DeleteFunc(a.queue, func(t *task) bool {
+			if now.Sub(t.queuedAt) < queueTTL {
+				return false
+			}
+			t.err = "agent did not poll in time"
+			t.finishLocked(api.TaskExpired, now)
+			return true
+		})
+	}
+	for _, j := range r.jobs {
+		for _, t := range j.tasks {
+			if (t.state == api.TaskDispatched || t.state == api.TaskRunning) && now.After(t.deadline) {
+				t.err = "agent did not report back in time"
+				t.finishLocked(api.TaskExpired, now)
+			}
+		}
+	}
+}
+
+// pruneLocked drops the oldest finished jobs beyond maxJobs.
+func (r *Registry) pruneLocked() {
+	for i := 0; len(r.order) > maxJobs && i < len(r.order); {
+		j := r.jobs[r.order[i]]
+		if !j.finishedLocked() {
+			i++
+			continue
+		}
+		delete(r.jobs, j.id)
+		r.order = slices.Delete(r.order, i, i+1)
+	}
+}
+
+// dispatchLocked hands t to its agent.
+func (t *task) dispatchLocked(now time.Time) *api.AgentJob {
+	d, _ := ValidateSpec(t.job.spec)
+	if d == 0 {
+		d = fullTestTime
+	}
+	t.state = api.TaskDispatched
+	t.dispatchedAt = now
+	t.deadline = now.Add(d + reportGrace)
+	return &api.AgentJob{ID: t.job.id, Spec: t.job.spec, Deadline: t.deadline}
+}
+
+func (t *task) finishLocked(state string, now time.Time) {
+	t.state = state
+	t.finishedAt = now
+}
+
+func (j *job) finishedLocked() bool {
+	for _, t := range j.tasks {
+		switch t.state {
+		case api.TaskQueued, api.TaskDispatched, api.TaskRunning:
+			return false
+		}
+	}
+	return true
+}
+
+func (j *job) viewLocked() api.Job {
+	v := api.Job{
+		ID:        j.id,
+		CreatedAt: j.createdAt,
+		Spec:      j.spec,
+		Labels:    maps.Clone(j.labels),
+		Tasks:     make([]api.JobTask, len(j.tasks)),
+	}
+	for i, t := range j.tasks {
+		v.Tasks[i] = api.JobTask{Agent: t.agent, State: t.state, ResultID: t.resultID, Error: t.err}
+		if !t.dispatchedAt.IsZero() {
+			at := t.dispatchedAt
+			v.Tasks[i].DispatchedAt = &at
+		}
+		if !t.finishedAt.IsZero() {
+			at := t.finishedAt
+			v.Tasks[i].FinishedAt = &at
+		}
+	}
+	return v
+}
+
+// newJobID returns a random job ID.
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
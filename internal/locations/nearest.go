@@ -0,0 +1,35 @@
+package locations
+
+import (
+	"cmp"
+	"math"
+	"slices"
+
+	"github.com/yellowman/netspeed/api"
+)
+
+// earthRadiusKm is the mean radius of the Earth.
+const earthRadiusKm = 6371.0088
+
+// Distance returns the great-circle distance in kilometres between two
+// points given in degrees.
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat, dLon := rad(lat2-lat1), rad(lon2-lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// Nearest returns locs with their distance from lat, lon, nearest first.
+// Locations as far as each other keep their order.
+func Nearest(locs []Location, lat, lon float64) []api.NearestLocation {
+	ranked := make([]api.NearestLocation, len(locs))
+	for i, l := range locs {
+		ranked[i] = api.NearestLocation{Location: l, DistanceKm: Distance(lat, lon, l.Lat, l.Lon)}
+	}
+	slices.SortStableFunc(ranked, func(a, b api.NearestLocation) int {
+		return cmp.Compare(a.DistanceKm, b.DistanceKm)
+	})
+	return ranked
+}
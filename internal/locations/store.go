@@ -2,21 +2,15 @@
 package locations
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"sync"
+
+	"github.com/yellowman/netspeed/api"
 )
 
 // Location represents a test server location / data center.
-type Location struct {
-	IATA   string  `json:"iata"`
-	Lat    float64 `json:"lat"`
-	Lon    float64 `json:"lon"`
-	CCA2   string  `json:"cca2"`
-	Region string  `json:"region"`
-	City   string  `json:"city"`
-}
+type Location = api.Location
 
 // Store is the interface for accessing location data.
 type Store interface {
@@ -24,25 +18,59 @@ type Store interface {
 }
 
 // FileStore loads locations from a JSON file at startup.
+// The file can be re-read at runtime with Reload.
 type FileStore struct {
 	mu        sync.RWMutex
+	path      string
 	locations []Location
 }
 
 // NewFileStore creates a new FileStore by loading locations from the given file path.
 // If the file cannot be read or parsed, an error is returned.
 func NewFileStore(filePath string) (*FileStore, error) {
+	locations, err := readFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileStore{path: filePath, locations: locations}, nil
+}
+
+// Path returns the file the store was loaded from.
+func (s *FileStore) Path() string {
+	return s.path
+}
+
+// Reload re-reads the locations file and swaps in the new entries.
+// If the file cannot be read or parsed, the current entries are kept
+// and the error is returned. It reports the entry counts before and after.
+func (s *FileStore) Reload() (before, after int, err error) {
+	locations, err := readFile(s.path)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before = len(s.locations)
+	if err != nil {
+		return before, before, err
+	}
+	s.locations = locations
+	return before, len(locations), nil
+}
+
+// readFile reads, parses and validates a JSON locations file.
+func readFile(filePath string) ([]Location, error) {
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read locations file: %w", err)
 	}
 
-	var locations []Location
-	if err := json.Unmarshal(data, &locations); err != nil {
-		return nil, fmt.Errorf("failed to parse locations JSON: %w", err)
+	locations, err := parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid locations file %s: %w", filePath, err)
 	}
 
-	return &FileStore{locations: locations}, nil
+	return locations, nil
 }
 
 // All returns all loaded locations.
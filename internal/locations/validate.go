@@ -0,0 +1,168 @@
+package locations
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/yellowman/netspeed/api"
+)
+
+// parse decodes a JSON array of locations and validates each entry.
+// Errors start with the line they were found on.
+func parse(data []byte) ([]Location, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, jsonError(data, 0, err)
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '[' {
+		return nil, fmt.Errorf("line %d: expected an array of locations", lineAt(data, dec.InputOffset()))
+	}
+
+	locations := []Location{}
+	firstLine := map[string]int{}
+	for dec.More() {
+		start := valueStart(data, dec.InputOffset())
+		line := lineAt(data, start)
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, jsonError(data, 0, err)
+		}
+		var loc Location
+		if err := json.Unmarshal(raw, &loc); err != nil {
+			return nil, jsonError(data, start, err)
+		}
+		if err := validate(&loc); err != nil {
+			if loc.IATA != "" {
+				return nil, fmt.Errorf("line %d: location %s: %w", line, loc.IATA, err)
+			}
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		key := strings.ToUpper(loc.IATA)
+		if first, ok := firstLine[key]; ok {
+			return nil, fmt.Errorf("line %d: duplicate location %s (first on line %d)", line, loc.IATA, first)
+		}
+		firstLine[key] = line
+		locations = append(locations, loc)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, jsonError(data, 0, err)
+	}
+	return locations, nil
+}
+
+// jsonError adds the line of err to it. The offsets of errors from
+// decoding a single entry are relative to its start, base.
+func jsonError(data []byte, base int64, err error) error {
+	var syntax *json.SyntaxError
+	var typ *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntax):
+		return fmt.Errorf("line %d: %w", lineAt(data, base+syntax.Offset), err)
+	case errors.As(err, &typ):
+		return fmt.Errorf("line %d: %w", lineAt(data, base+typ.Offset), err)
+	}
+	return err
+}
+
+// valueStart skips the whitespace and comma from off to the next value.
+func valueStart(data []byte, off int64) int64 {
+	for off < int64(len(data)) && strings.IndexByte(" \t\r\n,", data[off]) >= 0 {
+		off++
+	}
+	return off
+}
+
+// lineAt returns the line of data that offset off is on, counting from 1.
+func lineAt(data []byte, off int64) int {
+	off = min(max(off, 0), int64(len(data)))
+	return 1 + bytes.Count(data[:off], []byte("\n"))
+}
+
+var (
+	locationTests = []string{api.LocationTestDownload, api.LocationTestUpload, api.LocationTestPacketLoss, api.LocationTestHTTP3}
+	capacities    = []string{api.CapacitySmall, api.CapacityMedium, api.CapacityLarge}
+	statuses      = []string{api.LocationActive, api.LocationMaintenance, api.LocationDisabled}
+)
+
+// validate checks the fields of l.
+func validate(l *Location) error {
+	if l.IATA == "" {
+		return errors.New("iata is required")
+	}
+	if l.Lat < -90 || l.Lat > 90 {
+		return fmt.Errorf("lat %v is out of range", l.Lat)
+	}
+	if l.Lon < -180 || l.Lon > 180 {
+		return fmt.Errorf("lon %v is out of range", l.Lon)
+	}
+	if l.CCA2 != "" && len(l.CCA2) != 2 {
+		return fmt.Errorf("cca2 %q is not a two-letter country code", l.CCA2)
+	}
+	if l.URL != "" {
+		u, err := url.Parse(l.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			return fmt.Errorf("url: invalid URL %q (want http(s)://host[:port][/path])", l.URL)
+		}
+	}
+	if err := validHost(l.IPv4Host, true); err != nil {
+		return fmt.Errorf("ipv4Host: %w", err)
+	}
+	if err := validHost(l.IPv6Host, false); err != nil {
+		return fmt.Errorf("ipv6Host: %w", err)
+	}
+	for i, t := range l.Tests {
+		if !slices.Contains(locationTests, t) {
+			return fmt.Errorf("tests: unknown test %q (want one of %s)", t, strings.Join(locationTests, ", "))
+		}
+		if slices.Contains(l.Tests[:i], t) {
+			return fmt.Errorf("tests: %s is listed twice", t)
+		}
+	}
+	if l.Capacity != "" && !slices.Contains(capacities, l.Capacity) {
+		return fmt.Errorf("capacity: unknown class %q (want one of %s)", l.Capacity, strings.Join(capacities, ", "))
+	}
+	for _, tag := range l.Tags {
+		if strings.TrimSpace(tag) == "" {
+			return errors.New("tags: empty tag")
+		}
+	}
+	if l.Status != "" && !slices.Contains(statuses, l.Status) {
+		return fmt.Errorf("status: unknown status %q (want one of %s)", l.Status, strings.Join(statuses, ", "))
+	}
+	return nil
+}
+
+// validHost checks that h is empty, a hostname, or an address of the
+// family the field is for.
+func validHost(h string, v4 bool) error {
+	if h == "" {
+		return nil
+	}
+	if addr, err := netip.ParseAddr(strings.Trim(h, "[]")); err == nil {
+		if addr.Is4() != v4 {
+			return fmt.Errorf("%s is an address of the other family", h)
+		}
+		return nil
+	}
+	if len(h) > 253 {
+		return fmt.Errorf("invalid hostname %q", h)
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(h, "."), ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("invalid hostname %q", h)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return fmt.Errorf("invalid hostname %q", h)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+// Package logging sets up netspeedd's structured logs.
+//
+// Every subsystem logs through its own *slog.Logger from For, which tags
+// records with a "subsystem" attribute and filters them against that
+// subsystem's level. Configure picks the output format and the levels; it
+// can be called again at any time (e.g. on SIGHUP) and loggers handed out
+// earlier follow the new settings.
+//
+// Events that happen on every request of a test, such as latency probes and
+// TURN authentications, are sampled: only one in N is logged, and the lines
+// that are carry the rate in a "sampleRate" attribute.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// Subsystems with their own level.
+const (
+	Server = "server"
+	WebRTC = "webrtc"
+	TURN   = "turn"
+	Meta   = "meta"
+	Mesh   = "mesh"
+)
+
+// Sampled events.
+const (
+	LatencyProbe = "latency_probe"
+	TURNAuth     = "turn_auth"
+)
+
+// Output formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Config configures the log output.
+type Config struct {
+	// Format is FormatText or FormatJSON
+	Format string
+	// Level is the minimum level of subsystems without their own entry in
+	// Levels
+	Level  slog.Level
+	Levels map[string]slog.Level
+	// Sampling logs one in N occurrences of an event; events without an
+	// entry, or with N <= 1, are all logged
+	Sampling map[string]int
+}
+
+// root is the handler every subsystem writes to. It lets everything
+// through; levels are checked per subsystem before records reach it.
+var root atomic.Pointer[slog.Handler]
+
+var (
+	mu         sync.Mutex
+	current    = Config{Format: FormatText, Level: slog.LevelInfo}
+	subsystems = map[string]*slog.LevelVar{}
+	samplers   = map[string]*Sampler{}
+)
+
+func init() {
+	setRoot(FormatText)
+	slog.SetDefault(For(Server))
+}
+
+// Configure applies cfg to every logger and sampler, including those
+// created before.
+func Configure(cfg Config) error {
+	switch cfg.Format {
+	case FormatText, FormatJSON:
+	default:
+		return fmt.Errorf("unknown log format %q (want %q or %q)", cfg.Format, FormatText, FormatJSON)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if cfg.Format != current.Format {
+		setRoot(cfg.Format)
+	}
+	current = cfg
+	for name, level := range subsystems {
+		level.Set(cfg.levelFor(name))
+	}
+	for name, s := range samplers {
+		s.rate.Store(int64(cfg.Sampling[name]))
+	}
+	return nil
+}
+
+func setRoot(format string) {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var h slog.Handler
+	if format == FormatJSON {
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		h = slog.NewTextHandler(os.Stderr, opts)
+	}
+	root.Store(&h)
+}
+
+func (cfg *Config) levelFor(subsystem string) slog.Level {
+	if level, ok := cfg.Levels[subsystem]; ok {
+		return level
+	}
+	return cfg.Level
+}
+
+// For returns the logger of subsystem.
+func For(subsystem string) *slog.Logger {
+	mu.Lock()
+	level, ok := subsystems[subsystem]
+	if !ok {
+		level = new(slog.LevelVar)
+		level.Set(current.levelFor(subsystem))
+		subsystems[subsystem] = level
+	}
+	mu.Unlock()
+
+	return slog.New(&handler{
+		level: level,
+		attrs: []slog.Attr{slog.String("subsystem", subsystem)},
+	})
+}
+
+// handler filters records by its subsystem's level and passes them on to
+// the current root handler, replaying the attributes and groups added with
+// WithAttrs and WithGroup.
+type handler struct {
+	level *slog.LevelVar
+	attrs []slog.Attr
+	group string
+	// parent is the handler WithGroup was called on; attrs and group apply
+	// on top of it
+	parent *handler
+
+	// built caches the root handler with attrs and groups applied, for as
+	// long as the root does not change
+	built atomic.Pointer[builtHandler]
+}
+
+type builtHandler struct {
+	root    *slog.Handler
+	handler slog.Handler
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	return h.resolve(root.Load()).Handle(ctx, r)
+}
+
+// resolve returns rootHandler with h's attributes and groups applied.
+func (h *handler) resolve(rootHandler *slog.Handler) slog.Handler {
+	if b := h.built.Load(); b != nil && b.root == rootHandler {
+		return b.handler
+	}
+	out := *rootHandler
+	if h.parent != nil {
+		out = h.parent.resolve(rootHandler).WithGroup(h.group)
+	}
+	if len(h.attrs) > 0 {
+		out = out.WithAttrs(h.attrs)
+	}
+	h.built.Store(&builtHandler{root: rootHandler, handler: out})
+	return out
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	return &handler{
+		level:  h.level,
+		attrs:  append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...),
+		group:  h.group,
+		parent: h.parent,
+	}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &handler{level: h.level, group: name, parent: h}
+}
+
+// Sampler decides which occurrences of a high-volume event are logged.
+type Sampler struct {
+	rate  atomic.Int64
+	count atomic.Uint64
+}
+
+// Sampled returns the sampler of event.
+func Sampled(event string) *Sampler {
+	mu.Lock()
+	defer mu.Unlock()
+	s, ok := samplers[event]
+	if !ok {
+		s = new(Sampler)
+		s.rate.Store(int64(current.Sampling[event]))
+		samplers[event] = s
+	}
+	return s
+}
+
+// Allow reports whether this occurrence should be logged: the first of
+// every N.
+func (s *Sampler) Allow() bool {
+	n := s.rate.Load()
+	if n <= 1 {
+		return true
+	}
+	return (s.count.Add(1)-1)%uint64(n) == 0
+}
+
+// Rate is N, the number of occurrences each logged line stands for.
+func (s *Sampler) Rate() int {
+	if n := s.rate.Load(); n > 1 {
+		return int(n)
+	}
+	return 1
+}
+
+// Attr is the sampleRate attribute for a line logged after Allow.
+func (s *Sampler) Attr() slog.Attr {
+	return slog.Int("sampleRate", s.Rate())
+}
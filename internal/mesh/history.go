@@ -0,0 +1,171 @@
+package mesh
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/yellowman/netspeed/api"
+)
+
+// linksBucket maps the measurement time (big-endian Unix nanoseconds)
+// followed by the peer's IATA code to the JSON-encoded link.
+var linksBucket = []byte("links")
+
+// maxLinks bounds the measurements kept, whatever the retention.
+const maxLinks = 500000
+
+// History keeps this node's measurements, oldest first. It holds them in
+// memory and, if opened with a file, in a bbolt database as well, from
+// which they are loaded again at startup. It is safe for concurrent use.
+type History struct {
+	mu    sync.Mutex
+	db    *bolt.DB // nil keeps measurements in memory only
+	links []api.MeshLink
+}
+
+// OpenHistory opens or creates the database at path and loads the
+// measurements in it; an empty path keeps them in memory only.
+func OpenHistory(path string) (*History, error) {
+	h := &History{}
+	if path == "" {
+		return h, nil
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open mesh history: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(linksBucket)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(_, v []byte) error {
+			var l api.MeshLink
+			if err := json.Unmarshal(v, &l); err != nil {
+				return err
+			}
+			h.links = append(h.links, l)
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to load mesh history: %w", err)
+	}
+	h.db = db
+	return h, nil
+}
+
+// Close closes the database, if any.
+func (h *History) Close() error {
+	if h.db == nil {
+		return nil
+	}
+	return h.db.Close()
+}
+
+// Add records l.
+func (h *History) Add(l api.MeshLink) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.db != nil {
+		v, err := json.Marshal(l)
+		if err != nil {
+			return err
+		}
+		err = h.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(linksBucket).Put(linkKey(l), v)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to store mesh link: %w", err)
+		}
+	}
+	h.links = append(h.links, l)
+	if len(h.links) > maxLinks {
+		h.pruneLocked(len(h.links) - maxLinks)
+	}
+	return nil
+}
+
+// Prune drops the measurements taken before t.
+func (h *History) Prune(t time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n, _ := slices.BinarySearchFunc(h.links, t, func(l api.MeshLink, t time.Time) int {
+		return l.MeasuredAt.Compare(t)
+	})
+	return h.pruneLocked(n)
+}
+
+// pruneLocked drops the oldest n measurements.
+func (h *History) pruneLocked(n int) error {
+	if n == 0 {
+		return nil
+	}
+	dropped := h.links[:n]
+	h.links = slices.Delete(h.links, 0, n)
+	if h.db == nil {
+		return nil
+	}
+	return h.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(linksBucket)
+		for _, l := range dropped {
+			if err := b.Delete(linkKey(l)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Latest returns the latest measurement to each peer, by peer.
+func (h *History) Latest() []api.MeshLink {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	seen := make(map[string]bool)
+	var latest []api.MeshLink
+	for i := len(h.links) - 1; i >= 0; i-- {
+		if l := h.links[i]; !seen[l.To] {
+			seen[l.To] = true
+			latest = append(latest, l)
+		}
+	}
+	slices.SortFunc(latest, func(a, b api.MeshLink) int { return strings.Compare(a.To, b.To) })
+	return latest
+}
+
+// Query returns the measurements to peer, or to every peer if it is empty,
+// taken in [from, to), oldest first. Zero times leave the range open; if
+// there are more than limit, the newest are returned.
+func (h *History) Query(peer string, from, to time.Time, limit int) []api.MeshLink {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var list []api.MeshLink
+	for i := len(h.links) - 1; i >= 0 && len(list) < limit; i-- {
+		l := h.links[i]
+		if !from.IsZero() && l.MeasuredAt.Before(from) {
+			break
+		}
+		if !to.IsZero() && !l.MeasuredAt.Before(to) {
+			continue
+		}
+		if peer != "" && !strings.EqualFold(l.To, peer) {
+			continue
+		}
+		list = append(list, l)
+	}
+	slices.Reverse(list)
+	return list
+}
+
+func linkKey(l api.MeshLink) []byte {
+	key := binary.BigEndian.AppendUint64(nil, uint64(l.MeasuredAt.UnixNano()))
+	return append(key, l.To...)
+}
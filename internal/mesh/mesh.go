@@ -0,0 +1,352 @@
+// Package mesh measures the links between netspeedd nodes.
+//
+// Every node with the mesh enabled tests the other nodes of its locations
+// store that are available, its peers, over the same endpoints browsers
+// use: latency on an idle connection, then download and upload for a few
+// seconds each with latency under load, and optionally WebRTC packet loss.
+// Peers are tested one at a time, once per round. Each direction is capped at a number of bytes, and a
+// daily budget across all peers leaves only latency to measure once spent,
+// so the mesh cannot saturate the links it watches.
+//
+// A node keeps the history of its own measurements. The full matrix is put
+// together by fetching the latest row of every peer after each round.
+package mesh
+
+import (
+	"context"
+	"fmt"
+	mrand "math/rand/v2"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yellowman/netspeed/api"
+	"github.com/yellowman/netspeed/internal/config"
+	"github.com/yellowman/netspeed/internal/logging"
+	"github.com/yellowman/netspeed/speedtest"
+)
+
+var logger = logging.For(logging.Mesh)
+
+// fetchTimeout bounds fetching a peer's row of the matrix.
+const fetchTimeout = 10 * time.Second
+
+// Options wires a Runner to the server.
+type Options struct {
+	// Config returns the current configuration, so that a reload changes
+	// the colo, peers and budgets from the next round on
+	Config func() *config.Config
+	// Locations returns the locations whose nodes are the peers
+	Locations func() []api.Location
+	History   *History
+}
+
+// Runner tests the peers of this node on schedule and serves the results.
+type Runner struct {
+	opts Options
+	http *http.Client
+
+	mu sync.Mutex
+	// day is the UTC day spent counts the bytes of
+	day   string
+	spent int64
+	// rows are the peers' own rows as last fetched, by colo; unreachable
+	// lists the peers whose row could not be fetched
+	rows        map[string]api.MeshNode
+	unreachable []string
+}
+
+// peer is a node to test.
+type peer struct {
+	colo string
+	url  string
+}
+
+// New returns a Runner. The bytes already spent today are counted from the
+// history.
+func New(opts Options) *Runner {
+	r := &Runner{opts: opts, http: &http.Client{Timeout: fetchTimeout}, rows: map[string]api.MeshNode{}}
+	now := time.Now().UTC()
+	r.day = now.Format(time.DateOnly)
+	for _, l := range opts.History.Query("", now.Truncate(24*time.Hour), time.Time{}, maxLinks) {
+		r.spent += l.Bytes
+	}
+	return r
+}
+
+// Run runs a round every interval until ctx is done. The first one starts
+// within a minute, at random, so that nodes restarted together don't all
+// test each other at once.
+func (r *Runner) Run(ctx context.Context) {
+	wait := mrand.N(time.Minute)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		start := time.Now()
+		r.round(ctx)
+		wait = r.opts.Config().Mesh.Interval - time.Since(start)
+	}
+}
+
+// round tests every peer once, then fetches the peers' rows.
+func (r *Runner) round(ctx context.Context) {
+	cfg := r.opts.Config()
+	mcfg := cfg.Mesh
+	peers := r.peers(cfg)
+	if len(peers) == 0 {
+		logger.Warn("Mesh has no peers; give locations a url or set mesh.url_template or mesh.peers")
+		return
+	}
+	logger.Info("Mesh round started", "peers", len(peers))
+	start := time.Now()
+	failed := 0
+	for _, p := range peers {
+		if ctx.Err() != nil {
+			return
+		}
+		l := r.measure(ctx, cfg.Colo, p, &mcfg)
+		if ctx.Err() != nil {
+			return
+		}
+		if l.Error != "" {
+			failed++
+			logger.Warn("Mesh peer failed", "peer", p.colo, "url", p.url, "error", l.Error)
+		} else {
+			logger.Debug("Mesh peer measured", "peer", p.colo, "latencyMs", l.LatencyMs, "bytes", l.Bytes)
+		}
+		if err := r.opts.History.Add(l); err != nil {
+			logger.Error("Failed to record mesh link", "peer", p.colo, "error", err)
+		}
+	}
+	if err := r.opts.History.Prune(time.Now().Add(-mcfg.Retention)); err != nil {
+		logger.Error("Failed to prune mesh history", "error", err)
+	}
+	r.fetchRows(ctx, peers)
+	r.mu.Lock()
+	spent := r.spent
+	r.mu.Unlock()
+	logger.Info("Mesh round finished", "peers", len(peers), "failed", failed,
+		"duration", time.Since(start).Round(time.Millisecond), "bytesToday", spent)
+}
+
+// peers returns the available nodes of the locations store other than
+// this one, and the configured peers, by colo. Locations in maintenance or
+// disabled are skipped even if configured.
+func (r *Runner) peers(cfg *config.Config) []peer {
+	m := &cfg.Mesh
+	seen := map[string]bool{strings.ToUpper(cfg.Colo): true}
+	var peers []peer
+	add := func(colo, base string) {
+		if seen[strings.ToUpper(colo)] {
+			return
+		}
+		seen[strings.ToUpper(colo)] = true
+		if u, ok := m.PeerURL(colo, base); ok {
+			peers = append(peers, peer{colo: colo, url: u})
+		}
+	}
+	for _, loc := range r.opts.Locations() {
+		if !loc.Available() {
+			seen[strings.ToUpper(loc.IATA)] = true
+			continue
+		}
+		add(loc.IATA, loc.URL)
+	}
+	for colo := range m.Peers {
+		add(colo, "")
+	}
+	slices.SortFunc(peers, func(a, b peer) int { return strings.Compare(a.colo, b.colo) })
+	return peers
+}
+
+// hasBudget reports whether today's byte budget has room for want more
+// bytes.
+func (r *Runner) hasBudget(m *config.Mesh, want int64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if day := time.Now().UTC().Format(time.DateOnly); day != r.day {
+		r.day, r.spent = day, 0
+	}
+	return m.DailyBytes == 0 || r.spent+want <= m.DailyBytes
+}
+
+// spend adds n bytes to today's count.
+func (r *Runner) spend(n int64) {
+	r.mu.Lock()
+	r.spent += n
+	r.mu.Unlock()
+}
+
+// measure tests p.
+func (r *Runner) measure(ctx context.Context, self string, p peer, m *config.Mesh) api.MeshLink {
+	l := api.MeshLink{From: self, To: p.colo, MeasuredAt: time.Now().UTC()}
+	fail := func(err error) api.MeshLink {
+		l.Error = err.Error()
+		return l
+	}
+	c, err := speedtest.NewClient(p.url, nil)
+	if err != nil {
+		return fail(err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*m.Duration+2*time.Minute)
+	defer cancel()
+
+	if !r.hasBudget(m, 2*m.TestBytes) {
+		logger.Debug("Mesh byte budget spent, measuring latency only", "peer", p.colo)
+		res, err := c.RunTimed(ctx, speedtest.TimedConfig{Duration: m.Duration})
+		if err != nil {
+			return fail(err)
+		}
+		l.LatencyMs, l.JitterMs = res.Summary.LatencyUnloadedMs, res.Summary.JitterMs
+		return l
+	}
+
+	for _, dir := range []string{speedtest.Download, speedtest.Upload} {
+		res, err := c.RunTimed(ctx, speedtest.TimedConfig{
+			Direction: dir,
+			Profile:   profileFor(dir, m.TestBytes),
+			Duration:  m.Duration,
+			MaxBytes:  m.TestBytes,
+		})
+		if res != nil {
+			n := moved(res)
+			l.Bytes += n
+			r.spend(n)
+		}
+		if err != nil {
+			return fail(fmt.Errorf("%s: %w", dir, err))
+		}
+		s := res.Summary
+		if dir == speedtest.Download {
+			l.LatencyMs, l.JitterMs = s.LatencyUnloadedMs, s.JitterMs
+			l.DownloadMbps, l.LatencyDownloadMs = &s.DownloadMbps, &s.LatencyDownloadMs
+		} else {
+			l.UploadMbps, l.LatencyUploadMs = &s.UploadMbps, &s.LatencyUploadMs
+		}
+	}
+
+	if m.PacketLoss {
+		pl, err := c.PacketLoss(ctx, speedtest.DefaultConfig().PacketLoss)
+		if err != nil {
+			return fail(fmt.Errorf("packet loss: %w", err))
+		}
+		if pl.Unavailable {
+			logger.Debug("Mesh packet loss unavailable", "peer", p.colo, "reason", pl.Reason)
+		} else {
+			l.PacketLossPercent = &pl.LossPercent
+		}
+	}
+	return l
+}
+
+// profileFor picks the transfer size for a timed test of direction dir
+// capped at max bytes: the largest that fits four times, up to 25MB, so
+// that a few transfers finish within the test.
+func profileFor(dir string, max int64) speedtest.Profile {
+	profiles := speedtest.DownloadProfiles
+	if dir == speedtest.Upload {
+		profiles = speedtest.UploadProfiles
+	}
+	best := profiles[0]
+	for _, p := range profiles {
+		if p.Bytes <= max/4 && p.Bytes <= 25e6 {
+			best = p
+		}
+	}
+	return best
+}
+
+// moved returns the bytes of the transfers of res.
+func moved(res *speedtest.Result) int64 {
+	var n int64
+	for _, s := range res.ThroughputSamples {
+		n += s.SizeBytes
+	}
+	return n
+}
+
+// fetchRows fetches the rows of peers for the matrix.
+func (r *Runner) fetchRows(ctx context.Context, peers []peer) {
+	rows := make(map[string]api.MeshNode, len(peers))
+	var unreachable []string
+	for _, p := range peers {
+		c, err := speedtest.NewClient(p.url, r.http)
+		if err != nil {
+			unreachable = append(unreachable, p.colo)
+			continue
+		}
+		row, err := c.MeshNode(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Debug("Failed to fetch mesh row", "peer", p.colo, "error", err)
+			unreachable = append(unreachable, p.colo)
+			continue
+		}
+		rows[p.colo] = *row
+	}
+	r.mu.Lock()
+	r.rows, r.unreachable = rows, unreachable
+	r.mu.Unlock()
+}
+
+// Node returns this node's row: the latest measurement to each peer.
+func (r *Runner) Node() api.MeshNode {
+	links := r.opts.History.Latest()
+	if links == nil {
+		links = []api.MeshLink{}
+	}
+	return api.MeshNode{Colo: r.opts.Config().Colo, Links: links}
+}
+
+// Matrix returns the latest measurement between every pair of nodes: this
+// node's own row and the rows of its peers as last fetched.
+func (r *Runner) Matrix() api.MeshResponse {
+	self := r.Node()
+	r.mu.Lock()
+	rows := make([]api.MeshNode, 0, len(r.rows)+1)
+	rows = append(rows, self)
+	for colo, row := range r.rows {
+		if !strings.EqualFold(colo, self.Colo) {
+			rows = append(rows, row)
+		}
+	}
+	unreachable := slices.Clone(r.unreachable)
+	r.mu.Unlock()
+
+	index := map[string]int{}
+	var nodes []string
+	addNode := func(colo string) {
+		if _, ok := index[colo]; !ok {
+			index[colo] = -1
+			nodes = append(nodes, colo)
+		}
+	}
+	for _, row := range rows {
+		addNode(row.Colo)
+		for _, l := range row.Links {
+			addNode(l.To)
+		}
+	}
+	slices.Sort(nodes)
+	for i, colo := range nodes {
+		index[colo] = i
+	}
+
+	matrix := make([][]*api.MeshLink, len(nodes))
+	for i := range matrix {
+		matrix[i] = make([]*api.MeshLink, len(nodes))
+	}
+	for _, row := range rows {
+		for _, l := range row.Links {
+			matrix[index[row.Colo]][index[l.To]] = &l
+		}
+	}
+	return api.MeshResponse{Nodes: nodes, Matrix: matrix, Unreachable: unreachable}
+}
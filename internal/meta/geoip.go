@@ -2,13 +2,16 @@
 package meta
 
 import (
-	"log"
 	"net"
 	"net/http"
 
 	"github.com/oschwald/geoip2-golang"
+	"github.com/yellowman/netspeed/internal/logging"
+	"github.com/yellowman/netspeed/internal/metrics"
 )
 
+var logger = logging.For(logging.Meta)
+
 // GeoIPProvider looks up ASN/organization info from MaxMind GeoLite2-ASN database.
 type GeoIPProvider struct {
 	db         *geoip2.Reader
@@ -63,13 +66,14 @@ func (p *GeoIPProvider) MetaFor(r *http.Request) ClientMeta {
 	// Look up ASN from IP
 	ip := net.ParseIP(clientIP)
 	if ip == nil {
-		log.Printf("GeoIP: failed to parse IP: %s", clientIP)
+		logger.Warn("GeoIP: failed to parse IP", "client", clientIP)
 		return meta
 	}
 
 	asn, err := p.db.ASN(ip)
 	if err != nil {
-		log.Printf("GeoIP: ASN lookup failed for %s: %v", clientIP, err)
+		logger.Warn("GeoIP: ASN lookup failed", "client", clientIP, "error", err)
+		metrics.GeoIPLookupFailed("asn")
 		return meta
 	}
 
@@ -146,7 +150,7 @@ func (p *CityGeoIPProvider) MetaFor(r *http.Request) ClientMeta {
 
 	ip := net.ParseIP(clientIP)
 	if ip == nil {
-		log.Printf("GeoIP: failed to parse IP: %s", clientIP)
+		logger.Warn("GeoIP: failed to parse IP", "client", clientIP)
 		return meta
 	}
 
@@ -157,7 +161,8 @@ func (p *CityGeoIPProvider) MetaFor(r *http.Request) ClientMeta {
 			meta.ASN = int(asn.AutonomousSystemNumber)
 			meta.ASOrg = asn.AutonomousSystemOrganization
 		} else {
-			log.Printf("GeoIP: ASN lookup failed for %s: %v", clientIP, err)
+			logger.Warn("GeoIP: ASN lookup failed", "client", clientIP, "error", err)
+			metrics.GeoIPLookupFailed("asn")
 		}
 	}
 
@@ -187,7 +192,8 @@ func (p *CityGeoIPProvider) MetaFor(r *http.Request) ClientMeta {
 				meta.Timezone = city.Location.TimeZone
 			}
 		} else {
-			log.Printf("GeoIP: city lookup failed for %s: %v", clientIP, err)
+			logger.Warn("GeoIP: city lookup failed", "client", clientIP, "error", err)
+			metrics.GeoIPLookupFailed("city")
 		}
 	}
 
@@ -6,24 +6,12 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+
+	"github.com/yellowman/netspeed/api"
 )
 
 // ClientMeta holds per-client metadata for the /meta endpoint.
-type ClientMeta struct {
-	Hostname     string  `json:"hostname"`
-	ClientIP     string  `json:"clientIp"`
-	HTTPProtocol string  `json:"httpProtocol"`
-	ASN          int     `json:"asn"`
-	ASOrg        string  `json:"asOrganization"`
-	Colo         string  `json:"colo"`
-	Country      string  `json:"country"`
-	City         string  `json:"city"`
-	Region       string  `json:"region"`
-	PostalCode   string  `json:"postalCode"`
-	Latitude     float64 `json:"latitude"`
-	Longitude    float64 `json:"longitude"`
-	Timezone     string  `json:"timezone,omitempty"`
-}
+type ClientMeta = api.ClientMeta
 
 // Provider is the interface for extracting client metadata from requests.
 type Provider interface {
@@ -0,0 +1,264 @@
+// Package metrics holds the Prometheus metrics exported on /metrics.
+//
+// Collectors are package-level so that every subsystem (server, meta,
+// webrtc, turn) can record into them without threading a registry through
+// its constructor. They live in their own registry rather than the global
+// default one so that only netspeedd's metrics and the standard Go and
+// process collectors are exposed.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "netspeedd"
+
+// Transfer directions, as used in the direction label.
+const (
+	Download = "download"
+	Upload   = "upload"
+)
+
+// registry is the registry served by Handler.
+var registry = prometheus.NewRegistry()
+
+var factory = promauto.With(registry)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// HTTP
+var (
+	httpRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route and status code.",
+	}, []string{"route", "code"})
+
+	httpDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time to serve HTTP requests by route, including the transfer.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10), // 1ms to ~4m
+	}, []string{"route"})
+)
+
+// Measurement endpoints
+var (
+	transferBytes = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transfer_bytes_total",
+		Help:      "Payload bytes served by /__down and received by /__up.",
+	}, []string{"direction"})
+
+	transferThroughput = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "transfer_throughput_mbps",
+		Help:      "Per-request throughput of /__down and /__up transfers in Mbps.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 10), // 1 Mbps to ~262 Gbps
+	}, []string{"direction"})
+
+	latencyProbe = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "latency_probe_duration_seconds",
+		Help:      "Server-side time to answer /__down?bytes=0 latency probes.",
+		Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 8), // 10µs to ~160ms
+	})
+)
+
+// WebRTC packet tests
+var (
+	webrtcSessions = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "webrtc",
+		Name:      "sessions_total",
+		Help:      "WebRTC packet-test sessions created.",
+	})
+
+	packetTestReports = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "packet_test",
+		Name:      "reports_total",
+		Help:      "Packet-test results reported to /api/packet-test/report.",
+	})
+
+	packetTestLoss = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "packet_test",
+		Name:      "loss_percent",
+		Help:      "Packet loss reported by packet tests, in percent.",
+		Buckets:   []float64{0, 0.1, 0.5, 1, 2, 5, 10, 25, 50, 100},
+	})
+
+	packetTestRTT = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "packet_test",
+		Name:      "rtt_seconds",
+		Help:      "Round-trip times reported by packet tests, by statistic (min, median, p90).",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 12), // 1ms to ~2s
+	}, []string{"stat"})
+
+	packetTestJitter = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "packet_test",
+		Name:      "jitter_seconds",
+		Help:      "Jitter reported by packet tests.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 10), // 0.5ms to ~256ms
+	})
+)
+
+// GeoIP and TURN
+var (
+	geoipFailures = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "geoip",
+		Name:      "lookup_failures_total",
+		Help:      "GeoIP lookups that failed, by database (asn, city).",
+	}, []string{"database"})
+
+	turnAuth = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "turn",
+		Name:      "auth_requests_total",
+		Help:      "Embedded TURN authentication requests by result.",
+	}, []string{"result"})
+
+	turnAllocations = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "turn",
+		Name:      "allocations_total",
+		Help:      "Relay allocations made by the embedded TURN server.",
+	})
+
+	turnActiveAllocations = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "turn",
+		Name:      "active_allocations",
+		Help:      "Relay allocations currently open on the embedded TURN server.",
+	})
+)
+
+// handler serves the registry; it is built once so promhttp's own error
+// counters are only registered once.
+var handler = promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return handler
+}
+
+// gaugeFuncs holds the functions behind each gauge registered with
+// RegisterGaugeFunc, by full name.
+var (
+	gaugeMu    sync.Mutex
+	gaugeFuncs = map[string]map[*func() float64]bool{}
+)
+
+// RegisterGaugeFunc exports the value returned by f as a gauge, e.g. the
+// number of open WebRTC sessions, until the returned function is called.
+// Every Server in the process registers its own function under the same
+// name, and the gauge is their sum, as the counters are.
+func RegisterGaugeFunc(subsystem, name, help string, f func() float64) (unregister func()) {
+	fullName := prometheus.BuildFQName(namespace, subsystem, name)
+	gaugeMu.Lock()
+	defer gaugeMu.Unlock()
+	funcs, ok := gaugeFuncs[fullName]
+	if !ok {
+		funcs = map[*func() float64]bool{}
+		gaugeFuncs[fullName] = funcs
+		registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      name,
+			Help:      help,
+		}, func() float64 {
+			gaugeMu.Lock()
+			defer gaugeMu.Unlock()
+			var sum float64
+			for f := range funcs {
+				sum += (*f)()
+			}
+			return sum
+		}))
+	}
+	key := &f
+	funcs[key] = true
+	return func() {
+		gaugeMu.Lock()
+		delete(funcs, key)
+		gaugeMu.Unlock()
+	}
+}
+
+// ObserveRequest records a served HTTP request. route is the mux pattern
+// that matched, so unknown paths don't create new series.
+func ObserveRequest(route string, code int, d time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	httpRequests.WithLabelValues(route, strconv.Itoa(code)).Inc()
+	httpDuration.WithLabelValues(route).Observe(d.Seconds())
+}
+
+// ObserveTransfer records a /__down or /__up transfer. Interrupted
+// transfers count towards the bytes but not the throughput.
+func ObserveTransfer(direction string, bytes int64, mbps float64, complete bool) {
+	transferBytes.WithLabelValues(direction).Add(float64(bytes))
+	if complete && bytes > 0 {
+		transferThroughput.WithLabelValues(direction).Observe(mbps)
+	}
+}
+
+// ObserveLatencyProbe records the server-side time of a latency probe.
+func ObserveLatencyProbe(d time.Duration) {
+	latencyProbe.Observe(d.Seconds())
+}
+
+// WebRTCSessionCreated counts a new packet-test session.
+func WebRTCSessionCreated() {
+	webrtcSessions.Inc()
+}
+
+// ObservePacketTest records a packet-test report. Times are milliseconds,
+// as the client reports them.
+func ObservePacketTest(lossPercent, rttMinMs, rttMedianMs, rttP90Ms, jitterMs float64) {
+	packetTestReports.Inc()
+	packetTestLoss.Observe(lossPercent)
+	packetTestRTT.WithLabelValues("min").Observe(rttMinMs / 1000)
+	packetTestRTT.WithLabelValues("median").Observe(rttMedianMs / 1000)
+	packetTestRTT.WithLabelValues("p90").Observe(rttP90Ms / 1000)
+	packetTestJitter.Observe(jitterMs / 1000)
+}
+
+// GeoIPLookupFailed counts a failed lookup in database ("asn" or "city").
+func GeoIPLookupFailed(database string) {
+	geoipFailures.WithLabelValues(database).Inc()
+}
+
+// TURNAuth counts an embedded TURN authentication request by result.
+func TURNAuth(result string) {
+	turnAuth.WithLabelValues(result).Inc()
+}
+
+// TURNAllocationOpened counts a new relay allocation.
+func TURNAllocationOpened() {
+	turnAllocations.Inc()
+	turnActiveAllocations.Inc()
+}
+
+// TURNAllocationClosed counts a relay allocation going away.
+func TURNAllocationClosed() {
+	turnActiveAllocations.Dec()
+}
@@ -0,0 +1,330 @@
+// Package ratelimit enforces per-client request and data limits on the
+// measurement endpoints.
+//
+// Every request is counted against up to three keys: the client IP, the
+// network prefix it belongs to and its ASN. Each key has fixed windows of
+// one minute (requests), one hour and one day (bytes). A request is
+// admitted only if none of its keys is over a limit, and the bytes it
+// announces (the bytes parameter of /__down, the Content-Length of /__up)
+// are reserved up front so parallel streams cannot overshoot a quota.
+// Requests that move no data, such as latency probes, only count against
+// the request limit.
+package ratelimit
+
+import (
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Scopes a limit can apply to.
+const (
+	ScopeIP     = "ip"
+	ScopePrefix = "prefix"
+	ScopeASN    = "asn"
+)
+
+// Limit names, as reported in Denial.Limit.
+const (
+	LimitRequestsPerMinute = "requests_per_minute"
+	LimitBytesPerHour      = "bytes_per_hour"
+	LimitBytesPerDay       = "bytes_per_day"
+)
+
+// sweepInterval is how often idle counters are dropped.
+const sweepInterval = time.Minute
+
+// Limits caps one client key; zero values are unlimited.
+type Limits struct {
+	RequestsPerMinute int64
+	BytesPerHour      int64
+	BytesPerDay       int64
+}
+
+func (l Limits) zero() bool {
+	return l.RequestsPerMinute == 0 && l.BytesPerHour == 0 && l.BytesPerDay == 0
+}
+
+// Rule holds the limits of one endpoint for each scope.
+type Rule struct {
+	IP     Limits
+	Prefix Limits
+	ASN    Limits
+}
+
+// Config configures a Limiter.
+type Config struct {
+	// Rules maps an endpoint path to its limits. DefaultRule, if non-nil,
+	// applies to endpoints without an entry.
+	Rules       map[string]Rule
+	DefaultRule *Rule
+	// IPv4Prefix and IPv6Prefix are the prefix lengths for ScopePrefix.
+	IPv4Prefix int
+	IPv6Prefix int
+	// Exempt clients are never limited or counted.
+	Exempt []netip.Prefix
+}
+
+// Client identifies the sender of a request.
+type Client struct {
+	// IP is the client address; invalid addresses skip the ip and
+	// prefix scopes.
+	IP netip.Addr
+	// ASN is the client's autonomous system; 0 skips the asn scope.
+	ASN int
+}
+
+// Denial explains why a request was rejected.
+type Denial struct {
+	Endpoint   string
+	Scope      string // ScopeIP, ScopePrefix or ScopeASN
+	Key        string // the IP, prefix or "AS<n>" that hit the limit
+	Limit      string // LimitRequestsPerMinute, LimitBytesPerHour or LimitBytesPerDay
+	Max        int64
+	RetryAfter time.Duration
+}
+
+func (d *Denial) Error() string {
+	return fmt.Sprintf("rate limit exceeded: %s %s %s (%s=%d)", d.Endpoint, d.Scope, d.Key, d.Limit, d.Max)
+}
+
+// window is a fixed counting window.
+type window struct {
+	start time.Time
+	used  int64
+}
+
+// current returns the window's usage at now, resetting it if it has expired.
+func (w *window) current(now time.Time, period time.Duration) int64 {
+	if now.Sub(w.start) >= period {
+		w.start = now
+		w.used = 0
+	}
+	return w.used
+}
+
+func (w *window) retryAfter(now time.Time, period time.Duration) time.Duration {
+	return w.start.Add(period).Sub(now)
+}
+
+// usage is the state of one endpoint/scope/key combination.
+type usage struct {
+	requests window // per minute
+	hour     window // bytes
+	day      window // bytes
+	lastSeen time.Time
+}
+
+type counterKey struct {
+	endpoint string
+	scope    string
+	key      string
+}
+
+// Limiter tracks usage and admits or rejects requests. It is safe for
+// concurrent use.
+type Limiter struct {
+	mu        sync.Mutex
+	cfg       Config
+	counters  map[counterKey]*usage
+	lastSweep time.Time
+}
+
+// New creates a Limiter.
+func New(cfg Config) *Limiter {
+	return &Limiter{
+		cfg:      cfg,
+		counters: make(map[counterKey]*usage),
+	}
+}
+
+// SetConfig replaces the limits while keeping the usage counted so far.
+func (l *Limiter) SetConfig(cfg Config) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cfg = cfg
+}
+
+// exempt reports whether addr is in an exempt prefix.
+func (l *Limiter) exempt(addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range l.cfg.Exempt {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// scopedKey is a counter key together with the limits that apply to it.
+type scopedKey struct {
+	counterKey
+	limits Limits
+}
+
+// keys returns the counters a request from c to endpoint is checked against.
+func (l *Limiter) keys(endpoint string, c Client) []scopedKey {
+	rule, ok := l.cfg.Rules[endpoint]
+	if !ok {
+		if l.cfg.DefaultRule == nil {
+			return nil
+		}
+		rule = *l.cfg.DefaultRule
+	}
+
+	var keys []scopedKey
+	add := func(scope, key string, limits Limits) {
+		if !limits.zero() {
+			keys = append(keys, scopedKey{counterKey{endpoint, scope, key}, limits})
+		}
+	}
+	if c.IP.IsValid() {
+		ip := c.IP.Unmap()
+		add(ScopeIP, ip.String(), rule.IP)
+
+		bits := l.cfg.IPv6Prefix
+		if ip.Is4() {
+			bits = l.cfg.IPv4Prefix
+		}
+		if prefix, err := ip.Prefix(bits); err == nil {
+			add(ScopePrefix, prefix.String(), rule.Prefix)
+		}
+	}
+	if c.ASN != 0 {
+		add(ScopeASN, fmt.Sprintf("AS%d", c.ASN), rule.ASN)
+	}
+	return keys
+}
+
+// Allow checks a request from c to endpoint that expects to move bytes
+// bytes, or a negative value if the size is not known. If it is admitted,
+// the request and the expected bytes are counted and a Reservation is
+// returned whose Done must be called with the bytes actually transferred.
+// Exempt clients and endpoints without limits get a nil Reservation, on
+// which Done is a no-op.
+func (l *Limiter) Allow(endpoint string, c Client, bytes int64) (*Reservation, *Denial) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.exempt(c.IP) {
+		return nil, nil
+	}
+	keys := l.keys(endpoint, c)
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	l.sweep(now)
+
+	// Check every key before counting anything, so a rejected request does
+	// not use up quota on the keys that still had room
+	counters := make([]*usage, len(keys))
+	for i, k := range keys {
+		u := l.counters[k.counterKey]
+		if u == nil {
+			u = &usage{}
+			l.counters[k.counterKey] = u
+		}
+		counters[i] = u
+
+		if d := check(k, u, bytes, now); d != nil {
+			d.Endpoint = endpoint
+			return nil, d
+		}
+	}
+
+	bytes = max(bytes, 0)
+	for _, u := range counters {
+		u.requests.used++
+		u.hour.used += bytes
+		u.day.used += bytes
+		u.lastSeen = now
+	}
+	return &Reservation{limiter: l, counters: counters, reserved: bytes}, nil
+}
+
+// check returns a Denial if one more request moving bytes would exceed k.
+func check(k scopedKey, u *usage, bytes int64, now time.Time) *Denial {
+	deny := func(limit string, max int64, w *window, period time.Duration) *Denial {
+		return &Denial{
+			Scope:      k.scope,
+			Key:        k.key,
+			Limit:      limit,
+			Max:        max,
+			RetryAfter: w.retryAfter(now, period),
+		}
+	}
+
+	if max := k.limits.RequestsPerMinute; max > 0 && u.requests.current(now, time.Minute)+1 > max {
+		return deny(LimitRequestsPerMinute, max, &u.requests, time.Minute)
+	}
+	if max := k.limits.BytesPerHour; max > 0 && overQuota(u.hour.current(now, time.Hour), bytes, max) {
+		return deny(LimitBytesPerHour, max, &u.hour, time.Hour)
+	}
+	if max := k.limits.BytesPerDay; max > 0 && overQuota(u.day.current(now, 24*time.Hour), bytes, max) {
+		return deny(LimitBytesPerDay, max, &u.day, 24*time.Hour)
+	}
+	return nil
+}
+
+// overQuota reports whether moving bytes on top of used exceeds max.
+// Requests that move nothing (latency probes) always fit; requests of
+// unknown size (bytes < 0) fit while any quota is left and are charged for
+// what they actually move.
+func overQuota(used, bytes, max int64) bool {
+	switch {
+	case bytes == 0:
+		return false
+	case bytes < 0:
+		return used >= max
+	default:
+		return bytes > max-used
+	}
+}
+
+// sweep drops counters that have not been used for a day, so memory stays
+// proportional to the number of recently active clients.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for k, u := range l.counters {
+		if now.Sub(u.lastSeen) >= 24*time.Hour {
+			delete(l.counters, k)
+		}
+	}
+}
+
+// Reservation is an admitted request's claim on its byte quota.
+type Reservation struct {
+	limiter  *Limiter
+	counters []*usage
+	reserved int64
+	once     sync.Once
+}
+
+// Done settles the reservation against the bytes actually transferred,
+// returning unused bytes to the quota or charging the excess.
+func (r *Reservation) Done(actual int64) {
+	if r == nil {
+		return
+	}
+	r.once.Do(func() {
+		delta := actual - r.reserved
+		if delta == 0 {
+			return
+		}
+		r.limiter.mu.Lock()
+		defer r.limiter.mu.Unlock()
+		for _, u := range r.counters {
+			u.hour.used = max(u.hour.used+delta, 0)
+			u.day.used = max(u.day.used+delta, 0)
+		}
+	})
+}
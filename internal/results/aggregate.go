@@ -0,0 +1,151 @@
+package results
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Aggregation groupings.
+const (
+	GroupHour  = "hour"
+	GroupDay   = "day"
+	GroupASN   = "asn"
+	GroupColo  = "colo"
+	GroupAgent = "agent"
+)
+
+// Groups are the groupings Aggregate accepts.
+var Groups = []string{GroupHour, GroupDay, GroupASN, GroupColo, GroupAgent}
+
+// Percentiles are the percentiles of each metric in a Bucket.
+type Percentiles struct {
+	P10 float64 `json:"p10"`
+	P25 float64 `json:"p25"`
+	P50 float64 `json:"p50"`
+	P75 float64 `json:"p75"`
+	P90 float64 `json:"p90"`
+	P95 float64 `json:"p95"`
+}
+
+// Bucket is one group of an aggregation.
+type Bucket struct {
+	// Key is the start of the hour (RFC 3339) or day (YYYY-MM-DD) in UTC,
+	// the ASN, the colo or the agent (empty for results shared from a
+	// browser)
+	Key string `json:"key"`
+	// ASOrg is the AS organization, for GroupASN
+	ASOrg string `json:"asOrganization,omitempty"`
+	Count int    `json:"count"`
+
+	DownloadMbps      Percentiles `json:"downloadMbps"`
+	UploadMbps        Percentiles `json:"uploadMbps"`
+	LatencyMs         Percentiles `json:"latencyMs"`
+	JitterMs          Percentiles `json:"jitterMs"`
+	PacketLossPercent Percentiles `json:"packetLossPercent"`
+}
+
+// samples collects the values of one bucket.
+type samples struct {
+	asOrg                           string
+	down, up, latency, jitter, loss []float64
+	sortKey                         int64
+}
+
+// Aggregate groups the results matching f by group (one of Groups) and
+// returns the percentiles of each metric per group. Time buckets are in
+// ascending order, ASNs by number and colos and agents by name. Latency is the
+// unloaded latency.
+func (s *Store) Aggregate(f Filter, group string) ([]Bucket, error) {
+	if !slices.Contains(Groups, group) {
+		return nil, fmt.Errorf("unknown group %q (want one of %s)", group, strings.Join(Groups, ", "))
+	}
+
+	groups := make(map[string]*samples)
+	err := s.Scan(f, "", func(r *Result) bool {
+		key, sortKey := groupKey(r, group)
+		g, ok := groups[key]
+		if !ok {
+			g = &samples{sortKey: sortKey}
+			groups[key] = g
+		}
+		if g.asOrg == "" {
+			g.asOrg = r.Client.ASOrg
+		}
+		g.down = append(g.down, r.Summary.DownloadMbps)
+		g.up = append(g.up, r.Summary.UploadMbps)
+		g.latency = append(g.latency, r.Summary.LatencyUnloadedMs)
+		g.jitter = append(g.jitter, r.Summary.JitterMs)
+		g.loss = append(g.loss, r.Summary.PacketLossPercent)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make([]Bucket, 0, len(groups))
+	for key, g := range groups {
+		b := Bucket{
+			Key:               key,
+			Count:             len(g.down),
+			DownloadMbps:      percentiles(g.down),
+			UploadMbps:        percentiles(g.up),
+			LatencyMs:         percentiles(g.latency),
+			JitterMs:          percentiles(g.jitter),
+			PacketLossPercent: percentiles(g.loss),
+		}
+		if group == GroupASN {
+			b.ASOrg = g.asOrg
+		}
+		buckets = append(buckets, b)
+	}
+	slices.SortFunc(buckets, func(a, b Bucket) int {
+		return cmp.Or(cmp.Compare(groups[a.Key].sortKey, groups[b.Key].sortKey), strings.Compare(a.Key, b.Key))
+	})
+	return buckets, nil
+}
+
+// groupKey returns the bucket of r and a number to order buckets by; colos
+// and agents are ordered by key alone.
+func groupKey(r *Result, group string) (string, int64) {
+	switch group {
+	case GroupHour:
+		t := r.CreatedAt.UTC().Truncate(time.Hour)
+		return t.Format(time.RFC3339), t.Unix()
+	case GroupDay:
+		t := r.CreatedAt.UTC()
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.Format(time.DateOnly), day.Unix()
+	case GroupASN:
+		return strconv.Itoa(r.Client.ASN), int64(r.Client.ASN)
+	case GroupAgent:
+		return r.Agent, 0
+	default:
+		return r.Colo, 0
+	}
+}
+
+// percentiles computes the Percentiles of values, which it sorts. Like the
+// web UI, it uses the nearest-rank method.
+func percentiles(values []float64) Percentiles {
+	slices.Sort(values)
+	rank := func(p float64) float64 {
+		if len(values) == 0 {
+			return 0
+		}
+		i := int(math.Ceil(p/100*float64(len(values)))) - 1
+		return values[max(0, i)]
+	}
+	return Percentiles{
+		P10: rank(10),
+		P25: rank(25),
+		P50: rank(50),
+		P75: rank(75),
+		P90: rank(90),
+		P95: rank(95),
+	}
+}
@@ -0,0 +1,204 @@
+package results
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Export formats.
+const (
+	FormatCSV     = "csv"
+	FormatNDJSON  = "ndjson"
+	FormatParquet = "parquet"
+)
+
+// Formats are the formats Export accepts.
+var Formats = []string{FormatCSV, FormatNDJSON, FormatParquet}
+
+// ContentType returns the MIME type of an export format.
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	default:
+		return "application/vnd.apache.parquet"
+	}
+}
+
+// exportBatch is how many results are read per database transaction, so a
+// slow reader does not hold one open for the whole export.
+const exportBatch = 500
+
+// parquetRowGroup bounds the rows the Parquet writer buffers before it
+// writes them out.
+const parquetRowGroup = 10000
+
+// Row is a result flattened into one record for export. The client IP is
+// left out, as everywhere results are served.
+type Row struct {
+	ID                string    `json:"id" parquet:"id"`
+	CreatedAt         time.Time `json:"createdAt" parquet:"createdAt,timestamp(millisecond)"`
+	Colo              string    `json:"colo" parquet:"colo"`
+	ASN               int64     `json:"asn" parquet:"asn"`
+	ASOrg             string    `json:"asOrganization" parquet:"asOrganization"`
+	Country           string    `json:"country" parquet:"country"`
+	Region            string    `json:"region" parquet:"region"`
+	City              string    `json:"city" parquet:"city"`
+	HTTPProtocol      string    `json:"httpProtocol" parquet:"httpProtocol"`
+	DownloadMbps      float64   `json:"downloadMbps" parquet:"downloadMbps"`
+	UploadMbps        float64   `json:"uploadMbps" parquet:"uploadMbps"`
+	LatencyUnloadedMs float64   `json:"latencyUnloadedMs" parquet:"latencyUnloadedMs"`
+	LatencyDownloadMs float64   `json:"latencyDownloadMs" parquet:"latencyDownloadMs"`
+	LatencyUploadMs   float64   `json:"latencyUploadMs" parquet:"latencyUploadMs"`
+	JitterMs          float64   `json:"jitterMs" parquet:"jitterMs"`
+	PacketLossPercent float64   `json:"packetLossPercent" parquet:"packetLossPercent"`
+	Agent             string    `json:"agent" parquet:"agent"`
+	Job               string    `json:"job" parquet:"job"`
+}
+
+// csvHeader is the first line of a CSV export, in Row order.
+var csvHeader = []string{
+	"id", "createdAt", "colo", "asn", "asOrganization", "country", "region", "city", "httpProtocol",
+	"downloadMbps", "uploadMbps", "latencyUnloadedMs", "latencyDownloadMs", "latencyUploadMs",
+	"jitterMs", "packetLossPercent", "agent", "job",
+}
+
+// NewRow flattens r.
+func NewRow(r *Result) Row {
+	return Row{
+		ID:                r.ID,
+		CreatedAt:         r.CreatedAt.UTC(),
+		Colo:              r.Colo,
+		ASN:               int64(r.Client.ASN),
+		ASOrg:             r.Client.ASOrg,
+		Country:           r.Client.Country,
+		Region:            r.Client.Region,
+		City:              r.Client.City,
+		HTTPProtocol:      r.Client.HTTPProtocol,
+		DownloadMbps:      r.Summary.DownloadMbps,
+		UploadMbps:        r.Summary.UploadMbps,
+		LatencyUnloadedMs: r.Summary.LatencyUnloadedMs,
+		LatencyDownloadMs: r.Summary.LatencyDownloadMs,
+		LatencyUploadMs:   r.Summary.LatencyUploadMs,
+		JitterMs:          r.Summary.JitterMs,
+		PacketLossPercent: r.Summary.PacketLossPercent,
+		Agent:             r.Agent,
+		Job:               r.Job,
+	}
+}
+
+func (row *Row) csvRecord() []string {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	return []string{
+		row.ID, row.CreatedAt.Format(time.RFC3339Nano), row.Colo, strconv.FormatInt(row.ASN, 10),
+		row.ASOrg, row.Country, row.Region, row.City, row.HTTPProtocol,
+		f(row.DownloadMbps), f(row.UploadMbps), f(row.LatencyUnloadedMs), f(row.LatencyDownloadMs),
+		f(row.LatencyUploadMs), f(row.JitterMs), f(row.PacketLossPercent), row.Agent,
+		row.Job,
+	}
+}
+
+// rowWriter writes rows in one export format.
+type rowWriter interface {
+	Write(rows []Row) error
+	Close() error
+}
+
+type csvWriter struct{ w *csv.Writer }
+
+func (c *csvWriter) Write(rows []Row) error {
+	for i := range rows {
+		if err := c.w.Write(rows[i].csvRecord()); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) Close() error { return nil }
+
+type ndjsonWriter struct{ enc *json.Encoder }
+
+func (n *ndjsonWriter) Write(rows []Row) error {
+	for i := range rows {
+		if err := n.enc.Encode(&rows[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *ndjsonWriter) Close() error { return nil }
+
+type parquetWriter struct{ w *parquet.GenericWriter[Row] }
+
+func (p *parquetWriter) Write(rows []Row) error {
+	_, err := p.w.Write(rows)
+	return err
+}
+
+func (p *parquetWriter) Close() error { return p.w.Close() }
+
+// Export writes every result matching f to w in format (one of Formats),
+// newest first. Results are read and written in batches, so memory use
+// does not grow with the number of results. It returns how many results
+// were written.
+func (s *Store) Export(w io.Writer, f Filter, format string) (int, error) {
+	var out rowWriter
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return 0, err
+		}
+		out = &csvWriter{w: cw}
+	case FormatNDJSON:
+		out = &ndjsonWriter{enc: json.NewEncoder(w)}
+	case FormatParquet:
+		out = &parquetWriter{w: parquet.NewGenericWriter[Row](w,
+			parquet.Compression(&parquet.Snappy),
+			parquet.MaxRowsPerRowGroup(parquetRowGroup),
+		)}
+	default:
+		return 0, fmt.Errorf("unknown format %q (want one of %s)", format, strings.Join(Formats, ", "))
+	}
+
+	n := 0
+	rows := make([]Row, 0, exportBatch)
+	cursor := ""
+	for {
+		page, next, err := s.Query(f, cursor, exportBatch)
+		if err != nil {
+			return n, err
+		}
+		rows = rows[:0]
+		for _, r := range page {
+			rows = append(rows, NewRow(r))
+		}
+		if err := out.Write(rows); err != nil {
+			return n, err
+		}
+		n += len(rows)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	return n, out.Close()
+}
+
+// ValidFormat reports whether format is one of Formats.
+func ValidFormat(format string) bool {
+	return slices.Contains(Formats, format)
+}
@@ -0,0 +1,222 @@
+package results
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrInvalidCursor is returned for a cursor that Query did not hand out.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Filter selects stored results. The zero Filter matches everything.
+type Filter struct {
+	// From and To bound the creation time; To is exclusive. A zero time
+	// leaves that end open.
+	From, To time.Time
+	// ASN, if non-zero, is the client's autonomous system
+	ASN int
+	// Country, if set, is the client's ISO country code
+	Country string
+	// Colo, if set, is the colo that ran the test
+	Colo string
+	// Prefix, if valid, must contain the client IP
+	Prefix netip.Prefix
+	// Agent, if set, is the agent that pushed the result
+	Agent string
+	// Job, if set, is the job the result was pushed for
+	Job string
+}
+
+// Match reports whether r is selected by f. The time range is checked too,
+// although Scan only visits results inside it anyway.
+func (f *Filter) Match(r *Result) bool {
+	if !f.From.IsZero() && r.CreatedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !r.CreatedAt.Before(f.To) {
+		return false
+	}
+	if f.ASN != 0 && r.Client.ASN != f.ASN {
+		return false
+	}
+	if f.Country != "" && !strings.EqualFold(r.Client.Country, f.Country) {
+		return false
+	}
+	if f.Colo != "" && !strings.EqualFold(r.Colo, f.Colo) {
+		return false
+	}
+	if f.Agent != "" && r.Agent != f.Agent {
+		return false
+	}
+	if f.Job != "" && r.Job != f.Job {
+		return false
+	}
+	if f.Prefix.IsValid() {
+		ip, err := netip.ParseAddr(r.Client.ClientIP)
+		if err != nil || !f.Prefix.Contains(ip.Unmap()) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseFilter reads a Filter from query parameters: from and to (RFC 3339
+// or YYYY-MM-DD, to is exclusive), asn, country, colo, prefix (a CIDR
+// prefix or a single address), agent and job. Parameters that are missing
+// or empty leave that part of the filter open.
+func ParseFilter(q url.Values) (Filter, error) {
+	var f Filter
+	for name, dst := range map[string]*time.Time{"from": &f.From, "to": &f.To} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			if t, err = time.Parse(time.DateOnly, v); err != nil {
+				return f, fmt.Errorf("%s must be an RFC 3339 time or a YYYY-MM-DD date", name)
+			}
+		}
+		*dst = t
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return f, errors.New("from must be before to")
+	}
+	if v := q.Get("asn"); v != "" {
+		asn, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(v), "AS"))
+		if err != nil || asn <= 0 {
+			return f, errors.New("asn must be a positive number")
+		}
+		f.ASN = asn
+	}
+	if v := q.Get("country"); v != "" {
+		if len(v) != 2 {
+			return f, errors.New("country must be a two-letter code")
+		}
+		f.Country = v
+	}
+	f.Colo = q.Get("colo")
+	f.Agent = q.Get("agent")
+	f.Job = q.Get("job")
+	if v := q.Get("prefix"); v != "" {
+		if strings.Contains(v, "/") {
+			p, err := netip.ParsePrefix(v)
+			if err != nil {
+				return f, errors.New("prefix must be a CIDR prefix or an IP address")
+			}
+			f.Prefix = p.Masked()
+		} else {
+			ip, err := netip.ParseAddr(v)
+			if err != nil {
+				return f, errors.New("prefix must be a CIDR prefix or an IP address")
+			}
+			f.Prefix = netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen())
+		}
+	}
+	return f, nil
+}
+
+// Scan calls fn for every result matching f, newest first, until fn
+// returns false. If after is a cursor from an earlier Query, only results
+// older than the one it points at are visited.
+func (s *Store) Scan(f Filter, after string, fn func(*Result) bool) error {
+	var start []byte
+	if after != "" {
+		var err error
+		if start, err = decodeCursor(after); err != nil {
+			return err
+		}
+	}
+	var lower []byte
+	if !f.From.IsZero() {
+		lower = timeKey(f.From, "")
+	}
+
+	return s.db.View(func(tx *bolt.Tx) error {
+		results := tx.Bucket(resultsBucket)
+		c := tx.Bucket(byTimeBucket).Cursor()
+
+		var k []byte
+		switch {
+		case start != nil:
+			// Seek lands on the cursor's own key, or on the next one if it
+			// has gone; either way, step back from there
+			if k, _ = c.Seek(start); k == nil {
+				k, _ = c.Last()
+			}
+			if k != nil && bytes.Compare(k, start) >= 0 {
+				k, _ = c.Prev()
+			}
+		case !f.To.IsZero():
+			if k, _ = c.Seek(timeKey(f.To, "")); k == nil {
+				k, _ = c.Last()
+			} else {
+				k, _ = c.Prev()
+			}
+		default:
+			k, _ = c.Last()
+		}
+
+		for ; k != nil; k, _ = c.Prev() {
+			if lower != nil && bytes.Compare(k, lower) < 0 {
+				break
+			}
+			data := results.Get(k[8:])
+			if data == nil {
+				continue
+			}
+			r := new(Result)
+			if err := json.Unmarshal(data, r); err != nil {
+				return err
+			}
+			if !f.Match(r) {
+				continue
+			}
+			if !fn(r) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// Query returns up to limit results matching f, newest first, starting
+// after the cursor after (empty for the first page). next is the cursor of
+// the following page, or empty if there is none.
+func (s *Store) Query(f Filter, after string, limit int) (page []*Result, next string, err error) {
+	err = s.Scan(f, after, func(r *Result) bool {
+		if len(page) == limit {
+			next = Cursor(page[len(page)-1])
+			return false
+		}
+		page = append(page, r)
+		return true
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return page, next, nil
+}
+
+// Cursor returns a cursor pointing at r, for continuing a Query after it.
+func Cursor(r *Result) string {
+	return base64.RawURLEncoding.EncodeToString(timeKey(r.CreatedAt, r.ID))
+}
+
+func decodeCursor(cursor string) ([]byte, error) {
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(key) != 8+idLength || !ValidID(string(key[8:])) {
+		return nil, ErrInvalidCursor
+	}
+	return key, nil
+}
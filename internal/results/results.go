@@ -0,0 +1,84 @@
+// Package results stores completed test results so that they can be shared
+// by a short ID instead of being packed into the URL.
+//
+// A result is the document the browser submitted, kept verbatim, plus what
+// the server knew about the client at the time (its ClientMeta) and the
+// colo that ran the test. Results pushed by a monitoring agent also carry
+// the agent's ID. The headline numbers are copied out of the
+// document into Summary so results can be filtered without decoding it.
+package results
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/yellowman/netspeed/internal/meta"
+)
+
+// ErrNotFound is returned for an unknown result ID.
+var ErrNotFound = errors.New("result not found")
+
+// Summary holds the headline numbers of a result, as computed by the
+// client. The JSON names match the summary object of the submitted
+// document.
+type Summary struct {
+	DownloadMbps      float64 `json:"downloadMbps"`
+	UploadMbps        float64 `json:"uploadMbps"`
+	LatencyUnloadedMs float64 `json:"latencyUnloadedMs"`
+	LatencyDownloadMs float64 `json:"latencyDownloadMs"`
+	LatencyUploadMs   float64 `json:"latencyUploadMs"`
+	JitterMs          float64 `json:"jitterMs"`
+	PacketLossPercent float64 `json:"packetLossPercent"`
+}
+
+// Result is a stored test result.
+type Result struct {
+	ID        string          `json:"id"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Colo      string          `json:"colo"`
+	Client    meta.ClientMeta `json:"client"`
+	Summary   Summary         `json:"summary"`
+	// Agent is the ID of the agent that pushed the result, empty for
+	// results shared from a browser
+	Agent string `json:"agent,omitempty"`
+	// Job is the ID of the job the agent ran the test for, empty for
+	// scheduled tests
+	Job string `json:"job,omitempty"`
+	// Document is the result as the client submitted it
+	Document json.RawMessage `json:"result"`
+}
+
+// ParseDocument checks that doc is a JSON object with a summary object, as
+// sent by the web UI, and returns the summary.
+func ParseDocument(doc []byte) (Summary, error) {
+	var d struct {
+		Summary *Summary `json:"summary"`
+	}
+	if err := json.Unmarshal(doc, &d); err != nil {
+		return Summary{}, fmt.Errorf("invalid result document: %w", err)
+	}
+	if d.Summary == nil {
+		return Summary{}, errors.New("result document has no summary")
+	}
+	s := d.Summary
+	for name, v := range map[string]float64{
+		"downloadMbps":      s.DownloadMbps,
+		"uploadMbps":        s.UploadMbps,
+		"latencyUnloadedMs": s.LatencyUnloadedMs,
+		"latencyDownloadMs": s.LatencyDownloadMs,
+		"latencyUploadMs":   s.LatencyUploadMs,
+		"jitterMs":          s.JitterMs,
+		"packetLossPercent": s.PacketLossPercent,
+	} {
+		if v < 0 || math.IsInf(v, 0) {
+			return Summary{}, fmt.Errorf("summary.%s out of range", name)
+		}
+	}
+	if s.PacketLossPercent > 100 {
+		return Summary{}, errors.New("summary.packetLossPercent out of range")
+	}
+	return *s, nil
+}
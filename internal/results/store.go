@@ -0,0 +1,178 @@
+package results
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Buckets: results maps ID to the JSON-encoded Result; byTime maps the
+// creation time (big-endian Unix nanoseconds) followed by the ID to
+// nothing, so results can be walked in time order; pushes maps an agent ID
+// and the ID the agent gave a result, separated by a NUL, to the result ID.
+var (
+	resultsBucket = []byte("results")
+	byTimeBucket  = []byte("by_time")
+	pushesBucket  = []byte("pushes")
+)
+
+// idAlphabet avoids characters that are easily confused when a link is
+// read out or retyped.
+const idAlphabet = "23456789abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ"
+
+// idLength gives about 46 bits per ID, plenty to make them unguessable at
+// the rate results are submitted.
+const idLength = 8
+
+// Store keeps results in a bbolt database file. It is safe for concurrent
+// use, but only one process can have the file open.
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens or creates the database at path.
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open results database: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{resultsBucket, byTimeBucket, pushesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize results database: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Path returns the database file.
+func (s *Store) Path() string {
+	return s.db.Path()
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Add stores r under a new ID, which it sets on r.
+func (s *Store) Add(r *Result) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return add(tx, r)
+	})
+}
+
+// AddPush stores r, pushed by agent r.Agent under its own pushID, unless
+// the agent pushed that ID before: agents retry a push whose response they
+// did not get. It reports whether r was stored; if not, r is replaced by
+// the result stored the first time.
+func (s *Store) AddPush(r *Result, pushID string) (bool, error) {
+	added := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		pushes := tx.Bucket(pushesBucket)
+		key := []byte(r.Agent + "\x00" + pushID)
+		if id := pushes.Get(key); id != nil {
+			if data := tx.Bucket(resultsBucket).Get(id); data != nil {
+				*r = Result{}
+				return json.Unmarshal(data, r)
+			}
+		}
+		if err := add(tx, r); err != nil {
+			return err
+		}
+		added = true
+		return pushes.Put(key, []byte(r.ID))
+	})
+	return added, err
+}
+
+// add stores r under a new ID within tx.
+func add(tx *bolt.Tx, r *Result) error {
+	results := tx.Bucket(resultsBucket)
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	for results.Get([]byte(id)) != nil {
+		if id, err = newID(); err != nil {
+			return err
+		}
+	}
+	r.ID = id
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if err := results.Put([]byte(id), data); err != nil {
+		return err
+	}
+	return tx.Bucket(byTimeBucket).Put(timeKey(r.CreatedAt, id), nil)
+}
+
+// Get returns the result with the given ID, or ErrNotFound.
+func (s *Store) Get(id string) (*Result, error) {
+	var r *Result
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(resultsBucket).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		r = new(Result)
+		return json.Unmarshal(data, r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// timeKey is the by_time key of a result.
+func timeKey(t time.Time, id string) []byte {
+	key := make([]byte, 8, 8+len(id))
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return append(key, id...)
+}
+
+// newID returns a random result ID. Bytes past the last whole multiple of
+// the alphabet size are skipped so every character is equally likely.
+func newID() (string, error) {
+	const limit = 256 - 256%len(idAlphabet)
+	id := make([]byte, 0, idLength)
+	buf := make([]byte, 2*idLength)
+	for len(id) < idLength {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(id) < idLength {
+				id = append(id, idAlphabet[int(b)%len(idAlphabet)])
+			}
+		}
+	}
+	return string(id), nil
+}
+
+// ValidID reports whether id could have been returned by Add.
+func ValidID(id string) bool {
+	if len(id) != idLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if strings.IndexByte(idAlphabet, id[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/yellowman/netspeed/internal/config"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newACMEManager builds an autocert manager that obtains and renews a
+// certificate for cfg.Hostname. TLS-ALPN-01 is answered on the main TLS
+// listener; HTTP-01 is answered by the handler returned from
+// Manager.HTTPHandler when ACMEHTTPAddr is set.
+//
+// Renewal runs in the background and new certificates are picked up by
+// GetCertificate on the next handshake, so active tests are not interrupted.
+func newACMEManager(cfg *config.Config) (*autocert.Manager, error) {
+	if cfg.Hostname == "" || cfg.Hostname == "localhost" {
+		return nil, errors.New("acme requires a public hostname")
+	}
+	if cfg.TLSCertFile != "" || cfg.TLSKeyFile != "" {
+		return nil, errors.New("acme cannot be combined with tls_cert_file/tls_key_file")
+	}
+
+	client := &acme.Client{DirectoryURL: cfg.ACMEDirectoryURL}
+	if cfg.ACMECAFile != "" {
+		httpClient, err := acmeHTTPClient(cfg.ACMECAFile)
+		if err != nil {
+			return nil, err
+		}
+		client.HTTPClient = httpClient
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cfg.ACMECacheDir),
+		HostPolicy: autocert.HostWhitelist(cfg.Hostname),
+		Email:      cfg.ACMEEmail,
+		Client:     client,
+	}, nil
+}
+
+// acmeHTTPClient returns an HTTP client that trusts the system roots plus
+// the PEM certificates in caFile. Test CAs such as Pebble serve their
+// directory with a certificate signed by their own root.
+func acmeHTTPClient(caFile string) (*http.Client, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ACME CA file: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in ACME CA file %s", caFile)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: transport, Timeout: 30 * time.Second}, nil
+}
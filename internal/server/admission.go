@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/yellowman/netspeed/internal/admission"
+	"github.com/yellowman/netspeed/internal/config"
+	"github.com/yellowman/netspeed/internal/meta"
+)
+
+// constrainedHeader flags results measured while the node was
+// oversubscribed: "queued" if the transfer had to wait for a slot,
+// "saturated" if the bandwidth cap was hit while it shared the node.
+// /__down sends it as a trailer when that only happens mid-transfer.
+const constrainedHeader = "X-Server-Constrained"
+
+// busyRetryAfter is the Retry-After sent with 503 responses.
+const busyRetryAfter = 5 * time.Second
+
+// ticketContextKey stores the admission ticket in the request context.
+type ticketContextKey struct{}
+
+// admissionLimits converts the admission settings for the controller.
+func admissionLimits(cfg *config.Config) admission.Limits {
+	return admission.Limits{
+		MaxConcurrent: cfg.MaxConcurrentTests,
+		MaxEgressBps:  cfg.MaxEgressMbps * 1_000_000,
+		MaxIngressBps: cfg.MaxIngressMbps * 1_000_000,
+		QueueTimeout:  cfg.QueueTimeout,
+		MaxQueue:      cfg.MaxQueuedTests,
+	}
+}
+
+// busyError is the JSON body of a 503 response.
+type busyError struct {
+	Error      string `json:"error"`
+	Message    string `json:"message"`
+	RetryAfter int64  `json:"retryAfter"` // seconds
+}
+
+// admitted wraps a transfer endpoint with admission control. The transfer
+// holds a slot while it runs and its bytes are metered towards the node's
+// bandwidth. Latency probes (/__down with bytes=0) are not admitted or
+// counted, so they keep working on a busy node.
+func (s *Server) admitted(direction string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next(w, r)
+			return
+		}
+		if direction == admission.Egress {
+			if v, err := strconv.ParseInt(r.URL.Query().Get("bytes"), 10, 64); err != nil || v <= 0 {
+				next(w, r)
+				return
+			}
+		}
+
+		ticket, err := s.admission.Acquire(r.Context(), direction)
+		if err != nil {
+			if r.Context().Err() != nil {
+				return // client gave up while queued
+			}
+			load := s.admission.Load()
+			logger.Warn("Server busy, transfer rejected",
+				"client", meta.ClientIPFromRequest(r, s.config().TrustProxyHeaders), "path", r.URL.Path,
+				"active", load.Active, "queued", load.Queued)
+			writeServerBusy(w)
+			return
+		}
+		defer ticket.Release()
+
+		meter := s.admission.Meter(direction)
+		if direction == admission.Ingress {
+			r.Body = &countingReader{ReadCloser: r.Body, meter: meter}
+		} else {
+			w = &countingWriter{ResponseWriter: w, meter: meter}
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), ticketContextKey{}, ticket)))
+	}
+}
+
+// constrainedReason returns the X-Server-Constrained value for the request,
+// or "" if it was not affected by server load.
+func constrainedReason(r *http.Request) string {
+	ticket, _ := r.Context().Value(ticketContextKey{}).(*admission.Ticket)
+	switch {
+	case ticket == nil:
+		return ""
+	case ticket.Queued():
+		return "queued"
+	case ticket.Constrained():
+		return "saturated"
+	default:
+		return ""
+	}
+}
+
+// constrainedAttr is reason as a transfer log attribute; empty if none.
+func constrainedAttr(reason string) slog.Attr {
+	if reason == "" {
+		return slog.Attr{}
+	}
+	return slog.String("constrained", reason)
+}
+
+// writeServerBusy sends a 503 with Retry-After and a JSON description.
+func writeServerBusy(w http.ResponseWriter) {
+	retryAfter := int64(busyRetryAfter / time.Second)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(busyError{
+		Error:      "server_busy",
+		Message:    "too many tests running on this server, try again later",
+		RetryAfter: retryAfter,
+	})
+}
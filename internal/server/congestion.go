@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/yellowman/netspeed/internal/config"
+)
+
+// congestionHeader reports the TCP congestion control algorithm in effect
+// for the connection that carried a /__down or /__up request.
+const congestionHeader = "X-Congestion-Control"
+
+// errCongestionUnsupported is returned where TCP_CONGESTION is not available.
+var errCongestionUnsupported = errors.New("congestion control selection is not supported on this platform")
+
+// connContextKey stores the accepted net.Conn in the request context.
+type connContextKey struct{}
+
+// withConn is used as http.Server.ConnContext so handlers can reach the
+// socket behind a request.
+func withConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// tcpConnFromRequest returns the TCP socket behind r, or nil for unix
+// sockets and HTTP/3.
+func tcpConnFromRequest(r *http.Request) syscall.Conn {
+	c, _ := r.Context().Value(connContextKey{}).(net.Conn)
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	if tcp, ok := c.(*net.TCPConn); ok {
+		return tcp
+	}
+	return nil
+}
+
+// applyCongestion handles the cc= query parameter on /__down and /__up and
+// sets the congestion control header. On HTTP/2 the algorithm applies to the
+// whole connection, and it stays in effect for later requests on the same
+// connection. It writes an error response and returns false if the request
+// cannot be served as asked.
+func applyCongestion(w http.ResponseWriter, r *http.Request, cfg *config.Config) bool {
+	conn := tcpConnFromRequest(r)
+
+	if cc := r.URL.Query().Get("cc"); cc != "" {
+		if !cfg.CongestionAllowed(cc) {
+			http.Error(w, "congestion control not allowed", http.StatusBadRequest)
+			return false
+		}
+		if conn == nil {
+			http.Error(w, "congestion control requires a TCP connection", http.StatusBadRequest)
+			return false
+		}
+		if err := setCongestion(conn, cc); err != nil {
+			http.Error(w, "congestion control unavailable: "+err.Error(), http.StatusNotImplemented)
+			return false
+		}
+	}
+
+	if conn != nil {
+		if cc, err := getCongestion(conn); err == nil {
+			w.Header().Set(congestionHeader, cc)
+		}
+	}
+	return true
+}
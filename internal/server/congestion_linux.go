@@ -0,0 +1,41 @@
+package server
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setCongestion sets TCP_CONGESTION on conn. On a listening socket the
+// algorithm is inherited by every accepted connection.
+func setCongestion(conn syscall.Conn, algorithm string) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptString(int(fd), unix.IPPROTO_TCP, unix.TCP_CONGESTION, algorithm)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// getCongestion returns the TCP_CONGESTION algorithm in effect on conn.
+func getCongestion(conn syscall.Conn) (string, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return "", err
+	}
+	var algorithm string
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		algorithm, sockErr = unix.GetsockoptString(int(fd), unix.IPPROTO_TCP, unix.TCP_CONGESTION)
+	})
+	if err != nil {
+		return "", err
+	}
+	return algorithm, sockErr
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package server
+
+import "syscall"
+
+// setCongestion is only implemented on Linux.
+func setCongestion(conn syscall.Conn, algorithm string) error {
+	return errCongestionUnsupported
+}
+
+// getCongestion is only implemented on Linux.
+func getCongestion(conn syscall.Conn) (string, error) {
+	return "", errCongestionUnsupported
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// countingWriter counts response body bytes, optionally adding them to a
+// shared meter as they are written.
+type countingWriter struct {
+	http.ResponseWriter
+	n     int64
+	meter *atomic.Int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.ResponseWriter.Write(p)
+	cw.n += int64(n)
+	if cw.meter != nil {
+		cw.meter.Add(int64(n))
+	}
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *countingWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// countingReader counts request body bytes, optionally adding them to a
+// shared meter as they are read.
+type countingReader struct {
+	io.ReadCloser
+	n     int64
+	meter *atomic.Int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	cr.n += int64(n)
+	if cr.meter != nil {
+		cr.meter.Add(int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/yellowman/netspeed/api"
+	"github.com/yellowman/netspeed/internal/fleet"
+)
+
+// Long-poll limits for /api/agents/jobs.
+const (
+	defaultJobWait = 30 * time.Second
+	maxJobWait     = 60 * time.Second
+)
+
+// maxAgentRequest bounds the body of a heartbeat, job report or job
+// request.
+const maxAgentRequest = 64 << 10
+
+// agentAllowed checks that agents are enabled and that the request carries
+// an agent key. It writes the error response and returns false otherwise.
+func (s *Server) agentAllowed(w http.ResponseWriter, r *http.Request) (string, bool) {
+	keys := s.config().AgentKeys
+	if len(keys) == 0 {
+		writeResultError(w, http.StatusNotFound, "agents_disabled", "this server does not accept agents")
+		return "", false
+	}
+	agent, ok := agentFor(keys, tokenFromRequest(r))
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="netspeed"`)
+		writeResultError(w, http.StatusUnauthorized, "unauthorized", "a valid agent key is required")
+		return "", false
+	}
+	return agent, true
+}
+
+// jobsEnabled reports whether jobs can be dispatched: they are created
+// over the admin API and their results are stored.
+func (s *Server) jobsEnabled() bool {
+	return s.config().AdminToken != "" && s.results != nil
+}
+
+// adminAllowed checks that the admin API is enabled and that the request
+// carries the admin token. It writes the error response and returns false
+// otherwise.
+func (s *Server) adminAllowed(w http.ResponseWriter, r *http.Request) bool {
+	token := s.config().AdminToken
+	if token == "" {
+		writeResultError(w, http.StatusNotFound, "admin_disabled", "the admin API is not enabled on this server")
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(tokenFromRequest(r)), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="netspeed"`)
+		writeResultError(w, http.StatusUnauthorized, "unauthorized", "a valid admin token is required")
+		return false
+	}
+	return true
+}
+
+// decodeAgentRequest reads a JSON request body into v, writing the error
+// response if it cannot.
+func decodeAgentRequest(w http.ResponseWriter, r *http.Request, v any, code string) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAgentRequest)).Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeResultError(w, http.StatusRequestEntityTooLarge, "too_large", "request body is too large")
+			return false
+		}
+		writeResultError(w, http.StatusBadRequest, code, "invalid JSON body")
+		return false
+	}
+	return true
+}
+
+// writeAgentJSON sends v as an uncached JSON response.
+func writeAgentJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// handleAgentHeartbeat handles POST /api/agents/heartbeat - records that an
+// agent is up, with its labels and what it tests.
+func (s *Server) handleAgentHeartbeat(w http.ResponseWriter, r *http.Request) {
+	agent, ok := s.agentAllowed(w, r)
+	if !ok {
+		return
+	}
+	var hb api.AgentHeartbeat
+	if !decodeAgentRequest(w, r, &hb, "invalid_heartbeat") {
+		return
+	}
+	if err := fleet.ValidateLabels(hb.Labels); err != nil {
+		writeResultError(w, http.StatusBadRequest, "invalid_heartbeat", err.Error())
+		return
+	}
+	s.fleet.Heartbeat(agent, hb, s.metaFor(r))
+	logger.Debug("Agent heartbeat", "agent", agent, "version", hb.Version, "labels", len(hb.Labels))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAgentJobs handles GET /api/agents/jobs - hands the agent its next
+// job, waiting up to the wait parameter for one to be created. It answers
+// 204 if none was.
+func (s *Server) handleAgentJobs(w http.ResponseWriter, r *http.Request) {
+	agent, ok := s.agentAllowed(w, r)
+	if !ok {
+		return
+	}
+	if !s.jobsEnabled() {
+		writeResultError(w, http.StatusNotFound, "jobs_disabled", "this server does not dispatch jobs")
+		return
+	}
+	wait := defaultJobWait
+	if v := r.URL.Query().Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 || d > maxJobWait {
+			writeResultError(w, http.StatusBadRequest, "invalid_query", "wait must be a duration of at most "+maxJobWait.String())
+			return
+		}
+		wait = d
+	}
+	// The poll may outlast write_timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + 10*time.Second))
+
+	job, err := s.fleet.Next(r.Context(), agent, wait, s.metaFor(r))
+	switch {
+	case errors.Is(err, fleet.ErrClosed):
+		writeResultError(w, http.StatusServiceUnavailable, "shutting_down", "the server is shutting down")
+		return
+	case err != nil:
+		// The agent went away
+		return
+	case job == nil:
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	logger.Info("Job dispatched", "job", job.ID, "agent", agent, "test", job.Spec.Test)
+	writeAgentJSON(w, http.StatusOK, job)
+}
+
+// handleAgentJobStatus handles POST /api/agents/jobs/{id}/status - records
+// that the agent started a job, or that it failed.
+func (s *Server) handleAgentJobStatus(w http.ResponseWriter, r *http.Request) {
+	agent, ok := s.agentAllowed(w, r)
+	if !ok {
+		return
+	}
+	var rep api.JobReport
+	if !decodeAgentRequest(w, r, &rep, "invalid_report") {
+		return
+	}
+	id := r.PathValue("id")
+	err := s.fleet.Report(agent, id, rep)
+	switch {
+	case errors.Is(err, fleet.ErrUnknownJob):
+		writeResultError(w, http.StatusNotFound, "not_found", "no such job for this agent")
+		return
+	case errors.Is(err, fleet.ErrTaskState):
+		writeResultError(w, http.StatusConflict, "job_finished", "the job is no longer running on this agent")
+		return
+	case err != nil:
+		writeResultError(w, http.StatusBadRequest, "invalid_report", err.Error())
+		return
+	}
+	if rep.State == api.TaskFailed {
+		logger.Warn("Job failed", "job", id, "agent", agent, "error", rep.Error)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAdminAgents handles GET /api/admin/agents - lists the agents that
+// have been in touch since the server started, and those with a key that
+// have not, by ID.
+func (s *Server) handleAdminAgents(w http.ResponseWriter, r *http.Request) {
+	if !s.adminAllowed(w, r) {
+		return
+	}
+	list := s.fleet.Agents()
+	for id := range s.config().AgentKeys {
+		if !slices.ContainsFunc(list, func(a api.AgentStatus) bool { return a.ID == id }) {
+			list = append(list, api.AgentStatus{ID: id, Labels: map[string]string{}})
+		}
+	}
+	slices.SortFunc(list, func(a, b api.AgentStatus) int { return strings.Compare(a.ID, b.ID) })
+	writeAgentJSON(w, http.StatusOK, api.AgentList{Agents: list})
+}
+
+// handleAdminAgent handles GET /api/admin/agents/{id}.
+func (s *Server) handleAdminAgent(w http.ResponseWriter, r *http.Request) {
+	if !s.adminAllowed(w, r) {
+		return
+	}
+	id := r.PathValue("id")
+	status, ok := s.fleet.Agent(id)
+	if !ok {
+		if _, known := s.config().AgentKeys[id]; !known {
+			writeResultError(w, http.StatusNotFound, "not_found", "no such agent")
+			return
+		}
+		status = api.AgentStatus{ID: id, Labels: map[string]string{}}
+	}
+	writeAgentJSON(w, http.StatusOK, status)
+}
+
+// handleAdminJobs handles GET /api/admin/jobs, which lists jobs newest
+// first, and POST /api/admin/jobs, which creates one.
+func (s *Server) handleAdminJobs(w http.ResponseWriter, r *http.Request) {
+	if !s.adminAllowed(w, r) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		writeAgentJSON(w, http.StatusOK, api.JobList{Jobs: s.fleet.Jobs()})
+		return
+	}
+
+	if s.results == nil {
+		writeResultError(w, http.StatusNotFound, "results_disabled", "jobs need results_db to store their results")
+		return
+	}
+	var req api.JobRequest
+	if !decodeAgentRequest(w, r, &req, "invalid_job") {
+		return
+	}
+	keys := s.config().AgentKeys
+	job, err := s.fleet.CreateJob(req, func(id string) bool {
+		_, ok := keys[id]
+		return ok
+	})
+	switch {
+	case errors.Is(err, fleet.ErrNoAgents):
+		writeResultError(w, http.StatusConflict, "no_agents", "no online agent matches the job")
+		return
+	case err != nil:
+		writeResultError(w, http.StatusBadRequest, "invalid_job", err.Error())
+		return
+	}
+	logger.Info("Job created", "job", job.ID, "test", job.Spec.Test, "agents", len(job.Tasks))
+	w.Header().Set("Location", "/api/admin/jobs/"+job.ID)
+	writeAgentJSON(w, http.StatusCreated, job)
+}
+
+// handleAdminJob handles GET /api/admin/jobs/{id}, and DELETE, which
+// cancels the tasks of the job that no agent has picked up yet.
+func (s *Server) handleAdminJob(w http.ResponseWriter, r *http.Request) {
+	if !s.adminAllowed(w, r) {
+		return
+	}
+	id := r.PathValue("id")
+	var job api.Job
+	var err error
+	if r.Method == http.MethodDelete {
+		job, err = s.fleet.Cancel(id)
+	} else {
+		job, err = s.fleet.Job(id)
+	}
+	if err != nil {
+		writeResultError(w, http.StatusNotFound, "not_found", "no such job")
+		return
+	}
+	if r.Method == http.MethodDelete {
+		logger.Info("Job canceled", "job", id)
+	}
+	writeAgentJSON(w, http.StatusOK, job)
+}
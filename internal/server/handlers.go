@@ -7,14 +7,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/yellowman/netspeed/api"
 	"github.com/yellowman/netspeed/internal/meta"
+	"github.com/yellowman/netspeed/internal/metrics"
+	"github.com/yellowman/netspeed/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // calculateSpeedMbps calculates speed in megabits per second from bytes and duration.
@@ -31,14 +35,6 @@ func calculateSpeedMbps(bytes int64, duration time.Duration) float64 {
 	return megabits / seconds
 }
 
-// formatSpeed returns a human-readable speed string.
-func formatSpeed(mbps float64) string {
-	if mbps >= 1000 {
-		return fmt.Sprintf("%.2f Gbps", mbps/1000)
-	}
-	return fmt.Sprintf("%.2f Mbps", mbps)
-}
-
 // handleMeta handles GET /meta - returns client metadata.
 func (s *Server) handleMeta(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -46,7 +42,7 @@ func (s *Server) handleMeta(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	clientMeta := s.metaProvider.MetaFor(r)
+	clientMeta := s.metaFor(r)
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.Header().Set("Cache-Control", "no-store")
@@ -65,6 +61,7 @@ func (s *Server) handleDown(w http.ResponseWriter, r *http.Request) {
 	}
 
 	start := time.Now()
+	cfg := s.config()
 
 	// Parse query parameters
 	bytesStr := r.URL.Query().Get("bytes")
@@ -82,20 +79,50 @@ func (s *Server) handleDown(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "bytes cannot be negative", http.StatusBadRequest)
 			return
 		}
-		if v > s.cfg.MaxBytes {
+		if v > cfg.MaxBytes {
 			http.Error(w, "bytes exceeds maximum allowed", http.StatusBadRequest)
 			return
 		}
 		nBytes = v
 	}
 
+	if !applyCongestion(w, r, cfg) {
+		return
+	}
+
 	// Get client info for headers and logging
-	clientMeta := s.metaProvider.MetaFor(r)
+	clientMeta := s.metaFor(r)
 	clientIP := clientMeta.ClientIP
 
+	// Sample TCP_INFO for real transfers and send the summary as a trailer.
+	// HTTP/1.1 only carries trailers in chunked responses, so drop
+	// Content-Length when the client asked for them with "TE: trailers".
+	var sampler *tcpSampler
+	if nBytes > 0 && cfg.TCPInfoInterval > 0 {
+		if conn := tcpConnFromRequest(r); conn != nil {
+			sampler = startTCPSampler(conn, cfg.TCPInfoInterval)
+		}
+	}
+	chunked := sampler != nil && r.ProtoMajor == 1 && acceptsTrailers(r)
+
 	// Set headers
 	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Length", strconv.FormatInt(nBytes, 10))
+	if !chunked {
+		w.Header().Set("Content-Length", strconv.FormatInt(nBytes, 10))
+	}
+	if sampler != nil {
+		w.Header().Add("Trailer", tcpInfoTrailer)
+	}
+	// Flag results skewed by server load; if the node only becomes
+	// oversubscribed mid-transfer, say so in a trailer where possible
+	constrained := constrainedReason(r)
+	constrainedTrailer := false
+	if constrained != "" {
+		w.Header().Set(constrainedHeader, constrained)
+	} else if nBytes > 0 && (r.ProtoMajor > 1 || chunked) {
+		w.Header().Add("Trailer", constrainedHeader)
+		constrainedTrailer = true
+	}
 	s.setMetaHeaders(w, clientMeta, start)
 
 	// Set Server-Timing header before body starts (measures server-side latency)
@@ -105,13 +132,20 @@ func (s *Server) handleDown(w http.ResponseWriter, r *http.Request) {
 	// If bytes == 0, this is a latency-only test (TTFB measurement)
 	if nBytes == 0 {
 		w.WriteHeader(http.StatusOK)
-		latencyMs := float64(time.Since(start).Microseconds()) / 1000.0
-		if phase != "" {
-			log.Printf("Latency probe: client=%s measId=%s phase=%s latency=%.3fms",
-				clientIP, measId, phase, latencyMs)
-		} else {
-			log.Printf("Latency probe: client=%s measId=%s latency=%.3fms",
-				clientIP, measId, latencyMs)
+		latency := time.Since(start)
+		metrics.ObserveLatencyProbe(latency)
+		// A browser test sends dozens of these, so only a sample is logged
+		if probeSampler.Allow() {
+			attrs := []slog.Attr{
+				slog.String("client", clientIP),
+				slog.String("measId", measId),
+				slog.Duration("latency", latency),
+				probeSampler.Attr(),
+			}
+			if phase != "" {
+				attrs = append(attrs, slog.String("phase", phase))
+			}
+			logger.LogAttrs(r.Context(), slog.LevelInfo, "Latency probe", attrs...)
 		}
 		return
 	}
@@ -130,8 +164,16 @@ func (s *Server) handleDown(w http.ResponseWriter, r *http.Request) {
 			duration := time.Since(start)
 			bytesSent := nBytes - remaining
 			speedMbps := calculateSpeedMbps(bytesSent, duration)
-			log.Printf("Download interrupted: client=%s measId=%s bytes=%d/%d duration=%s speed=%s",
-				clientIP, measId, bytesSent, nBytes, duration, formatSpeed(speedMbps))
+			metrics.ObserveTransfer(metrics.Download, bytesSent, speedMbps, false)
+			logger.LogAttrs(r.Context(), slog.LevelInfo, "Download interrupted",
+				slog.String("client", clientIP),
+				slog.String("measId", measId),
+				slog.Int64("bytes", bytesSent),
+				slog.Int64("requestedBytes", nBytes),
+				slog.Duration("duration", duration),
+				slog.Float64("speedMbps", speedMbps),
+				stopSampler(sampler, measId),
+			)
 			return
 		}
 		remaining -= int64(n)
@@ -140,8 +182,27 @@ func (s *Server) handleDown(w http.ResponseWriter, r *http.Request) {
 	// Log completed download with speed
 	duration := time.Since(start)
 	speedMbps := calculateSpeedMbps(nBytes, duration)
-	log.Printf("Download: client=%s measId=%s bytes=%d duration=%s speed=%s",
-		clientIP, measId, nBytes, duration, formatSpeed(speedMbps))
+	metrics.ObserveTransfer(metrics.Download, nBytes, speedMbps, true)
+	var tcpInfo *TCPInfoSummary
+	if sampler != nil {
+		tcpInfo = sampler.Stop(measId)
+		w.Header().Set(tcpInfoTrailer, tcpInfo.trailerValue())
+	}
+	if constrained == "" {
+		constrained = constrainedReason(r)
+		if constrained != "" && constrainedTrailer {
+			w.Header().Set(constrainedHeader, constrained)
+		}
+	}
+	logger.LogAttrs(r.Context(), slog.LevelInfo, "Download",
+		slog.String("client", clientIP),
+		slog.String("measId", measId),
+		slog.Int64("bytes", nBytes),
+		slog.Duration("duration", duration),
+		slog.Float64("speedMbps", speedMbps),
+		tcpInfo.logAttr(),
+		constrainedAttr(constrained),
+	)
 }
 
 // handleUp handles POST /__up - upload sink endpoint.
@@ -152,37 +213,89 @@ func (s *Server) handleUp(w http.ResponseWriter, r *http.Request) {
 	}
 
 	start := time.Now()
+	cfg := s.config()
+
+	if !applyCongestion(w, r, cfg) {
+		return
+	}
+
+	// Sample TCP_INFO while the body arrives
+	var sampler *tcpSampler
+	if cfg.TCPInfoInterval > 0 {
+		if conn := tcpConnFromRequest(r); conn != nil {
+			sampler = startTCPSampler(conn, cfg.TCPInfoInterval)
+		}
+	}
 
 	// Read and discard body safely with limit
-	n, err := io.Copy(io.Discard, io.LimitReader(r.Body, s.cfg.MaxBytes))
+	n, err := io.Copy(io.Discard, io.LimitReader(r.Body, cfg.MaxBytes))
 	if err != nil {
-		log.Printf("Upload read error: %v", err)
+		logger.Warn("Upload read error", "error", err)
 	}
 
 	// Calculate timing and speed
 	duration := time.Since(start)
 	speedMbps := calculateSpeedMbps(n, duration)
+	metrics.ObserveTransfer(metrics.Upload, n, speedMbps, err == nil)
 
 	// Log upload details with speed
 	measId := r.URL.Query().Get("measId")
-	clientIP := meta.ClientIPFromRequest(r, s.cfg.TrustProxyHeaders)
-	log.Printf("Upload: client=%s measId=%s bytes=%d duration=%s speed=%s",
-		clientIP, measId, n, duration, formatSpeed(speedMbps))
+	var tcpInfo *TCPInfoSummary
+	if sampler != nil {
+		tcpInfo = sampler.Stop(measId)
+	}
+	constrained := constrainedReason(r)
+	clientIP := meta.ClientIPFromRequest(r, cfg.TrustProxyHeaders)
+	logger.LogAttrs(r.Context(), slog.LevelInfo, "Upload",
+		slog.String("client", clientIP),
+		slog.String("measId", measId),
+		slog.Int64("bytes", n),
+		slog.Duration("duration", duration),
+		slog.Float64("speedMbps", speedMbps),
+		tcpInfo.logAttr(),
+		constrainedAttr(constrained),
+	)
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if constrained != "" {
+		w.Header().Set(constrainedHeader, constrained)
+	}
 	s.setServerTiming(w, start)
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"ok":true}`))
+	json.NewEncoder(w).Encode(upResponse{
+		OK:          true,
+		MeasID:      measId,
+		Bytes:       n,
+		TCPInfo:     tcpInfo,
+		Constrained: constrained,
+	})
+}
+
+// upResponse is the JSON body returned by /__up.
+type upResponse struct {
+	OK      bool            `json:"ok"`
+	MeasID  string          `json:"measId,omitempty"`
+	Bytes   int64           `json:"bytes"`
+	TCPInfo *TCPInfoSummary `json:"tcpInfo,omitempty"`
+	// Constrained mirrors the X-Server-Constrained header
+	Constrained string `json:"constrained,omitempty"`
 }
 
 // handleLocations handles GET /locations - returns list of test locations.
+// Disabled locations are left out.
 func (s *Server) handleLocations(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	locs := s.locations.All()
+	// Filter into a new slice: a store may hand out its own
+	locs := []api.Location{}
+	for _, l := range s.locationStore().All() {
+		if l.Status != api.LocationDisabled {
+			locs = append(locs, l)
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.Header().Set("Cache-Control", "public, max-age=86400")
@@ -199,7 +312,7 @@ func (s *Server) handleTrace(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	clientMeta := s.metaProvider.MetaFor(r)
+	clientMeta := s.metaFor(r)
 	tlsVersion := getTLSVersion(r)
 	httpVersion := getHTTPVersion(r)
 
@@ -218,13 +331,7 @@ func (s *Server) handleTrace(w http.ResponseWriter, r *http.Request) {
 }
 
 // TurnCredentialsResponse is the response for /api/turn/credentials.
-type TurnCredentialsResponse struct {
-	Username   string   `json:"username"`
-	Credential string   `json:"credential"`
-	TTLSec     int64    `json:"ttlSec"`
-	Servers    []string `json:"servers"`
-	Realm      string   `json:"realm"`
-}
+type TurnCredentialsResponse = api.TurnCredentialsResponse
 
 // handleTurnCredentials handles GET /api/turn/credentials.
 func (s *Server) handleTurnCredentials(w http.ResponseWriter, r *http.Request) {
@@ -233,11 +340,13 @@ func (s *Server) handleTurnCredentials(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	cfg := s.config()
+
 	// Determine TURN servers - either configured or derived from embedded TURN
 	var turnServers []string
-	if len(s.cfg.TurnServers) > 0 {
-		turnServers = s.cfg.TurnServers
-	} else if s.cfg.EmbeddedTurnPort != "" && s.cfg.TurnSecret != "" {
+	if len(cfg.TurnServers) > 0 {
+		turnServers = cfg.TurnServers
+	} else if cfg.EmbeddedTurnPort != "" && cfg.TurnSecret != "" {
 		// Derive TURN server URL from request host
 		host := r.Host
 		// Strip port from host if present, handling IPv6 addresses properly
@@ -252,13 +361,13 @@ func (s *Server) handleTurnCredentials(w http.ResponseWriter, r *http.Request) {
 		}
 		// Include both STUN and TURN URLs - browsers need STUN for reflexive candidates
 		turnServers = []string{
-			fmt.Sprintf("stun:%s:%s", host, s.cfg.EmbeddedTurnPort),
-			fmt.Sprintf("turn:%s:%s?transport=udp", host, s.cfg.EmbeddedTurnPort),
+			fmt.Sprintf("stun:%s:%s", host, cfg.EmbeddedTurnPort),
+			fmt.Sprintf("turn:%s:%s?transport=udp", host, cfg.EmbeddedTurnPort),
 		}
 	}
 
 	// Check if TURN is configured
-	if s.cfg.TurnSecret == "" || len(turnServers) == 0 {
+	if cfg.TurnSecret == "" || len(turnServers) == 0 {
 		http.Error(w, "TURN not configured", http.StatusServiceUnavailable)
 		return
 	}
@@ -276,8 +385,8 @@ func (s *Server) handleTurnCredentials(w http.ResponseWriter, r *http.Request) {
 	if ttl < 60 {
 		ttl = 60
 	}
-	if ttl > s.cfg.MaxTurnTTL {
-		ttl = s.cfg.MaxTurnTTL
+	if ttl > cfg.MaxTurnTTL {
+		ttl = cfg.MaxTurnTTL
 	}
 
 	// Compute expiry and generate username
@@ -289,7 +398,7 @@ func (s *Server) handleTurnCredentials(w http.ResponseWriter, r *http.Request) {
 	username := fmt.Sprintf("%d:%s", exp, token)
 
 	// Compute HMAC-SHA1 credential
-	mac := hmac.New(sha1.New, []byte(s.cfg.TurnSecret))
+	mac := hmac.New(sha1.New, []byte(cfg.TurnSecret))
 	mac.Write([]byte(username))
 	credential := base64.StdEncoding.EncodeToString(mac.Sum(nil))
 
@@ -298,10 +407,12 @@ func (s *Server) handleTurnCredentials(w http.ResponseWriter, r *http.Request) {
 		Credential: credential,
 		TTLSec:     ttl,
 		Servers:    turnServers,
-		Realm:      s.cfg.TurnRealm,
+		Realm:      cfg.TurnRealm,
 	}
 
-	log.Printf("TURN credentials: servers=%v username=%s realm=%s", turnServers, username, s.cfg.TurnRealm)
+	trace.SpanFromContext(r.Context()).SetAttributes(tracing.TURNUser(username))
+
+	logger.Info("TURN credentials issued", "servers", turnServers, "username", username, "realm", cfg.TurnRealm)
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.Header().Set("Cache-Control", "no-store")
@@ -310,18 +421,10 @@ func (s *Server) handleTurnCredentials(w http.ResponseWriter, r *http.Request) {
 }
 
 // PacketTestOfferRequest is the request body for /api/packet-test/offer.
-type PacketTestOfferRequest struct {
-	SDP         string `json:"sdp"`
-	Type        string `json:"type"`
-	TestProfile string `json:"testProfile,omitempty"`
-}
+type PacketTestOfferRequest = api.PacketTestOfferRequest
 
 // PacketTestOfferResponse is the response for /api/packet-test/offer.
-type PacketTestOfferResponse struct {
-	SDP    string `json:"sdp"`
-	Type   string `json:"type"`
-	TestID string `json:"testId"`
-}
+type PacketTestOfferResponse = api.PacketTestOfferResponse
 
 // handlePacketTestOffer handles POST /api/packet-test/offer.
 // This endpoint performs WebRTC signaling for packet loss testing.
@@ -355,12 +458,14 @@ func (s *Server) handlePacketTestOffer(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Handle the offer and get an answer
-	answerSDP, testID, err := s.webrtcManager.HandleOffer(req.SDP, req.TestProfile)
+	answerSDP, testID, err := s.webrtcManager.HandleOffer(r.Context(), req.SDP, req.TestProfile)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("failed to process offer: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	trace.SpanFromContext(r.Context()).SetAttributes(tracing.TestID(testID))
+
 	resp := PacketTestOfferResponse{
 		SDP:    answerSDP,
 		Type:   "answer",
@@ -374,17 +479,32 @@ func (s *Server) handlePacketTestOffer(w http.ResponseWriter, r *http.Request) {
 }
 
 // PacketTestReportRequest is the request body for /api/packet-test/report.
-type PacketTestReportRequest struct {
-	TestID            string  `json:"testId"`
-	Sent              int     `json:"sent"`
-	Received          int     `json:"received"`
-	LossPercent       float64 `json:"lossPercent"`
-	RTTMin            float64 `json:"rttMinMs"`
-	RTTMedian         float64 `json:"rttMedianMs"`
-	RTTP90            float64 `json:"rttP90Ms"`
-	JitterMs          float64 `json:"jitterMs"`
-	TurnServer        string  `json:"turnServer,omitempty"`
-	TransportProtocol string  `json:"transportProtocol,omitempty"`
+type PacketTestReportRequest = api.PacketTestReportRequest
+
+// maxPacketTestMs bounds the round trip times and jitter of a packet test
+// report; a probe that took longer was lost, not measured.
+const maxPacketTestMs = 60_000
+
+// checkPacketTestReport returns an error if a figure in req is out of
+// range, so that a bogus report can't skew the metrics.
+func checkPacketTestReport(req *PacketTestReportRequest) error {
+	if !(req.LossPercent >= 0 && req.LossPercent <= 100) {
+		return fmt.Errorf("lossPercent %v out of range", req.LossPercent)
+	}
+	for _, f := range []struct {
+		name string
+		v    float64
+	}{
+		{"rttMinMs", req.RTTMin},
+		{"rttMedianMs", req.RTTMedian},
+		{"rttP90Ms", req.RTTP90},
+		{"jitterMs", req.JitterMs},
+	} {
+		if !(f.v >= 0 && f.v < maxPacketTestMs) {
+			return fmt.Errorf("%s %v out of range", f.name, f.v)
+		}
+	}
+	return nil
 }
 
 // handlePacketTestReport handles POST /api/packet-test/report.
@@ -400,12 +520,27 @@ func (s *Server) handlePacketTestReport(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
+	if err := checkPacketTestReport(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	trace.SpanFromContext(r.Context()).SetAttributes(tracing.TestID(req.TestID))
 
 	// Log the report
-	clientIP := meta.ClientIPFromRequest(r, s.cfg.TrustProxyHeaders)
-	log.Printf("Packet test report: testId=%s client=%s sent=%d received=%d loss=%.2f%% rtt=[%.2f/%.2f/%.2f]ms jitter=%.2fms",
-		req.TestID, clientIP, req.Sent, req.Received, req.LossPercent,
-		req.RTTMin, req.RTTMedian, req.RTTP90, req.JitterMs)
+	clientIP := meta.ClientIPFromRequest(r, s.config().TrustProxyHeaders)
+	logger.LogAttrs(r.Context(), slog.LevelInfo, "Packet test report",
+		slog.String("testId", req.TestID),
+		slog.String("client", clientIP),
+		slog.Int("sent", req.Sent),
+		slog.Int("received", req.Received),
+		slog.Float64("lossPercent", req.LossPercent),
+		slog.Float64("rttMinMs", req.RTTMin),
+		slog.Float64("rttMedianMs", req.RTTMedian),
+		slog.Float64("rttP90Ms", req.RTTP90),
+		slog.Float64("jitterMs", req.JitterMs),
+	)
+	metrics.ObservePacketTest(req.LossPercent, req.RTTMin, req.RTTMedian, req.RTTP90, req.JitterMs)
 
 	// Clean up the session if it exists
 	if s.webrtcManager != nil && req.TestID != "" {
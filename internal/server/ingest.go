@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/yellowman/netspeed/api"
+	"github.com/yellowman/netspeed/internal/results"
+)
+
+// maxIngestSkew is how far in the future an agent's clock may put a
+// result before it is refused.
+const maxIngestSkew = 5 * time.Minute
+
+// maxPushID bounds the ID an agent gives a push.
+const maxPushID = 64
+
+// IngestRequest is the request body for /api/results/ingest.
+type IngestRequest = api.IngestRequest
+
+// IngestResponse is the response for /api/results/ingest.
+type IngestResponse = api.IngestResponse
+
+// handleResultIngest handles POST /api/results/ingest - stores a result
+// pushed by a monitoring agent, together with the agent's ID and the
+// metadata of the connection it pushed from. Pushes are idempotent per
+// agent and push ID, so agents can retry freely.
+func (s *Server) handleResultIngest(w http.ResponseWriter, r *http.Request) {
+	if s.results == nil {
+		writeResultError(w, http.StatusNotFound, "results_disabled", "this server does not store results")
+		return
+	}
+	keys := s.config().AgentKeys
+	if len(keys) == 0 {
+		writeResultError(w, http.StatusNotFound, "ingest_disabled", "this server does not accept agent results")
+		return
+	}
+	agent, ok := agentFor(keys, tokenFromRequest(r))
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="netspeed"`)
+		writeResultError(w, http.StatusUnauthorized, "unauthorized", "a valid agent key is required")
+		return
+	}
+
+	var req IngestRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxResultSize+4096))
+	if err := dec.Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeResultError(w, http.StatusRequestEntityTooLarge, "too_large", "result document is too large")
+			return
+		}
+		writeResultError(w, http.StatusBadRequest, "invalid_result", "invalid JSON body")
+		return
+	}
+	if !validPushID(req.ID) {
+		writeResultError(w, http.StatusBadRequest, "invalid_result", "id must be 1-64 printable ASCII characters")
+		return
+	}
+	if req.Job != "" && !validPushID(req.Job) {
+		writeResultError(w, http.StatusBadRequest, "invalid_result", "job must be 1-64 printable ASCII characters")
+		return
+	}
+	if req.MeasuredAt.IsZero() {
+		writeResultError(w, http.StatusBadRequest, "invalid_result", "measuredAt is required")
+		return
+	}
+	if req.MeasuredAt.After(time.Now().Add(maxIngestSkew)) {
+		writeResultError(w, http.StatusBadRequest, "invalid_result", "measuredAt is in the future")
+		return
+	}
+	summary, err := results.ParseDocument(req.Result)
+	if err != nil {
+		writeResultError(w, http.StatusBadRequest, "invalid_result", err.Error())
+		return
+	}
+
+	var compact bytes.Buffer
+	json.Compact(&compact, req.Result)
+
+	colo := req.Colo
+	if colo == "" {
+		colo = s.config().Colo
+	}
+	res := &results.Result{
+		// Agents may push long after the test, so it is filed under the
+		// time it ran
+		CreatedAt: req.MeasuredAt.UTC(),
+		Colo:      colo,
+		Client:    s.metaFor(r),
+		Summary:   summary,
+		Agent:     agent,
+		Job:       req.Job,
+		Document:  compact.Bytes(),
+	}
+	added, err := s.results.AddPush(res, req.ID)
+	if err != nil {
+		logger.Error("Failed to store agent result", "agent", agent, "error", err)
+		writeResultError(w, http.StatusInternalServerError, "store_failed", "failed to store result")
+		return
+	}
+
+	s.fleet.Seen(agent, res.Client)
+	if req.Job != "" && s.fleet.Complete(agent, req.Job, res.ID) {
+		logger.Info("Job done", "job", req.Job, "agent", agent, "id", res.ID)
+	}
+
+	status := http.StatusCreated
+	if added {
+		logger.Info("Agent result stored", "id", res.ID, "agent", agent, "node", req.Node, "client", res.Client.ClientIP, "measuredAt", res.CreatedAt)
+	} else {
+		status = http.StatusOK
+		logger.Debug("Agent result already stored", "id", res.ID, "agent", agent, "pushId", req.ID)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Location", "/api/results/"+res.ID)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(IngestResponse{ID: res.ID, CreatedAt: res.CreatedAt, Agent: agent, Duplicate: !added})
+}
+
+// agentFor returns the ID of the agent whose key is key. Every key is
+// compared, so the time taken does not depend on which one matched.
+func agentFor(keys map[string]string, key string) (string, bool) {
+	var agent string
+	found := 0
+	for id, k := range keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(k)) == 1 {
+			agent = id
+			found = 1
+		}
+	}
+	return agent, found == 1 && key != ""
+}
+
+// validPushID reports whether id is usable as the ID of a push.
+func validPushID(id string) bool {
+	if id == "" || len(id) > maxPushID {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
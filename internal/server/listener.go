@@ -1,8 +1,17 @@
 package server
 
 import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
 	"net"
+	"net/http"
+	"os"
+	"syscall"
 	"time"
+
+	"github.com/yellowman/netspeed/internal/config"
 )
 
 // OptimizedListener wraps a net.Listener to configure TCP options
@@ -28,6 +37,11 @@ type ListenerConfig struct {
 	// This reduces latency for small writes at the cost of
 	// potentially more packets. Default: true for speed tests.
 	NoDelay bool
+
+	// Congestion sets TCP_CONGESTION (e.g. "cubic", "bbr") on the
+	// listening socket, which accepted connections inherit.
+	// Default: empty, the kernel default (net.ipv4.tcp_congestion_control).
+	Congestion string
 }
 
 // DefaultListenerConfig returns sensible defaults for speed testing.
@@ -40,12 +54,32 @@ func DefaultListenerConfig() ListenerConfig {
 }
 
 // NewOptimizedListener creates a listener with optimized TCP settings.
-func NewOptimizedListener(addr string, cfg ListenerConfig) (net.Listener, error) {
-	ln, err := net.Listen("tcp", addr)
+// network is tcp, tcp4, tcp6 or unix; the TCP options are skipped for
+// unix sockets.
+func NewOptimizedListener(network, addr string, cfg ListenerConfig) (net.Listener, error) {
+	if network == "unix" {
+		if err := removeStaleSocket(addr); err != nil {
+			return nil, err
+		}
+	}
+
+	ln, err := net.Listen(network, addr)
 	if err != nil {
 		return nil, err
 	}
 
+	if cfg.Congestion != "" {
+		sc, ok := ln.(syscall.Conn)
+		if !ok || network == "unix" {
+			ln.Close()
+			return nil, fmt.Errorf("congestion control %q needs a TCP listener", cfg.Congestion)
+		}
+		if err := setCongestion(sc, cfg.Congestion); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("failed to set congestion control %q: %w", cfg.Congestion, err)
+		}
+	}
+
 	return &OptimizedListener{
 		Listener:    ln,
 		sendBufSize: cfg.SendBufSize,
@@ -64,10 +98,10 @@ func (l *OptimizedListener) Accept() (net.Conn, error) {
 	// Apply TCP optimizations
 	if tcpConn, ok := conn.(*net.TCPConn); ok {
 		// Set TCP_NODELAY to disable Nagle's algorithm
-		// This is crucial for latency-sensitive speed tests
-		if l.noDelay {
-			tcpConn.SetNoDelay(true)
-		}
+		// This is crucial for latency-sensitive speed tests. Go enables
+		// it on accepted sockets already, so it is set either way for
+		// tcp_nodelay: false to turn it off.
+		tcpConn.SetNoDelay(l.noDelay)
 
 		// Increase send buffer for high-throughput downloads
 		if l.sendBufSize > 0 {
@@ -86,3 +120,131 @@ func (l *OptimizedListener) Accept() (net.Conn, error) {
 
 	return conn, nil
 }
+
+// removeStaleSocket deletes a unix socket left behind by a previous run that
+// did not shut down cleanly. Anything other than a socket is left alone so
+// a typo cannot remove a regular file.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+	return os.Remove(path)
+}
+
+// listenerServer serves one configured listener with its own http.Server,
+// so protocol pinning and TLS can differ between sockets.
+type listenerServer struct {
+	spec  config.Listener
+	lnCfg ListenerConfig
+	srv   *http.Server
+	ln    net.Listener // set by listen
+}
+
+// newListenerServer builds the http.Server for spec.
+func (s *Server) newListenerServer(spec config.Listener, handler http.Handler, cfg *config.Config) (*listenerServer, error) {
+	// Accept cleartext HTTP/2 alongside HTTP/1.1 on plain listeners
+	if !spec.TLS && spec.Protocol == "" && cfg.EnableH2C {
+		handler = h2cHandler(handler)
+	}
+
+	srv := &http.Server{
+		Addr:         spec.Addr,
+		Handler:      handler,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+		Protocols:    protocolsFor(spec.Protocol, spec.TLS),
+		HTTP2:        http2Config(),
+		ConnContext:  withConn,
+	}
+
+	if spec.TLS {
+		switch {
+		case s.acme != nil && spec.Protocol == "":
+			// Also answers TLS-ALPN-01 challenges
+			srv.TLSConfig = s.acme.TLSConfig()
+		default:
+			getCert, err := s.certificateSource()
+			if err != nil {
+				return nil, fmt.Errorf("listener %s: %w", spec, err)
+			}
+			// ServeTLS sets ALPN to match the pinned protocol
+			srv.TLSConfig = &tls.Config{GetCertificate: getCert}
+		}
+	}
+
+	return &listenerServer{spec: spec, lnCfg: listenerConfig(cfg, spec), srv: srv}, nil
+}
+
+// listenerConfig applies the TCP tuning from cfg, then the overrides of
+// spec, over the defaults.
+func listenerConfig(cfg *config.Config, spec config.Listener) ListenerConfig {
+	lnCfg := DefaultListenerConfig()
+	if cfg.TCPSendBufSize > 0 {
+		lnCfg.SendBufSize = int(cfg.TCPSendBufSize)
+	}
+	if cfg.TCPRecvBufSize > 0 {
+		lnCfg.RecvBufSize = int(cfg.TCPRecvBufSize)
+	}
+	lnCfg.NoDelay = cfg.TCPNoDelay
+	if spec.Network != "unix" {
+		lnCfg.Congestion = cfg.TCPCongestion
+	}
+
+	if spec.SendBufSize > 0 {
+		lnCfg.SendBufSize = int(spec.SendBufSize)
+	}
+	if spec.RecvBufSize > 0 {
+		lnCfg.RecvBufSize = int(spec.RecvBufSize)
+	}
+	if spec.Congestion != "" {
+		lnCfg.Congestion = spec.Congestion
+	}
+	return lnCfg
+}
+
+// listen binds the listener socket.
+func (ls *listenerServer) listen() error {
+	ln, err := NewOptimizedListener(ls.spec.Network, ls.spec.Addr, ls.lnCfg)
+	if err != nil {
+		return fmt.Errorf("failed to create listener %s: %w", ls.spec, err)
+	}
+	ls.ln = ln
+
+	proto := ls.spec.Protocol
+	if proto == "" {
+		proto = "auto"
+	}
+	if ls.spec.Network == "unix" {
+		logger.Info("Listening", "network", "unix", "addr", ln.Addr().String(), "tls", ls.spec.TLS, "proto", proto)
+	} else {
+		cc := ls.lnCfg.Congestion
+		if cc == "" {
+			cc = "default"
+		}
+		logger.Info("Listening",
+			"network", ls.spec.Network, "addr", ln.Addr().String(), "tls", ls.spec.TLS, "proto", proto,
+			"sendBuffer", ls.lnCfg.SendBufSize, "recvBuffer", ls.lnCfg.RecvBufSize,
+			"nodelay", ls.lnCfg.NoDelay, "cc", cc)
+	}
+	return nil
+}
+
+// serve accepts connections until the server is shut down.
+func (ls *listenerServer) serve() error {
+	if ls.spec.TLS {
+		return ls.srv.ServeTLS(ls.ln, "", "")
+	}
+	return ls.srv.Serve(ls.ln)
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/yellowman/netspeed/api"
+	"github.com/yellowman/netspeed/internal/locations"
+)
+
+// Result sizes of /api/locations/nearest and /api/locations/select.
+const (
+	defaultNearest    = 5
+	maxNearest        = 100
+	defaultCandidates = 3
+	maxCandidates     = 10
+)
+
+// selectPlan is the probe plan handed out with the candidates.
+var selectPlan = api.ProbePlan{Probes: 5, Discard: 1, IntervalMs: 100, TimeoutMs: 2000}
+
+// clientPosition returns the position to rank locations from: the lat and
+// lon parameters if given, otherwise the client's GeoIP position. ok is
+// false if neither is known.
+func (s *Server) clientPosition(r *http.Request) (lat, lon float64, ok bool, err error) {
+	q := r.URL.Query()
+	if q.Has("lat") || q.Has("lon") {
+		var err1, err2 error
+		lat, err1 = strconv.ParseFloat(q.Get("lat"), 64)
+		lon, err2 = strconv.ParseFloat(q.Get("lon"), 64)
+		if err1 != nil || err2 != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+			return 0, 0, false, errors.New("lat and lon must be given together, in degrees")
+		}
+		return lat, lon, true, nil
+	}
+	m := s.metaFor(r)
+	if m.Latitude == 0 && m.Longitude == 0 {
+		return 0, 0, false, nil
+	}
+	return m.Latitude, m.Longitude, true, nil
+}
+
+// matchingLocations returns the available locations that serve the test
+// parameter, if any, and carry every tag parameter, in a new slice: a
+// store may hand out its own.
+func (s *Server) matchingLocations(q url.Values) ([]api.Location, error) {
+	test := q.Get("test")
+	switch test {
+	case "", api.LocationTestDownload, api.LocationTestUpload, api.LocationTestPacketLoss, api.LocationTestHTTP3:
+	default:
+		return nil, fmt.Errorf("unknown test %q", test)
+	}
+	tags := q["tag"]
+	var locs []api.Location
+	for _, l := range s.locationStore().All() {
+		if !l.Available() || (test != "" && !l.Supports(test)) {
+			continue
+		}
+		if !slices.ContainsFunc(tags, func(tag string) bool { return !slices.Contains(l.Tags, tag) }) {
+			locs = append(locs, l)
+		}
+	}
+	return locs, nil
+}
+
+// queryLimit reads the integer parameter name, between 1 and max.
+func queryLimit(q url.Values, name string, def, max int) (int, error) {
+	v := q.Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > max {
+		return 0, fmt.Errorf("%s must be between 1 and %d", name, max)
+	}
+	return n, nil
+}
+
+// handleNearestLocations handles GET /api/locations/nearest - the
+// available locations, nearest to the client first.
+func (s *Server) handleNearestLocations(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit, err := queryLimit(q, "limit", defaultNearest, maxNearest)
+	if err != nil {
+		writeResultError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	locs, err := s.matchingLocations(q)
+	if err != nil {
+		writeResultError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	lat, lon, ok, err := s.clientPosition(r)
+	if err != nil {
+		writeResultError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	if !ok {
+		writeResultError(w, http.StatusUnprocessableEntity, "unknown_position", "the client's position is unknown; pass lat and lon")
+		return
+	}
+	ranked := locations.Nearest(locs, lat, lon)
+	writeAgentJSON(w, http.StatusOK, api.NearestResponse{
+		Latitude:  lat,
+		Longitude: lon,
+		Locations: ranked[:min(limit, len(ranked))],
+	})
+}
+
+// handleSelectLocation handles GET /api/locations/select - the nearest
+// nodes a client can test against, with the plan to choose between them
+// by latency, or with redirect=1 a redirect to the nearest.
+func (s *Server) handleSelectLocation(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	count, err := queryLimit(q, "count", defaultCandidates, maxCandidates)
+	if err != nil {
+		writeResultError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	family := q.Get("family")
+	if family != "" && family != "4" && family != "6" {
+		writeResultError(w, http.StatusBadRequest, "invalid_query", "family must be 4 or 6")
+		return
+	}
+	redirect := q.Get("redirect") == "1" || q.Get("redirect") == "true"
+	path := q.Get("path")
+	if path != "" && (!strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//")) {
+		writeResultError(w, http.StatusBadRequest, "invalid_query", "path must be an absolute path")
+		return
+	}
+	locs, err := s.matchingLocations(q)
+	if err != nil {
+		writeResultError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	lat, lon, ranked, err := s.clientPosition(r)
+	if err != nil {
+		writeResultError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+
+	var candidates []api.SelectCandidate
+	add := func(l *api.Location, distance *float64) {
+		if base := s.nodeURL(r, l, family); base != "" && len(candidates) < count {
+			candidates = append(candidates, api.SelectCandidate{
+				IATA:       l.IATA,
+				City:       l.City,
+				URL:        base,
+				ProbeURL:   base + "/api/probe",
+				DistanceKm: distance,
+			})
+		}
+	}
+	if ranked {
+		for _, n := range locations.Nearest(locs, lat, lon) {
+			add(&n.Location, &n.DistanceKm)
+		}
+	} else {
+		for i := range locs {
+			add(&locs[i], nil)
+		}
+	}
+	if len(candidates) == 0 {
+		writeResultError(w, http.StatusNotFound, "no_candidates", "no location matches and has a URL")
+		return
+	}
+
+	if redirect {
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, candidates[0].URL+path, http.StatusTemporaryRedirect)
+		return
+	}
+	writeAgentJSON(w, http.StatusOK, api.SelectResponse{
+		Candidates:  candidates,
+		Recommended: candidates[0].IATA,
+		Plan:        selectPlan,
+	})
+}
+
+// handleProbe handles GET /api/probe - an empty response to time the
+// round trip to this node with. Unlike a latency probe on /__down it needs
+// no test token and is not rate limited, so the probe plan of
+// /api/locations/select works on every node.
+func (s *Server) handleProbe(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// nodeURL returns the base URL of the node at l, using its host for family
+// if it has one, or "" if it has none. This node's own location defaults
+// to the URL the request came in on.
+func (s *Server) nodeURL(r *http.Request, l *api.Location, family string) string {
+	base := l.URL
+	if base == "" {
+		if !strings.EqualFold(l.IATA, s.config().Colo) {
+			return ""
+		}
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		base = scheme + "://" + r.Host
+	}
+	host := l.IPv4Host
+	if family == "6" {
+		host = l.IPv6Host
+	}
+	if family != "" && host != "" {
+		u, err := url.Parse(base)
+		if err != nil {
+			return ""
+		}
+		host = strings.Trim(host, "[]")
+		if port := u.Port(); port != "" {
+			u.Host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			u.Host = "[" + host + "]"
+		} else {
+			u.Host = host
+		}
+		base = u.String()
+	}
+	return strings.TrimSuffix(base, "/")
+}
@@ -0,0 +1,24 @@
+package server
+
+import (
+	"github.com/yellowman/netspeed/internal/config"
+	"github.com/yellowman/netspeed/internal/logging"
+)
+
+var (
+	// logger is the server subsystem's logger
+	logger = logging.For(logging.Server)
+	// probeSampler picks the latency probes that are logged
+	probeSampler = logging.Sampled(logging.LatencyProbe)
+)
+
+// ConfigureLogging applies the log settings of cfg. It is called at startup
+// and again by Reload, so levels and sampling can change on SIGHUP.
+func ConfigureLogging(cfg *config.Config) error {
+	return logging.Configure(logging.Config{
+		Format:   cfg.Log.Format,
+		Level:    cfg.Log.Level,
+		Levels:   cfg.Log.Levels,
+		Sampling: cfg.Log.Sampling,
+	})
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/yellowman/netspeed/api"
+	"github.com/yellowman/netspeed/internal/mesh"
+	"github.com/yellowman/netspeed/internal/results"
+)
+
+// Page sizes for /api/mesh/history.
+const (
+	defaultMeshHistory = 1000
+	maxMeshHistory     = 10000
+)
+
+// startMesh starts testing the peers of this node in the background;
+// release stops it.
+func (s *Server) startMesh() {
+	s.mesh = mesh.New(mesh.Options{
+		Config:    s.config,
+		Locations: func() []api.Location { return s.locationStore().All() },
+		History:   s.meshHistory,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	s.meshStop = cancel
+	s.meshDone = make(chan struct{})
+	go func() {
+		defer close(s.meshDone)
+		s.mesh.Run(ctx)
+	}()
+	cfg := s.config().Mesh
+	logger.Info("Mesh enabled", "interval", cfg.Interval, "historyDB", cfg.HistoryDB)
+}
+
+// meshEnabled writes the error response and returns false if the mesh is
+// not enabled.
+func (s *Server) meshEnabled(w http.ResponseWriter) bool {
+	if s.mesh == nil {
+		writeResultError(w, http.StatusNotFound, "mesh_disabled", "this server does not run mesh measurements")
+		return false
+	}
+	return true
+}
+
+// handleMesh handles GET /api/mesh - the latest measurement between every
+// pair of nodes.
+func (s *Server) handleMesh(w http.ResponseWriter, r *http.Request) {
+	if !s.meshEnabled(w) {
+		return
+	}
+	writeAgentJSON(w, http.StatusOK, s.mesh.Matrix())
+}
+
+// handleMeshNode handles GET /api/mesh/node - this node's own row of the
+// matrix, which its peers fetch.
+func (s *Server) handleMeshNode(w http.ResponseWriter, r *http.Request) {
+	if !s.meshEnabled(w) {
+		return
+	}
+	writeAgentJSON(w, http.StatusOK, s.mesh.Node())
+}
+
+// handleMeshHistory handles GET /api/mesh/history - this node's
+// measurements, oldest first, filtered by peer, from and to.
+func (s *Server) handleMeshHistory(w http.ResponseWriter, r *http.Request) {
+	if !s.meshEnabled(w) {
+		return
+	}
+	q := r.URL.Query()
+	f, err := results.ParseFilter(url.Values{"from": q["from"], "to": q["to"]})
+	if err != nil {
+		writeResultError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	limit := defaultMeshHistory
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxMeshHistory {
+			writeResultError(w, http.StatusBadRequest, "invalid_query", fmt.Sprintf("limit must be between 1 and %d", maxMeshHistory))
+			return
+		}
+	}
+	links := s.meshHistory.Query(q.Get("peer"), f.From, f.To, limit)
+	if links == nil {
+		links = []api.MeshLink{}
+	}
+	writeAgentJSON(w, http.StatusOK, api.MeshHistoryResponse{Links: links})
+}
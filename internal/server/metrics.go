@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/yellowman/netspeed/internal/config"
+	"github.com/yellowman/netspeed/internal/metrics"
+)
+
+// registerGauges exports the server state that is read on scrape rather
+// than counted as it happens. Close unregisters it again.
+func (s *Server) registerGauges() {
+	if s.webrtcManager != nil {
+		mgr := s.webrtcManager
+		s.unregisterGauges = append(s.unregisterGauges, metrics.RegisterGaugeFunc("webrtc", "active_sessions",
+			"Open WebRTC packet-test sessions.",
+			func() float64 { return float64(mgr.SessionCount()) }))
+	}
+	s.unregisterGauges = append(s.unregisterGauges,
+		metrics.RegisterGaugeFunc("", "active_tests",
+			"Admitted /__down and /__up transfers running now.",
+			func() float64 { return float64(s.admission.Load().Active) }),
+		metrics.RegisterGaugeFunc("", "queued_tests",
+			"Transfers waiting for admission.",
+			func() float64 { return float64(s.admission.Load().Queued) }))
+}
+
+// newMetricsServer serves /metrics on metrics_addr, or returns nil when
+// metrics are off or served on the main listeners.
+func (s *Server) newMetricsServer(cfg *config.Config) *http.Server {
+	if !cfg.Metrics || cfg.MetricsAddr == "" {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	return &http.Server{
+		Addr:              cfg.MetricsAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: cfg.ReadTimeout,
+	}
+}
+
+// handleMetrics handles GET /metrics - Prometheus metrics.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if !s.config().Metrics {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	metrics.Handler().ServeHTTP(w, r)
+}
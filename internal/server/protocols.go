@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+
+	"golang.org/x/net/http/httpguts"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// h2ReceiveWindow is the HTTP/2 flow control window per stream and per
+// connection. The net/http default (1MB/stream) throttles /__up on
+// high bandwidth-delay paths long before the link is saturated.
+const h2ReceiveWindow = 16 * 1024 * 1024 // 16 MB
+
+// Protocol pins for listeners (config.Listener.Protocol).
+const (
+	protoHTTP1 = "http1" // HTTP/1.1 only
+	protoHTTP2 = "http2" // HTTP/2 only (h2 via ALPN, or h2c prior knowledge)
+)
+
+// http2Config returns the HTTP/2 settings shared by all listeners.
+func http2Config() *http.HTTP2Config {
+	return &http.HTTP2Config{
+		MaxReceiveBufferPerConnection: h2ReceiveWindow,
+		MaxReceiveBufferPerStream:     h2ReceiveWindow,
+	}
+}
+
+// protocolsFor returns the protocol set for a listener pinned to proto.
+// A nil result leaves the net/http defaults in place.
+func protocolsFor(proto string, tlsEnabled bool) *http.Protocols {
+	var p http.Protocols
+	switch proto {
+	case protoHTTP1:
+		p.SetHTTP1(true)
+	case protoHTTP2:
+		if tlsEnabled {
+			p.SetHTTP2(true)
+		} else {
+			p.SetUnencryptedHTTP2(true)
+		}
+	default:
+		return nil
+	}
+	return &p
+}
+
+// h2cHandler wraps handler so a plain-text listener also accepts cleartext
+// HTTP/2, both with prior knowledge and via "Upgrade: h2c". It must be the
+// outermost handler because it hijacks the underlying connection.
+func h2cHandler(handler http.Handler) http.Handler {
+	// The request that carried "Upgrade: h2c" is answered on stream 1 of the
+	// new HTTP/2 connection but keeps its HTTP/1.1 Proto. Only upgraded
+	// requests reach this point with the upgrade headers still set, so fix
+	// up the version for /meta and /cdn-cgi/trace.
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 1 &&
+			httpguts.HeaderValuesContainsToken(r.Header["Upgrade"], "h2c") &&
+			httpguts.HeaderValuesContainsToken(r.Header["Connection"], "HTTP2-Settings") {
+			r = r.WithContext(r.Context())
+			r.Proto, r.ProtoMajor, r.ProtoMinor = "HTTP/2.0", 2, 0
+		}
+		handler.ServeHTTP(w, r)
+	})
+
+	return h2c.NewHandler(inner, &http2.Server{
+		MaxUploadBufferPerConnection: h2ReceiveWindow,
+		MaxUploadBufferPerStream:     h2ReceiveWindow,
+	})
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// QUICListenerConfig holds configuration for the HTTP/3 (QUIC) listener.
+// It plays the same role for UDP as ListenerConfig does for TCP.
+type QUICListenerConfig struct {
+	// UDPRecvBufSize is the UDP socket receive buffer size in bytes.
+	// Default: 8MB so bursts of incoming upload packets are not dropped.
+	UDPRecvBufSize int
+
+	// UDPSendBufSize is the UDP socket send buffer size in bytes.
+	// Default: 8MB for high-speed downloads.
+	UDPSendBufSize int
+
+	// MaxStreamReceiveWindow is the maximum per-stream flow control window.
+	// quic-go auto-tunes up to this value. Default: 16MB, large enough for
+	// a single /__up stream to fill a multi-gigabit path.
+	MaxStreamReceiveWindow uint64
+
+	// MaxConnectionReceiveWindow is the maximum connection-level flow
+	// control window. Default: 32MB.
+	MaxConnectionReceiveWindow uint64
+
+	// MaxIdleTimeout closes QUIC connections without network activity.
+	// Default: 60s.
+	MaxIdleTimeout time.Duration
+}
+
+// DefaultQUICListenerConfig returns sensible defaults for speed testing.
+func DefaultQUICListenerConfig() QUICListenerConfig {
+	return QUICListenerConfig{
+		UDPRecvBufSize:             8 * 1024 * 1024,  // 8 MB
+		UDPSendBufSize:             8 * 1024 * 1024,  // 8 MB
+		MaxStreamReceiveWindow:     16 * 1024 * 1024, // 16 MB
+		MaxConnectionReceiveWindow: 32 * 1024 * 1024, // 32 MB
+		MaxIdleTimeout:             60 * time.Second,
+	}
+}
+
+// quicConfig converts the listener configuration to a quic.Config.
+func (c QUICListenerConfig) quicConfig() *quic.Config {
+	return &quic.Config{
+		MaxStreamReceiveWindow:     c.MaxStreamReceiveWindow,
+		MaxConnectionReceiveWindow: c.MaxConnectionReceiveWindow,
+		MaxIdleTimeout:             c.MaxIdleTimeout,
+	}
+}
+
+// NewQUICPacketConn creates a UDP socket with buffers sized for QUIC.
+func NewQUICPacketConn(addr string, cfg QUICListenerConfig) (net.PacketConn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Buffer sizes are best-effort; the kernel may cap them
+	// (net.core.rmem_max / wmem_max)
+	if cfg.UDPRecvBufSize > 0 {
+		conn.SetReadBuffer(cfg.UDPRecvBufSize)
+	}
+	if cfg.UDPSendBufSize > 0 {
+		conn.SetWriteBuffer(cfg.UDPSendBufSize)
+	}
+
+	return conn, nil
+}
+
+// newHTTP3Server builds an HTTP/3 server for handler using the given
+// certificate source.
+func newHTTP3Server(handler http.Handler, getCert func(*tls.ClientHelloInfo) (*tls.Certificate, error), cfg QUICListenerConfig) *http3.Server {
+	return &http3.Server{
+		Handler:    handler,
+		TLSConfig:  http3.ConfigureTLSConfig(&tls.Config{GetCertificate: getCert}),
+		QUICConfig: cfg.quicConfig(),
+	}
+}
+
+// altSvcMiddleware advertises the HTTP/3 endpoint on TCP responses so
+// browsers can switch to QUIC for subsequent requests.
+func altSvcMiddleware(h3 *http3.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor < 3 {
+			h3.SetQUICHeaders(w.Header())
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+
+	"github.com/yellowman/netspeed/internal/config"
+	"github.com/yellowman/netspeed/internal/ratelimit"
+)
+
+// rateLimitConfig converts the rate_limit settings for the limiter.
+func rateLimitConfig(cfg *config.Config) (ratelimit.Config, error) {
+	exempt, err := cfg.RateLimit.ExemptPrefixes()
+	if err != nil {
+		return ratelimit.Config{}, err
+	}
+
+	rlCfg := ratelimit.Config{
+		Rules:      make(map[string]ratelimit.Rule),
+		IPv4Prefix: cfg.RateLimit.IPv4Prefix,
+		IPv6Prefix: cfg.RateLimit.IPv6Prefix,
+		Exempt:     exempt,
+	}
+	for endpoint, l := range cfg.RateLimit.Endpoints {
+		rule := ratelimit.Rule{
+			IP:     ratelimit.Limits(l.IP),
+			Prefix: ratelimit.Limits(l.Prefix),
+			ASN:    ratelimit.Limits(l.ASN),
+		}
+		if endpoint == config.RateLimitDefault {
+			rlCfg.DefaultRule = &rule
+			continue
+		}
+		rlCfg.Rules[endpoint] = rule
+	}
+	return rlCfg, nil
+}
+
+// rateLimitError is the JSON body of a 429 response.
+type rateLimitError struct {
+	Error      string `json:"error"`
+	Message    string `json:"message"`
+	Endpoint   string `json:"endpoint"`
+	Scope      string `json:"scope"`
+	Limit      string `json:"limit"`
+	Max        int64  `json:"max"`
+	RetryAfter int64  `json:"retryAfter"` // seconds
+}
+
+// rateLimited wraps a measurement endpoint with the per-client limits
+// configured for it. Bytes are reserved from the bytes parameter or the
+// Content-Length and settled against what was actually transferred.
+func (s *Server) rateLimited(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.config().RateLimit.Enabled || r.Method == http.MethodOptions {
+			next(w, r)
+			return
+		}
+
+		clientMeta := s.metaFor(r)
+		ip, _ := netip.ParseAddr(clientMeta.ClientIP)
+		client := ratelimit.Client{IP: ip, ASN: clientMeta.ASN}
+
+		res, denial := s.limiter.Allow(endpoint, client, expectedBytes(r))
+		if denial != nil {
+			logger.Info("Rate limited",
+				"client", clientMeta.ClientIP, "asn", clientMeta.ASN, "endpoint", denial.Endpoint,
+				"scope", denial.Scope, "key", denial.Key, "limit", denial.Limit, "max", denial.Max)
+			writeRateLimited(w, denial)
+			return
+		}
+		if res == nil {
+			next(w, r)
+			return
+		}
+
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		cw := &countingWriter{ResponseWriter: w}
+		next(cw, r)
+		res.Done(body.n + cw.n)
+	}
+}
+
+// expectedBytes is how much data a request announces it will move, or -1
+// for a request body of unknown length. A sum too large for an int64 is
+// capped, as it is over any quota anyway.
+func expectedBytes(r *http.Request) int64 {
+	if r.ContentLength < 0 {
+		return -1
+	}
+	n := r.ContentLength
+	if v, err := strconv.ParseInt(r.URL.Query().Get("bytes"), 10, 64); err == nil && v > 0 {
+		n = min(n, math.MaxInt64-v) + v
+	}
+	return n
+}
+
+// writeRateLimited sends a 429 with Retry-After and a JSON description.
+func writeRateLimited(w http.ResponseWriter, d *ratelimit.Denial) {
+	retryAfter := int64(math.Ceil(d.RetryAfter.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(rateLimitError{
+		Error:      "rate_limited",
+		Message:    d.Error(),
+		Endpoint:   d.Endpoint,
+		Scope:      d.Scope,
+		Limit:      d.Limit,
+		Max:        d.Max,
+		RetryAfter: retryAfter,
+	})
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/yellowman/netspeed/internal/config"
+	"github.com/yellowman/netspeed/internal/locations"
+)
+
+// geoipCloseDelay is how long a replaced GeoIP database stays open so that
+// lookups already in flight against it can finish.
+const geoipCloseDelay = time.Minute
+
+// Reload applies a new configuration to the running server without
+// interrupting active transfers or WebRTC sessions. It re-reads the locations
+// file, GeoIP database and TLS certificate; any of them that fails to load is
+// rejected and the previous one stays active. Settings that are bound at
+// startup (listen address, timeouts, web dir, embedded TURN) are kept as-is.
+// The returned error joins every component that failed to reload.
+func (s *Server) Reload(newCfg *config.Config) error {
+	old := s.config()
+	cfg := *newCfg
+	keepStartupSettings(old, &cfg)
+
+	var errs []error
+
+	// TLS certificate
+	if s.certs != nil {
+		desc, err := s.certs.Reload(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			logger.Warn("Reload: keeping current TLS certificate", "error", err)
+			errs = append(errs, err)
+			cfg.TLSCertFile, cfg.TLSKeyFile = old.TLSCertFile, old.TLSKeyFile
+		} else {
+			logger.Info("Reload: TLS certificate loaded", "file", cfg.TLSCertFile, "certificate", desc)
+		}
+	}
+
+	// Meta provider / GeoIP database
+	s.mu.RLock()
+	metaProvider, geoipProvider := s.metaProvider, s.geoipProvider
+	s.mu.RUnlock()
+	oldGeoIP := geoipProvider
+
+	if mp, gp, err := newMetaProvider(&cfg); err != nil {
+		logger.Warn("Reload: keeping current meta provider: failed to load GeoIP database", "error", err)
+		errs = append(errs, fmt.Errorf("failed to load GeoIP database: %w", err))
+		cfg.GeoIPDatabasePath = old.GeoIPDatabasePath
+	} else {
+		metaProvider, geoipProvider = mp, gp
+		if gp != nil {
+			logger.Info("Reload: GeoIP ASN database loaded", "file", cfg.GeoIPDatabasePath)
+		}
+	}
+
+	// Location store
+	locationStore, err := s.reloadLocations(old, &cfg)
+	if err != nil {
+		logger.Warn("Reload: keeping current locations", "error", err)
+		errs = append(errs, err)
+		cfg.LocationsFile = old.LocationsFile
+	}
+
+	// Rate limits; usage counted so far is kept
+	if rlCfg, err := rateLimitConfig(&cfg); err != nil {
+		logger.Warn("Reload: keeping current rate limits", "error", err)
+		errs = append(errs, fmt.Errorf("invalid rate limit config: %w", err))
+		cfg.RateLimit = old.RateLimit
+	} else {
+		s.limiter.SetConfig(rlCfg)
+	}
+
+	// Test token signer; budgets spent so far are kept, and a generated
+	// key is only replaced when the token settings change
+	if tokenSignerChanged(&old.TestTokens, &cfg.TestTokens) {
+		if signer, err := newTokenSigner(&cfg.TestTokens); err != nil {
+			logger.Warn("Reload: keeping current test token key", "error", err)
+			errs = append(errs, fmt.Errorf("invalid test token config: %w", err))
+			cfg.TestTokens = old.TestTokens
+		} else {
+			s.tokens.SetSigner(signer)
+		}
+	}
+
+	// Mesh peers and budgets, used from the next round on
+	if cfg.Mesh.Enabled {
+		if err := cfg.Mesh.Validate(); err != nil {
+			logger.Warn("Reload: keeping current mesh settings", "error", err)
+			errs = append(errs, fmt.Errorf("invalid mesh config: %w", err))
+			cfg.Mesh = old.Mesh
+		}
+	}
+
+	// Admission limits; running transfers keep their slots
+	s.admission.SetLimits(admissionLimits(&cfg))
+
+	// Log format, levels and sampling
+	if err := ConfigureLogging(&cfg); err != nil {
+		logger.Warn("Reload: keeping current log settings", "error", err)
+		errs = append(errs, err)
+		cfg.Log = old.Log
+	}
+
+	for _, change := range config.Diff(old, &cfg) {
+		logger.Info("Reload: setting changed", "change", change)
+	}
+
+	s.mu.Lock()
+	s.cfg = &cfg
+	s.metaProvider = metaProvider
+	s.geoipProvider = geoipProvider
+	s.locations = locationStore
+	s.mu.Unlock()
+
+	if s.webrtcManager != nil {
+		s.webrtcManager.SetICEServers(iceServersFor(&cfg))
+	}
+
+	// Close the replaced GeoIP database once in-flight lookups are done
+	if oldGeoIP != nil && oldGeoIP != geoipProvider {
+		time.AfterFunc(geoipCloseDelay, func() {
+			oldGeoIP.Close()
+		})
+	}
+
+	return errors.Join(errs...)
+}
+
+// reloadLocations re-reads the current FileStore in place when the path is
+// unchanged, or builds a new store when it changed. On error the current
+// store is returned along with the error.
+func (s *Server) reloadLocations(old, cfg *config.Config) (locations.Store, error) {
+	current := s.locationStore()
+
+	if fs, ok := current.(*locations.FileStore); ok && cfg.LocationsFile == old.LocationsFile {
+		before, after, err := fs.Reload()
+		if err != nil {
+			return current, err
+		}
+		logger.Info("Reload: locations reloaded", "file", fs.Path(), "before", before, "after", after)
+		return fs, nil
+	}
+
+	store, err := newLocationStore(cfg)
+	if err != nil {
+		return current, err
+	}
+	return store, nil
+}
+
+// keepStartupSettings resets settings that only take effect at startup to
+// their current values, logging any attempted change.
+func keepStartupSettings(old, cfg *config.Config) {
+	keep("listen_addr", &cfg.ListenAddr, old.ListenAddr)
+	keep("read_timeout", &cfg.ReadTimeout, old.ReadTimeout)
+	keep("write_timeout", &cfg.WriteTimeout, old.WriteTimeout)
+	keep("idle_timeout", &cfg.IdleTimeout, old.IdleTimeout)
+	keep("web_dir", &cfg.WebDir, old.WebDir)
+	keep("results_db", &cfg.ResultsDB, old.ResultsDB)
+	keep("mesh.enabled", &cfg.Mesh.Enabled, old.Mesh.Enabled)
+	keep("mesh.history_db", &cfg.Mesh.HistoryDB, old.Mesh.HistoryDB)
+	keep("embedded_turn", &cfg.EmbeddedTurn, old.EmbeddedTurn)
+	keep("embedded_turn_addr", &cfg.EmbeddedTurnAddr, old.EmbeddedTurnAddr)
+	keep("embedded_turn_public_ip", &cfg.EmbeddedTurnPublicIP, old.EmbeddedTurnPublicIP)
+	cfg.EmbeddedTurnPort = old.EmbeddedTurnPort
+	keep("enable_h2c", &cfg.EnableH2C, old.EnableH2C)
+	if !reflect.DeepEqual(cfg.Listeners, old.Listeners) {
+		logger.Warn("Reload: changing listeners requires a restart, ignoring")
+		cfg.Listeners = old.Listeners
+	}
+	keep("tcp_send_buffer", &cfg.TCPSendBufSize, old.TCPSendBufSize)
+	keep("tcp_recv_buffer", &cfg.TCPRecvBufSize, old.TCPRecvBufSize)
+	keep("tcp_nodelay", &cfg.TCPNoDelay, old.TCPNoDelay)
+	keep("tcp_congestion", &cfg.TCPCongestion, old.TCPCongestion)
+	keep("http1_addr", &cfg.HTTP1Addr, old.HTTP1Addr)
+	keep("http2_addr", &cfg.HTTP2Addr, old.HTTP2Addr)
+	keep("http3", &cfg.HTTP3, old.HTTP3)
+	keep("http3_addr", &cfg.HTTP3Addr, old.HTTP3Addr)
+	keep("quic_udp_buffer", &cfg.QUICUDPBufSize, old.QUICUDPBufSize)
+	keep("quic_stream_window", &cfg.QUICStreamWindow, old.QUICStreamWindow)
+	keep("quic_conn_window", &cfg.QUICConnWindow, old.QUICConnWindow)
+	keep("acme", &cfg.ACME, old.ACME)
+	keep("acme_directory_url", &cfg.ACMEDirectoryURL, old.ACMEDirectoryURL)
+	keep("acme_email", &cfg.ACMEEmail, old.ACMEEmail)
+	keep("acme_cache_dir", &cfg.ACMECacheDir, old.ACMECacheDir)
+	keep("acme_http_addr", &cfg.ACMEHTTPAddr, old.ACMEHTTPAddr)
+	keep("acme_ca_file", &cfg.ACMECAFile, old.ACMECAFile)
+	keep("metrics_addr", &cfg.MetricsAddr, old.MetricsAddr)
+	keep("tracing_endpoint", &cfg.TracingEndpoint, old.TracingEndpoint)
+	keep("tracing_sample_ratio", &cfg.TracingSampleRatio, old.TracingSampleRatio)
+
+	// Switching between HTTP and HTTPS needs a new listener
+	if cfg.TLSEnabled() != old.TLSEnabled() {
+		logger.Warn("Reload: enabling or disabling TLS requires a restart, ignoring")
+		cfg.TLSCertFile, cfg.TLSKeyFile = old.TLSCertFile, old.TLSKeyFile
+	}
+}
+
+// keep restores *dst to old if it differs, logging that the change was ignored.
+func keep[T comparable](name string, dst *T, old T) {
+	if *dst != old {
+		logger.Warn("Reload: change requires a restart, ignoring", "setting", name)
+		*dst = old
+	}
+}
@@ -0,0 +1,328 @@
+package server
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yellowman/netspeed/internal/meta"
+	"github.com/yellowman/netspeed/internal/results"
+)
+
+// maxResultSize caps a submitted result document. A full browser test with
+// every sample is well under 100KB.
+const maxResultSize = 1 << 20
+
+// Page sizes of GET /api/results.
+const (
+	defaultResultPage = 100
+	maxResultPage     = 1000
+)
+
+// resultError is the JSON body of a failed /api/results request.
+type resultError struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// ResultCreatedResponse is the response for POST /api/results.
+type ResultCreatedResponse struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// sharedClient is the ClientMeta of a stored result as shown to anyone with
+// the link: everything but the client IP.
+type sharedClient struct {
+	meta.ClientMeta
+	ClientIP string `json:"clientIp,omitempty"`
+}
+
+// ResultResponse is the response for GET /api/results/{id}.
+type ResultResponse struct {
+	ID        string          `json:"id"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Colo      string          `json:"colo"`
+	Client    sharedClient    `json:"client"`
+	Summary   results.Summary `json:"summary"`
+	Agent     string          `json:"agent,omitempty"`
+	Job       string          `json:"job,omitempty"`
+	Result    json.RawMessage `json:"result"`
+}
+
+// ResultListItem is a result in the response for GET /api/results: the
+// same as ResultResponse without the submitted document.
+type ResultListItem struct {
+	ID        string          `json:"id"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Colo      string          `json:"colo"`
+	Client    sharedClient    `json:"client"`
+	Summary   results.Summary `json:"summary"`
+	Agent     string          `json:"agent,omitempty"`
+	Job       string          `json:"job,omitempty"`
+}
+
+// ResultListResponse is the response for GET /api/results.
+type ResultListResponse struct {
+	Results []ResultListItem `json:"results"`
+	// NextCursor is passed as cursor to fetch the next page; it is empty
+	// on the last one
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// ResultAggregateResponse is the response for GET /api/results/aggregate.
+type ResultAggregateResponse struct {
+	Group   string           `json:"group"`
+	Buckets []results.Bucket `json:"buckets"`
+}
+
+// writeResultError sends a JSON error for /api/results.
+func writeResultError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resultError{Error: code, Message: message})
+}
+
+// handleResults handles POST /api/results - stores a completed test result
+// together with the client's metadata and returns its share ID.
+func (s *Server) handleResults(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.results == nil {
+		writeResultError(w, http.StatusNotFound, "results_disabled", "this server does not store results")
+		return
+	}
+
+	doc, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxResultSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeResultError(w, http.StatusRequestEntityTooLarge, "too_large", "result document is too large")
+			return
+		}
+		writeResultError(w, http.StatusBadRequest, "invalid_result", "failed to read request body")
+		return
+	}
+	summary, err := results.ParseDocument(doc)
+	if err != nil {
+		writeResultError(w, http.StatusBadRequest, "invalid_result", err.Error())
+		return
+	}
+
+	var compact bytes.Buffer
+	json.Compact(&compact, doc)
+
+	res := &results.Result{
+		CreatedAt: time.Now().UTC(),
+		Colo:      s.config().Colo,
+		Client:    s.metaFor(r),
+		Summary:   summary,
+		Document:  compact.Bytes(),
+	}
+	if err := s.results.Add(res); err != nil {
+		logger.Error("Failed to store result", "error", err)
+		writeResultError(w, http.StatusInternalServerError, "store_failed", "failed to store result")
+		return
+	}
+
+	logger.Info("Result stored", "id", res.ID, "client", res.Client.ClientIP, "bytes", len(doc))
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Location", "/api/results/"+res.ID)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ResultCreatedResponse{ID: res.ID, CreatedAt: res.CreatedAt})
+}
+
+// handleResult handles GET /api/results/{id} - a stored result. The client
+// IP is left out since anyone with the link can see it.
+func (s *Server) handleResult(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.results == nil {
+		writeResultError(w, http.StatusNotFound, "results_disabled", "this server does not store results")
+		return
+	}
+
+	id := r.PathValue("id")
+	if !results.ValidID(id) {
+		writeResultError(w, http.StatusNotFound, "not_found", "no result with this ID")
+		return
+	}
+	res, err := s.results.Get(id)
+	if errors.Is(err, results.ErrNotFound) {
+		writeResultError(w, http.StatusNotFound, "not_found", "no result with this ID")
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to load result", "id", id, "error", err)
+		writeResultError(w, http.StatusInternalServerError, "store_failed", "failed to load result")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	// Results never change once stored
+	w.Header().Set("Cache-Control", "public, max-age=86400, immutable")
+	json.NewEncoder(w).Encode(ResultResponse{
+		ID:        res.ID,
+		CreatedAt: res.CreatedAt,
+		Colo:      res.Colo,
+		Client:    sharedClient{ClientMeta: res.Client},
+		Summary:   res.Summary,
+		Agent:     res.Agent,
+		Job:       res.Job,
+		Result:    res.Document,
+	})
+}
+
+// resultsQueryAllowed checks that the result store is open, that queries
+// are enabled and that the request carries the results API token. It
+// writes the error response and returns false otherwise.
+func (s *Server) resultsQueryAllowed(w http.ResponseWriter, r *http.Request) bool {
+	if s.results == nil {
+		writeResultError(w, http.StatusNotFound, "results_disabled", "this server does not store results")
+		return false
+	}
+	token := s.config().ResultsAPIToken
+	if token == "" {
+		writeResultError(w, http.StatusNotFound, "query_disabled", "result queries are not enabled on this server")
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(tokenFromRequest(r)), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="netspeed"`)
+		writeResultError(w, http.StatusUnauthorized, "unauthorized", "a valid results API token is required")
+		return false
+	}
+	return true
+}
+
+// handleResultQuery handles GET /api/results - lists stored results
+// matching the filter parameters, newest first, one page at a time.
+func (s *Server) handleResultQuery(w http.ResponseWriter, r *http.Request) {
+	if !s.resultsQueryAllowed(w, r) {
+		return
+	}
+	q := r.URL.Query()
+	f, err := results.ParseFilter(q)
+	if err != nil {
+		writeResultError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	limit := defaultResultPage
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxResultPage {
+			writeResultError(w, http.StatusBadRequest, "invalid_query", fmt.Sprintf("limit must be between 1 and %d", maxResultPage))
+			return
+		}
+	}
+
+	page, next, err := s.results.Query(f, q.Get("cursor"), limit)
+	if errors.Is(err, results.ErrInvalidCursor) {
+		writeResultError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to query results", "error", err)
+		writeResultError(w, http.StatusInternalServerError, "store_failed", "failed to query results")
+		return
+	}
+
+	resp := ResultListResponse{Results: make([]ResultListItem, len(page)), NextCursor: next}
+	for i, res := range page {
+		resp.Results[i] = ResultListItem{
+			ID:        res.ID,
+			CreatedAt: res.CreatedAt,
+			Colo:      res.Colo,
+			Client:    sharedClient{ClientMeta: res.Client},
+			Summary:   res.Summary,
+			Agent:     res.Agent,
+			Job:       res.Job,
+		}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleResultAggregate handles GET /api/results/aggregate - percentiles of
+// the results matching the filter parameters, grouped by the group
+// parameter.
+func (s *Server) handleResultAggregate(w http.ResponseWriter, r *http.Request) {
+	if !s.resultsQueryAllowed(w, r) {
+		return
+	}
+	q := r.URL.Query()
+	f, err := results.ParseFilter(q)
+	if err != nil {
+		writeResultError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	group := q.Get("group")
+	if group == "" {
+		group = results.GroupDay
+	}
+	if !slices.Contains(results.Groups, group) {
+		writeResultError(w, http.StatusBadRequest, "invalid_query",
+			fmt.Sprintf("group must be one of %s", strings.Join(results.Groups, ", ")))
+		return
+	}
+
+	buckets, err := s.results.Aggregate(f, group)
+	if err != nil {
+		logger.Error("Failed to aggregate results", "group", group, "error", err)
+		writeResultError(w, http.StatusInternalServerError, "store_failed", "failed to aggregate results")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(ResultAggregateResponse{Group: group, Buckets: buckets})
+}
+
+// handleResultExport handles GET /api/results/export - streams the results
+// matching the filter parameters as CSV, NDJSON or Parquet (the format
+// parameter, CSV by default).
+func (s *Server) handleResultExport(w http.ResponseWriter, r *http.Request) {
+	if !s.resultsQueryAllowed(w, r) {
+		return
+	}
+	q := r.URL.Query()
+	f, err := results.ParseFilter(q)
+	if err != nil {
+		writeResultError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	format := q.Get("format")
+	if format == "" {
+		format = results.FormatCSV
+	}
+	if !results.ValidFormat(format) {
+		writeResultError(w, http.StatusBadRequest, "invalid_query",
+			fmt.Sprintf("format must be one of %s", strings.Join(results.Formats, ", ")))
+		return
+	}
+
+	w.Header().Set("Content-Type", results.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="results-%s.%s"`, time.Now().UTC().Format("20060102"), format))
+	w.Header().Set("Cache-Control", "no-store")
+	// A large export can take longer than write_timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	start := time.Now()
+	n, err := s.results.Export(w, f, format)
+	if err != nil {
+		// Headers are gone by now; the client sees a truncated body
+		logger.Error("Result export failed", "format", format, "rows", n, "error", err)
+		return
+	}
+	logger.Info("Results exported", "format", format, "rows", n, "duration", time.Since(start))
+}
@@ -4,89 +4,170 @@ package server
 import (
 	"context"
 	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io/fs"
+	"log/slog"
+	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	pionwebrtc "github.com/pion/webrtc/v3"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/yellowman/netspeed/internal/admission"
 	"github.com/yellowman/netspeed/internal/config"
+	"github.com/yellowman/netspeed/internal/fleet"
 	"github.com/yellowman/netspeed/internal/locations"
+	"github.com/yellowman/netspeed/internal/mesh"
 	"github.com/yellowman/netspeed/internal/meta"
+	"github.com/yellowman/netspeed/internal/metrics"
+	"github.com/yellowman/netspeed/internal/ratelimit"
+	"github.com/yellowman/netspeed/internal/results"
+	"github.com/yellowman/netspeed/internal/token"
 	"github.com/yellowman/netspeed/internal/webrtc"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 // Server is the main netspeedd HTTP server.
 type Server struct {
-	cfg            *config.Config
-	httpServer     *http.Server
-	metaProvider   meta.Provider
-	geoipProvider  *meta.GeoIPProvider // track for cleanup
-	locations      locations.Store
-	payloadBuf     []byte
-	webrtcManager  *webrtc.Manager
+	listeners     []*listenerServer
+	payloadBuf    []byte
+	webrtcManager *webrtc.Manager
+	certs         *certStore        // nil unless static TLS files are configured
+	acme          *autocert.Manager // nil unless ACME is enabled
+	acmeServer    *http.Server      // serves HTTP-01 challenges, nil if disabled
+	metricsServer *http.Server      // serves /metrics on metrics_addr, nil if unused
+	h3            *http3.Server     // nil unless HTTP/3 is enabled
+	quicCfg       QUICListenerConfig
+	limiter       *ratelimit.Limiter // checks cfg.RateLimit.Enabled per request
+	admission     *admission.Controller
+	tokens        *token.Authority // checks cfg.TestTokens.Enabled per request
+	results       *results.Store   // nil unless results_db is set
+	fleet         *fleet.Registry  // monitoring agents and their jobs
+	mesh          *mesh.Runner     // nil unless the mesh is enabled
+	meshHistory   *mesh.History
+	meshStop      context.CancelFunc
+	meshDone      chan struct{} // closed once the mesh has stopped
+	handler       http.Handler  // routes and middleware, without listener-specific wrapping
+
+	releaseOnce sync.Once
+	closeOnce   sync.Once
+	closeErr    error
+	// unregisterGauges removes this server's gauges; Close calls them
+	unregisterGauges []func()
+
+	// mu guards the fields below, which are swapped by Reload.
+	mu            sync.RWMutex
+	cfg           *config.Config
+	metaProvider  meta.Provider
+	geoipProvider *meta.GeoIPProvider // track for cleanup
+	locations     locations.Store
+}
+
+// Options replaces parts of what the server would otherwise build from
+// its configuration. The zero value builds everything from the config.
+type Options struct {
+	// MetaProvider answers /meta in place of the GeoIP database or the
+	// static values from the config. The server does not reload or close
+	// it.
+	MetaProvider meta.Provider
+	// Locations is served on /locations in place of the locations file
+	Locations locations.Store
+	// DisableWebRTC turns the packet-loss test off; offers get a 503
+	DisableWebRTC bool
 }
 
 // New creates a new Server with the given configuration.
 func New(cfg *config.Config) (*Server, error) {
+	s, err := newServer(cfg, Options{})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.setupListeners(cfg); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewEmbedded creates a Server that does not listen on its own, for
+// mounting inside another Go service: serve Handler from that service's
+// http.Server and call Close once it has shut down. Listener, TLS, ACME,
+// HTTP/3 and metrics_addr settings are ignored, and the server is not
+// reloaded.
+func NewEmbedded(cfg *config.Config, opts Options) (*Server, error) {
+	return newServer(cfg, opts)
+}
+
+// newServer builds everything but the listeners: the providers, limits,
+// stores and WebRTC manager, and the handler with its middleware.
+func newServer(cfg *config.Config, opts Options) (*Server, error) {
 	// Build meta provider based on configuration
-	var metaProvider meta.Provider
+	metaProvider := opts.MetaProvider
 	var geoipProvider *meta.GeoIPProvider
-
-	// Try to use GeoIP database if configured
-	if cfg.GeoIPDatabasePath != "" {
-		gp, err := meta.NewGeoIPProvider(
-			cfg.GeoIPDatabasePath,
-			cfg.Hostname,
-			cfg.Colo,
-			cfg.TrustProxyHeaders,
-		)
+	if metaProvider == nil {
+		var err error
+		metaProvider, geoipProvider, err = newMetaProvider(cfg)
 		if err != nil {
-			log.Printf("Warning: failed to load GeoIP database: %v (falling back to static provider)", err)
-		} else {
-			metaProvider = gp
-			geoipProvider = gp
-			log.Printf("GeoIP ASN database loaded from %s", cfg.GeoIPDatabasePath)
+			logger.Warn("Failed to load GeoIP database, falling back to static provider", "error", err)
+			metaProvider = newStaticProvider(cfg)
+		} else if geoipProvider != nil {
+			logger.Info("GeoIP ASN database loaded", "file", cfg.GeoIPDatabasePath)
 		}
 	}
-
-	// Fall back to static provider if GeoIP not available
-	if metaProvider == nil {
-		metaProvider = &meta.StaticProvider{
-			Hostname:   cfg.Hostname,
-			Colo:       cfg.Colo,
-			TrustProxy: cfg.TrustProxyHeaders,
-			// Default values
-			Country:    "US",
-			City:       "Unknown",
-			Region:     "Unknown",
-			PostalCode: "",
-			Latitude:   0,
-			Longitude:  0,
-			Timezone:   "UTC",
-			ASN:        0,
-			ASOrg:      "Unknown",
+	fail := func(err error) (*Server, error) {
+		if geoipProvider != nil {
+			geoipProvider.Close()
 		}
+		return nil, err
 	}
 
 	// Build location store
-	var locationStore locations.Store
-	locationsFile := cfg.LocationsFile
-	if locationsFile == "" {
-		// Try default locations.json in current directory
-		locationsFile = "locations.json"
+	locationStore := opts.Locations
+	if locationStore == nil {
+		var err error
+		if locationStore, err = newLocationStore(cfg); err != nil {
+			return fail(err)
+		}
 	}
-	if store, err := locations.NewFileStore(locationsFile); err == nil {
-		locationStore = store
-		log.Printf("Loaded locations from %s", locationsFile)
-	} else if cfg.LocationsFile != "" {
-		// User explicitly specified a file that failed to load
-		return nil, fmt.Errorf("failed to load locations: %w", err)
-	} else {
-		// Fall back to built-in defaults
-		locationStore = locations.NewMemoryStore(locations.DefaultLocations())
-		log.Printf("Using built-in default locations")
+
+	rlCfg, err := rateLimitConfig(cfg)
+	if err != nil {
+		return fail(fmt.Errorf("invalid rate limit config: %w", err))
+	}
+
+	tokenSigner, err := newTokenSigner(&cfg.TestTokens)
+	if err != nil {
+		return fail(fmt.Errorf("invalid test token config: %w", err))
+	}
+
+	var resultStore *results.Store
+	if cfg.ResultsDB != "" {
+		resultStore, err = results.Open(cfg.ResultsDB)
+		if err != nil {
+			return fail(err)
+		}
+		logger.Info("Storing shared results", "file", cfg.ResultsDB)
+	}
+
+	var meshHistory *mesh.History
+	if cfg.Mesh.Enabled {
+		if err := cfg.Mesh.Validate(); err != nil {
+			if resultStore != nil {
+				resultStore.Close()
+			}
+			return fail(fmt.Errorf("invalid mesh config: %w", err))
+		}
+		if meshHistory, err = mesh.OpenHistory(cfg.Mesh.HistoryDB); err != nil {
+			if resultStore != nil {
+				resultStore.Close()
+			}
+			return fail(err)
+		}
 	}
 
 	// Allocate payload buffer (1 MiB of random data)
@@ -94,25 +175,16 @@ func New(cfg *config.Config) (*Server, error) {
 	payloadBuf := make([]byte, bufSize)
 	if _, err := rand.Read(payloadBuf); err != nil {
 		// Fallback to zeros if random fails
-		log.Printf("Warning: failed to fill payload buffer with random data: %v", err)
+		logger.Warn("Failed to fill payload buffer with random data", "error", err)
 	}
 
 	// Build WebRTC manager
-	webrtcCfg := webrtc.DefaultConfig()
-	if len(cfg.TurnServers) > 0 && cfg.TurnSecret != "" {
-		// ICE servers will be set dynamically per-request with fresh credentials
-		// For now, just set up STUN servers if available
-		var iceServers []pionwebrtc.ICEServer
-		for _, server := range cfg.TurnServers {
-			if strings.HasPrefix(server, "stun:") {
-				iceServers = append(iceServers, pionwebrtc.ICEServer{
-					URLs: []string{server},
-				})
-			}
-		}
-		webrtcCfg.ICEServers = iceServers
+	var webrtcMgr *webrtc.Manager
+	if !opts.DisableWebRTC {
+		webrtcCfg := webrtc.DefaultConfig()
+		webrtcCfg.ICEServers = iceServersFor(cfg)
+		webrtcMgr = webrtc.NewManager(webrtcCfg)
 	}
-	webrtcMgr := webrtc.NewManager(webrtcCfg)
 
 	s := &Server{
 		cfg:           cfg,
@@ -121,17 +193,25 @@ func New(cfg *config.Config) (*Server, error) {
 		locations:     locationStore,
 		payloadBuf:    payloadBuf,
 		webrtcManager: webrtcMgr,
+		limiter:       ratelimit.New(rlCfg),
+		admission:     admission.New(admissionLimits(cfg)),
+		tokens:        token.New(tokenSigner),
+		results:       resultStore,
+		fleet:         fleet.New(),
+		meshHistory:   meshHistory,
+	}
+	s.registerGauges()
+	if meshHistory != nil {
+		s.startMesh()
 	}
 
 	// Set up HTTP mux and routes
 	mux := http.NewServeMux()
 	s.registerRoutes(mux)
 
-	// Wrap with CORS middleware if enabled
+	// Wrap with CORS middleware (a no-op while CORS is disabled)
 	var handler http.Handler = mux
-	if cfg.EnableCORS {
-		handler = s.corsMiddleware(handler)
-	}
+	handler = s.corsMiddleware(handler)
 
 	// Wrap with logging middleware
 	handler = s.loggingMiddleware(handler)
@@ -139,38 +219,257 @@ func New(cfg *config.Config) (*Server, error) {
 	// Wrap with recovery middleware
 	handler = s.recoveryMiddleware(handler)
 
-	s.httpServer = &http.Server{
-		Addr:         cfg.ListenAddr,
-		Handler:      handler,
-		ReadTimeout:  cfg.ReadTimeout,
-		WriteTimeout: cfg.WriteTimeout,
-		IdleTimeout:  cfg.IdleTimeout,
+	// Trace every request when an OTLP endpoint is configured
+	if cfg.TracingEndpoint != "" {
+		handler = s.tracingMiddleware(handler)
 	}
+	s.handler = handler
 
 	return s, nil
 }
 
+// setupListeners prepares TLS, HTTP/3, the metrics listener and one
+// http.Server per configured listener around s.handler.
+func (s *Server) setupListeners(cfg *config.Config) error {
+	// Load TLS certificate up front so it can be swapped on reload,
+	// or let ACME obtain one on the first handshake
+	var err error
+	switch {
+	case cfg.ACME:
+		if s.acme, err = newACMEManager(cfg); err != nil {
+			return err
+		}
+	case cfg.TLSEnabled():
+		if s.certs, err = newCertStore(cfg.TLSCertFile, cfg.TLSKeyFile); err != nil {
+			return err
+		}
+	}
+	s.metricsServer = s.newMetricsServer(cfg)
+
+	// Serve the same handler over HTTP/3 and advertise it via Alt-Svc
+	handler := s.handler
+	if cfg.HTTP3 {
+		getCert, err := s.certificateSource()
+		if err != nil {
+			return fmt.Errorf("http3 requires TLS: %w", err)
+		}
+		s.quicCfg = quicListenerConfig(cfg)
+		s.h3 = newHTTP3Server(handler, getCert, s.quicCfg)
+		handler = altSvcMiddleware(s.h3, handler)
+	}
+
+	// One http.Server per listener so each can pin its own protocols
+	for _, spec := range cfg.EffectiveListeners() {
+		ls, err := s.newListenerServer(spec, handler, cfg)
+		if err != nil {
+			return err
+		}
+		s.listeners = append(s.listeners, ls)
+	}
+
+	if s.acme != nil && cfg.ACMEHTTPAddr != "" {
+		s.acmeServer = &http.Server{
+			Addr:              cfg.ACMEHTTPAddr,
+			Handler:           s.acme.HTTPHandler(nil),
+			ReadHeaderTimeout: cfg.ReadTimeout,
+		}
+	}
+	return nil
+}
+
+// Handler returns the server's routes with their middleware, to be served
+// by an http.Server of the caller's (see NewEmbedded).
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+// certificateSource returns the GetCertificate function for the active TLS mode.
+func (s *Server) certificateSource() (func(*tls.ClientHelloInfo) (*tls.Certificate, error), error) {
+	switch {
+	case s.acme != nil:
+		return s.acme.GetCertificate, nil
+	case s.certs != nil:
+		return s.certs.GetCertificate, nil
+	default:
+		return nil, fmt.Errorf("no certificate configured (tls_cert_file/tls_key_file or acme)")
+	}
+}
+
+// quicListenerConfig applies the QUIC tuning from cfg over the defaults.
+func quicListenerConfig(cfg *config.Config) QUICListenerConfig {
+	qc := DefaultQUICListenerConfig()
+	if cfg.QUICUDPBufSize > 0 {
+		qc.UDPRecvBufSize = int(cfg.QUICUDPBufSize)
+		qc.UDPSendBufSize = int(cfg.QUICUDPBufSize)
+	}
+	if cfg.QUICStreamWindow > 0 {
+		qc.MaxStreamReceiveWindow = uint64(cfg.QUICStreamWindow)
+	}
+	if cfg.QUICConnWindow > 0 {
+		qc.MaxConnectionReceiveWindow = uint64(cfg.QUICConnWindow)
+	}
+	return qc
+}
+
+// newMetaProvider builds the GeoIP-backed meta provider if a database is
+// configured, or a static provider otherwise. The returned GeoIPProvider is
+// non-nil only when a database was opened and must be closed by the caller.
+func newMetaProvider(cfg *config.Config) (meta.Provider, *meta.GeoIPProvider, error) {
+	if cfg.GeoIPDatabasePath == "" {
+		return newStaticProvider(cfg), nil, nil
+	}
+
+	gp, err := meta.NewGeoIPProvider(
+		cfg.GeoIPDatabasePath,
+		cfg.Hostname,
+		cfg.Colo,
+		cfg.TrustProxyHeaders,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	return gp, gp, nil
+}
+
+// newStaticProvider returns the fallback provider used without GeoIP.
+func newStaticProvider(cfg *config.Config) meta.Provider {
+	return &meta.StaticProvider{
+		Hostname:   cfg.Hostname,
+		Colo:       cfg.Colo,
+		TrustProxy: cfg.TrustProxyHeaders,
+		// Default values
+		Country:    "US",
+		City:       "Unknown",
+		Region:     "Unknown",
+		PostalCode: "",
+		Latitude:   0,
+		Longitude:  0,
+		Timezone:   "UTC",
+		ASN:        0,
+		ASOrg:      "Unknown",
+	}
+}
+
+// newLocationStore loads the configured locations file, falling back to
+// ./locations.json and then to the built-in defaults.
+func newLocationStore(cfg *config.Config) (locations.Store, error) {
+	locationsFile := cfg.LocationsFile
+	if locationsFile == "" {
+		// Try default locations.json in current directory
+		locationsFile = "locations.json"
+	}
+	if store, err := locations.NewFileStore(locationsFile); err == nil {
+		logger.Info("Loaded locations", "file", locationsFile)
+		return store, nil
+	} else if cfg.LocationsFile != "" {
+		// User explicitly specified a file that failed to load
+		return nil, fmt.Errorf("failed to load locations: %w", err)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		logger.Warn("Ignoring locations file", "file", locationsFile, "error", err)
+	}
+
+	// Fall back to built-in defaults
+	logger.Info("Using built-in default locations")
+	return locations.NewMemoryStore(locations.DefaultLocations()), nil
+}
+
+// iceServersFor returns the static ICE servers for the WebRTC manager.
+func iceServersFor(cfg *config.Config) []pionwebrtc.ICEServer {
+	if len(cfg.TurnServers) == 0 || cfg.TurnSecret == "" {
+		return nil
+	}
+
+	// ICE servers will be set dynamically per-request with fresh credentials
+	// For now, just set up STUN servers if available
+	var iceServers []pionwebrtc.ICEServer
+	for _, server := range cfg.TurnServers {
+		if strings.HasPrefix(server, "stun:") {
+			iceServers = append(iceServers, pionwebrtc.ICEServer{
+				URLs: []string{server},
+			})
+		}
+	}
+	return iceServers
+}
+
+// config returns the active configuration.
+func (s *Server) config() *config.Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cfg
+}
+
+// metaFor returns client metadata from the active meta provider.
+func (s *Server) metaFor(r *http.Request) meta.ClientMeta {
+	s.mu.RLock()
+	p := s.metaProvider
+	s.mu.RUnlock()
+	return p.MetaFor(r)
+}
+
+// locationStore returns the active location store.
+func (s *Server) locationStore() locations.Store {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.locations
+}
+
 // registerRoutes sets up all HTTP routes.
 func (s *Server) registerRoutes(mux *http.ServeMux) {
 	// Core measurement endpoints
 	mux.HandleFunc("/meta", s.handleMeta)
-	mux.HandleFunc("/__down", s.handleDown)
-	mux.HandleFunc("/__up", s.handleUp)
+	mux.HandleFunc("/__down", s.rateLimited("/__down", s.tokenRequired(s.admitted(admission.Egress, s.handleDown))))
+	mux.HandleFunc("/__up", s.rateLimited("/__up", s.tokenRequired(s.admitted(admission.Ingress, s.handleUp))))
 	mux.HandleFunc("/locations", s.handleLocations)
+	mux.HandleFunc("GET /api/locations/nearest", s.handleNearestLocations)
+	mux.HandleFunc("GET /api/locations/select", s.handleSelectLocation)
+	mux.HandleFunc("GET /api/probe", s.handleProbe)
 
 	// Optional diagnostic endpoint
 	mux.HandleFunc("/cdn-cgi/trace", s.handleTrace)
 
+	// Test tokens for the measurement endpoints
+	mux.HandleFunc("/api/tests", s.rateLimited("/api/tests", s.handleTests))
+
+	// Shared test results and their history
+	mux.HandleFunc("POST /api/results", s.rateLimited("/api/results", s.handleResults))
+	mux.HandleFunc("GET /api/results", s.handleResultQuery)
+	mux.HandleFunc("GET /api/results/aggregate", s.handleResultAggregate)
+	mux.HandleFunc("GET /api/results/export", s.handleResultExport)
+	mux.HandleFunc("POST /api/results/ingest", s.handleResultIngest)
+	mux.HandleFunc("/api/results/{id}", s.handleResult)
+
+	// Monitoring agents and the jobs dispatched to them
+	mux.HandleFunc("POST /api/agents/heartbeat", s.handleAgentHeartbeat)
+	mux.HandleFunc("GET /api/agents/jobs", s.handleAgentJobs)
+	mux.HandleFunc("POST /api/agents/jobs/{id}/status", s.handleAgentJobStatus)
+	mux.HandleFunc("GET /api/admin/agents", s.handleAdminAgents)
+	mux.HandleFunc("GET /api/admin/agents/{id}", s.handleAdminAgent)
+	mux.HandleFunc("GET /api/admin/jobs", s.handleAdminJobs)
+	mux.HandleFunc("POST /api/admin/jobs", s.handleAdminJobs)
+	mux.HandleFunc("GET /api/admin/jobs/{id}", s.handleAdminJob)
+	mux.HandleFunc("DELETE /api/admin/jobs/{id}", s.handleAdminJob)
+
+	// Measurements between nodes
+	mux.HandleFunc("GET /api/mesh", s.handleMesh)
+	mux.HandleFunc("GET /api/mesh/node", s.handleMeshNode)
+	mux.HandleFunc("GET /api/mesh/history", s.handleMeshHistory)
+
 	// TURN credentials endpoint
 	mux.HandleFunc("/api/turn/credentials", s.handleTurnCredentials)
 
 	// WebRTC packet-test signaling
-	mux.HandleFunc("/api/packet-test/offer", s.handlePacketTestOffer)
+	mux.HandleFunc("/api/packet-test/offer", s.rateLimited("/api/packet-test/offer", s.tokenRequired(s.handlePacketTestOffer)))
 	mux.HandleFunc("/api/packet-test/report", s.handlePacketTestReport)
 
 	// Health check
 	mux.HandleFunc("/health", s.handleHealth)
 
+	// Prometheus metrics, unless they have a listener of their own
+	if s.cfg.MetricsAddr == "" {
+		mux.HandleFunc("/metrics", s.handleMetrics)
+	}
+
 	// Static file serving for the web UI
 	if s.cfg.WebDir != "" {
 		fs := http.FileServer(http.Dir(s.cfg.WebDir))
@@ -196,57 +495,209 @@ func (s *Server) staticFileHandler(fs http.Handler) http.Handler {
 	})
 }
 
-// Run starts the HTTP server.
+// Run starts the HTTP server on every configured listener and blocks
+// until one of them fails or the server is shut down.
 func (s *Server) Run() error {
-	log.Printf("Starting netspeedd on %s", s.cfg.ListenAddr)
+	cfg := s.config()
+	logger.Info("Starting netspeedd", "listeners", len(s.listeners))
 
-	if s.cfg.WebDir != "" {
-		log.Printf("Serving static files from %s", s.cfg.WebDir)
+	if cfg.WebDir != "" {
+		logger.Info("Serving static files", "dir", cfg.WebDir)
 	}
 
-	// Create optimized listener with larger TCP buffers for speed testing
-	lnCfg := DefaultListenerConfig()
-	log.Printf("TCP buffers: send=%dKB recv=%dKB nodelay=%v",
-		lnCfg.SendBufSize/1024, lnCfg.RecvBufSize/1024, lnCfg.NoDelay)
+	// Bind everything up front so a bad address fails startup cleanly
+	for i, ls := range s.listeners {
+		if err := ls.listen(); err != nil {
+			for _, bound := range s.listeners[:i] {
+				bound.ln.Close()
+			}
+			return err
+		}
+	}
 
-	ln, err := NewOptimizedListener(s.cfg.ListenAddr, lnCfg)
-	if err != nil {
-		return fmt.Errorf("failed to create listener: %w", err)
+	if s.h3 != nil {
+		if err := s.startHTTP3(cfg); err != nil {
+			for _, ls := range s.listeners {
+				ls.ln.Close()
+			}
+			return err
+		}
+	}
+
+	if s.acme != nil {
+		logger.Info("TLS enabled via ACME",
+			"hostname", cfg.Hostname, "directory", cfg.ACMEDirectoryURL, "cache", cfg.ACMECacheDir)
+		if _, port, _ := net.SplitHostPort(cfg.ListenAddr); len(cfg.Listeners) == 0 && port != "443" {
+			logger.Info("ACME TLS-ALPN-01 challenges need port 443 to reach the listen address", "listen", cfg.ListenAddr)
+		}
+		if s.acmeServer != nil {
+			logger.Info("Serving ACME HTTP-01 challenges", "addr", s.acmeServer.Addr)
+			go func() {
+				if err := s.acmeServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					logger.Error("ACME HTTP-01 listener error", "error", err)
+				}
+			}()
+		}
+	} else if s.certs != nil {
+		logger.Info("TLS certificate loaded", "cert", cfg.TLSCertFile, "key", cfg.TLSKeyFile)
+	}
+
+	if s.metricsServer != nil {
+		logger.Info("Serving metrics", "addr", s.metricsServer.Addr)
+		go func() {
+			if err := s.metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("Metrics listener error", "error", err)
+			}
+		}()
+	}
+
+	errChan := make(chan error, len(s.listeners))
+	for _, ls := range s.listeners {
+		go func() {
+			err := ls.serve()
+			if err != nil && err != http.ErrServerClosed {
+				err = fmt.Errorf("listener %s: %w", ls.spec, err)
+			}
+			errChan <- err
+		}()
 	}
 
-	if s.cfg.TLSEnabled() {
-		log.Printf("TLS enabled with cert=%s key=%s", s.cfg.TLSCertFile, s.cfg.TLSKeyFile)
-		return s.httpServer.ServeTLS(ln, s.cfg.TLSCertFile, s.cfg.TLSKeyFile)
+	// Every listener returns ErrServerClosed once Shutdown is called;
+	// anything else is a real failure
+	for range s.listeners {
+		if err := <-errChan; err != http.ErrServerClosed {
+			return err
+		}
 	}
+	return http.ErrServerClosed
+}
 
-	return s.httpServer.Serve(ln)
+// defaultHTTP3Addr picks the UDP address for HTTP/3 when http3_addr is
+// unset: the first TLS listener on TCP, so Alt-Svc points at the same port.
+func (s *Server) defaultHTTP3Addr(cfg *config.Config) string {
+	for _, ls := range s.listeners {
+		if ls.spec.TLS && ls.spec.Network != "unix" {
+			return ls.spec.Addr
+		}
+	}
+	return cfg.ListenAddr
+}
+
+// startHTTP3 opens the UDP socket and serves HTTP/3 in the background.
+func (s *Server) startHTTP3(cfg *config.Config) error {
+	addr := cfg.HTTP3Addr
+	if addr == "" {
+		addr = s.defaultHTTP3Addr(cfg)
+	}
+
+	pc, err := NewQUICPacketConn(addr, s.quicCfg)
+	if err != nil {
+		return fmt.Errorf("failed to create QUIC listener: %w", err)
+	}
+
+	logger.Info("HTTP/3 enabled", "addr", pc.LocalAddr().String(),
+		"sendBuffer", s.quicCfg.UDPSendBufSize, "recvBuffer", s.quicCfg.UDPRecvBufSize,
+		"streamWindow", s.quicCfg.MaxStreamReceiveWindow, "connWindow", s.quicCfg.MaxConnectionReceiveWindow)
+
+	go func() {
+		if err := s.h3.Serve(pc); err != nil && err != http.ErrServerClosed {
+			logger.Error("HTTP/3 listener error", "error", err)
+		}
+	}()
+	return nil
 }
 
 // Shutdown gracefully shuts down the server.
 func (s *Server) Shutdown(ctx context.Context) error {
-	// Shutdown WebRTC manager first
-	if s.webrtcManager != nil {
-		s.webrtcManager.Shutdown()
+	// End WebRTC sessions and queued transfers first so the listeners
+	// can drain
+	s.release()
+	if s.acmeServer != nil {
+		s.acmeServer.Shutdown(ctx)
+	}
+	if s.metricsServer != nil {
+		s.metricsServer.Shutdown(ctx)
+	}
+	if s.h3 != nil {
+		s.h3.Shutdown(ctx)
+	}
+	var errs []error
+	for _, ls := range s.listeners {
+		if err := ls.srv.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("listener %s: %w", ls.spec, err))
+		}
 	}
-	// Close GeoIP database
-	if s.geoipProvider != nil {
-		s.geoipProvider.Close()
+	// Close the results database once no handler can write to it
+	if err := s.Close(); err != nil {
+		errs = append(errs, err)
 	}
-	return s.httpServer.Shutdown(ctx)
+	return errors.Join(errs...)
+}
+
+// Close ends WebRTC sessions, lets queued transfers go, stops the mesh and
+// closes the GeoIP, results and mesh history databases. It does not touch
+// the listeners: Shutdown calls it once they have drained, and a Server
+// from NewEmbedded is only closed, after the http.Server serving its
+// Handler has shut down. Calls after the first return the same error.
+func (s *Server) Close() error {
+	s.release()
+	s.closeOnce.Do(func() {
+		for _, unregister := range s.unregisterGauges {
+			unregister()
+		}
+		if s.results != nil {
+			if err := s.results.Close(); err != nil {
+				s.closeErr = fmt.Errorf("results database: %w", err)
+			}
+		}
+		if s.meshHistory != nil {
+			if err := s.meshHistory.Close(); err != nil && s.closeErr == nil {
+				s.closeErr = fmt.Errorf("mesh history: %w", err)
+			}
+		}
+	})
+	return s.closeErr
 }
 
+// release stops what could keep handlers running: WebRTC sessions, the
+// admission queue and agents polling for jobs. It also stops the mesh and
+// closes the GeoIP database.
+func (s *Server) release() {
+	s.releaseOnce.Do(func() {
+		if s.meshStop != nil {
+			s.meshStop()
+			<-s.meshDone
+		}
+		if s.webrtcManager != nil {
+			s.webrtcManager.Shutdown()
+		}
+		s.admission.Close()
+		s.fleet.Close()
+		s.mu.RLock()
+		geoipProvider := s.geoipProvider
+		s.mu.RUnlock()
+		if geoipProvider != nil {
+			geoipProvider.Close()
+		}
+	})
+}
+
+// exposedHeaders are the response headers browser clients may read.
+var exposedHeaders = strings.Join([]string{congestionHeader, tcpInfoTrailer, constrainedHeader, "Retry-After", "WWW-Authenticate"}, ", ")
+
 // corsMiddleware handles CORS headers and preflight requests.
 func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := s.config()
 		origin := r.Header.Get("Origin")
-		if origin == "" {
+		if !cfg.EnableCORS || origin == "" {
 			next.ServeHTTP(w, r)
 			return
 		}
 
 		// Check if origin is allowed
 		allowed := false
-		for _, o := range s.cfg.AllowedOrigins {
+		for _, o := range cfg.AllowedOrigins {
 			if o == "*" || o == origin {
 				allowed = true
 				break
@@ -259,7 +710,7 @@ func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 		}
 
 		// Set CORS headers
-		if len(s.cfg.AllowedOrigins) == 1 && s.cfg.AllowedOrigins[0] == "*" {
+		if len(cfg.AllowedOrigins) == 1 && cfg.AllowedOrigins[0] == "*" {
 			w.Header().Set("Access-Control-Allow-Origin", "*")
 		} else {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
@@ -269,17 +720,21 @@ func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 		// Handle preflight requests
 		if r.Method == http.MethodOptions {
 			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Requested-With")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Requested-With")
 			w.Header().Set("Access-Control-Max-Age", "86400")
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 
+		// Let browser clients read the per-test headers
+		w.Header().Set("Access-Control-Expose-Headers", exposedHeaders)
+
 		next.ServeHTTP(w, r)
 	})
 }
 
-// loggingMiddleware logs HTTP requests.
+// loggingMiddleware logs HTTP requests at debug level; the measurement
+// handlers log their own, more detailed line at info.
 func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -290,13 +745,17 @@ func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(rw, r)
 
 		duration := time.Since(start)
-		log.Printf("%s %s %d %s %s",
-			r.Method,
-			r.URL.Path,
-			rw.statusCode,
-			duration,
-			meta.ClientIPFromRequest(r, s.cfg.TrustProxyHeaders),
-		)
+		// The mux records the matched pattern on r, keeping route labels bounded
+		metrics.ObserveRequest(r.Pattern, rw.statusCode, duration)
+		if logger.Enabled(r.Context(), slog.LevelDebug) {
+			logger.LogAttrs(r.Context(), slog.LevelDebug, "Request",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", rw.statusCode),
+				slog.Duration("duration", duration),
+				slog.String("client", meta.ClientIPFromRequest(r, s.config().TrustProxyHeaders)),
+			)
+		}
 	})
 }
 
@@ -305,7 +764,7 @@ func (s *Server) recoveryMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				log.Printf("Panic recovered: %v", err)
+				logger.Error("Panic recovered", "panic", err, "method", r.Method, "path", r.URL.Path)
 				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			}
 		}()
@@ -324,9 +783,14 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // setServerTiming adds the Server-Timing header if enabled.
 func (s *Server) setServerTiming(w http.ResponseWriter, start time.Time) {
-	if s.cfg.EnableServerTiming {
+	if s.config().EnableServerTiming {
 		durMs := time.Since(start).Milliseconds()
 		w.Header().Set("Server-Timing", fmt.Sprintf("app;dur=%d", durMs))
 	}
@@ -348,13 +812,28 @@ func (s *Server) setMetaHeaders(w http.ResponseWriter, clientMeta meta.ClientMet
 	}
 }
 
-// handleHealth is a simple health check endpoint.
+// healthResponse is the JSON form of /health.
+type healthResponse struct {
+	Status string         `json:"status"`
+	Load   admission.Load `json:"load"`
+}
+
+// handleHealth is a simple health check endpoint. It reports "ok", or the
+// current load as JSON if the client asks for it.
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	load := s.admission.Load()
+	w.Header().Set("Cache-Control", "no-store")
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(healthResponse{Status: "ok", Load: load})
+		return
+	}
+
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("ok"))
@@ -391,6 +870,9 @@ func getHTTPVersion(r *http.Request) string {
 	case "http/1.1":
 		return "http/1.1"
 	case "http/2.0", "http/2":
+		if r.TLS == nil {
+			return "h2c"
+		}
 		return "h2"
 	case "http/3.0", "http/3":
 		return "h3"
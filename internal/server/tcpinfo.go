@@ -0,0 +1,194 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// tcpInfoTrailer carries the TCP_INFO summary of a /__down transfer.
+const tcpInfoTrailer = "X-TCP-Info"
+
+// errTCPInfoUnsupported is returned where TCP_INFO is not available.
+var errTCPInfoUnsupported = errors.New("TCP_INFO is not supported on this platform")
+
+// tcpInfoSample is one reading of the kernel's TCP_INFO for a connection.
+type tcpInfoSample struct {
+	SRTT          time.Duration
+	RTTVar        time.Duration
+	Cwnd          uint32 // segments
+	TotalRetrans  uint32
+	DeliveryRate  uint64 // bytes per second
+	BytesAcked    uint64
+	BytesReceived uint64
+}
+
+// TCPInfoSummary is the server's view of a transfer, aggregated from
+// TCP_INFO samples taken while it ran. On HTTP/2 the numbers cover the
+// whole connection, not just the one stream.
+type TCPInfoSummary struct {
+	MeasID             string  `json:"measId,omitempty"`
+	Samples            int     `json:"samples"`
+	DurationMs         float64 `json:"durationMs"`
+	SRTTMs             float64 `json:"srttMs"`
+	SRTTMinMs          float64 `json:"srttMinMs"`
+	SRTTAvgMs          float64 `json:"srttAvgMs"`
+	SRTTMaxMs          float64 `json:"srttMaxMs"`
+	RTTVarMs           float64 `json:"rttvarMs"`
+	Cwnd               uint32  `json:"cwnd"`
+	CwndMax            uint32  `json:"cwndMax"`
+	Retransmits        uint32  `json:"retransmits"`
+	DeliveryRateBps    uint64  `json:"deliveryRateBps"`
+	DeliveryRateMaxBps uint64  `json:"deliveryRateMaxBps"`
+	BytesAcked         uint64  `json:"bytesAcked"`
+	BytesReceived      uint64  `json:"bytesReceived"`
+}
+
+// trailerValue encodes the summary for the X-TCP-Info trailer.
+func (t *TCPInfoSummary) trailerValue() string {
+	b, _ := json.Marshal(t)
+	return string(b)
+}
+
+// logAttr groups the summary for the transfer log line; empty if nil.
+func (t *TCPInfoSummary) logAttr() slog.Attr {
+	if t == nil {
+		return slog.Attr{}
+	}
+	return slog.Group("tcp",
+		slog.Float64("srttMs", t.SRTTMs),
+		slog.Float64("srttMaxMs", t.SRTTMaxMs),
+		slog.Uint64("cwnd", uint64(t.Cwnd)),
+		slog.Uint64("retransmits", uint64(t.Retransmits)),
+		slog.Float64("deliveryRateMbps", float64(t.DeliveryRateBps)/1_000_000),
+	)
+}
+
+// stopSampler stops t, if any, and returns the log attribute of its summary.
+func stopSampler(t *tcpSampler, measID string) slog.Attr {
+	if t == nil {
+		return slog.Attr{}
+	}
+	return t.Stop(measID).logAttr()
+}
+
+// acceptsTrailers reports whether the client sent "TE: trailers".
+func acceptsTrailers(r *http.Request) bool {
+	return httpguts.HeaderValuesContainsToken(r.Header["Te"], "trailers")
+}
+
+// tcpSampler polls TCP_INFO on a connection until stopped. Counters in the
+// summary (retransmits, bytes) are deltas from the first sample, so earlier
+// requests on a kept-alive connection do not count.
+type tcpSampler struct {
+	conn  syscall.Conn
+	start time.Time
+	first tcpInfoSample
+
+	mu      sync.Mutex
+	last    tcpInfoSample
+	samples int
+	srttSum time.Duration
+	srttMin time.Duration
+	srttMax time.Duration
+	cwndMax uint32
+	rateMax uint64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startTCPSampler takes a first sample from conn and then samples every
+// interval in the background. It returns nil if TCP_INFO is unavailable.
+func startTCPSampler(conn syscall.Conn, interval time.Duration) *tcpSampler {
+	first, err := readTCPInfo(conn)
+	if err != nil {
+		return nil
+	}
+
+	t := &tcpSampler{
+		conn:  conn,
+		start: time.Now(),
+		first: first,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	t.add(first)
+
+	go func() {
+		defer close(t.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if sample, err := readTCPInfo(conn); err == nil {
+					t.add(sample)
+				}
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// add folds one sample into the running aggregates.
+func (t *tcpSampler) add(s tcpInfoSample) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.last = s
+	t.samples++
+	t.srttSum += s.SRTT
+	if t.samples == 1 || s.SRTT < t.srttMin {
+		t.srttMin = s.SRTT
+	}
+	if s.SRTT > t.srttMax {
+		t.srttMax = s.SRTT
+	}
+	if s.Cwnd > t.cwndMax {
+		t.cwndMax = s.Cwnd
+	}
+	if s.DeliveryRate > t.rateMax {
+		t.rateMax = s.DeliveryRate
+	}
+}
+
+// Stop takes a final sample, ends the background polling and returns the
+// summary for measID.
+func (t *tcpSampler) Stop(measID string) *TCPInfoSummary {
+	close(t.stop)
+	<-t.done
+	if sample, err := readTCPInfo(t.conn); err == nil {
+		t.add(sample)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000.0 }
+	return &TCPInfoSummary{
+		MeasID:             measID,
+		Samples:            t.samples,
+		DurationMs:         ms(time.Since(t.start)),
+		SRTTMs:             ms(t.last.SRTT),
+		SRTTMinMs:          ms(t.srttMin),
+		SRTTAvgMs:          ms(t.srttSum / time.Duration(t.samples)),
+		SRTTMaxMs:          ms(t.srttMax),
+		RTTVarMs:           ms(t.last.RTTVar),
+		Cwnd:               t.last.Cwnd,
+		CwndMax:            t.cwndMax,
+		Retransmits:        t.last.TotalRetrans - t.first.TotalRetrans,
+		DeliveryRateBps:    t.last.DeliveryRate * 8,
+		DeliveryRateMaxBps: t.rateMax * 8,
+		BytesAcked:         t.last.BytesAcked - t.first.BytesAcked,
+		BytesReceived:      t.last.BytesReceived - t.first.BytesReceived,
+	}
+}
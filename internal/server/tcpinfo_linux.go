@@ -0,0 +1,37 @@
+package server
+
+import (
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// readTCPInfo reads TCP_INFO from conn.
+func readTCPInfo(conn syscall.Conn) (tcpInfoSample, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return tcpInfoSample{}, err
+	}
+	var info *unix.TCPInfo
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		info, sockErr = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	})
+	if err != nil {
+		return tcpInfoSample{}, err
+	}
+	if sockErr != nil {
+		return tcpInfoSample{}, sockErr
+	}
+
+	return tcpInfoSample{
+		SRTT:          time.Duration(info.Rtt) * time.Microsecond,
+		RTTVar:        time.Duration(info.Rttvar) * time.Microsecond,
+		Cwnd:          info.Snd_cwnd,
+		TotalRetrans:  info.Total_retrans,
+		DeliveryRate:  info.Delivery_rate,
+		BytesAcked:    info.Bytes_acked,
+		BytesReceived: info.Bytes_received,
+	}, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package server
+
+import "syscall"
+
+// readTCPInfo is only implemented on Linux.
+func readTCPInfo(conn syscall.Conn) (tcpInfoSample, error) {
+	return tcpInfoSample{}, errTCPInfoUnsupported
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// certStore holds the active TLS certificate and serves it through
+// tls.Config.GetCertificate so it can be replaced without restarting
+// the listener. Existing connections keep the certificate they negotiated.
+type certStore struct {
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// newCertStore loads the certificate/key pair from disk.
+func newCertStore(certFile, keyFile string) (*certStore, error) {
+	cert, err := loadCertificate(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &certStore{cert: cert}, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (c *certStore) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// Reload loads the certificate/key pair from the given paths and swaps it in.
+// If loading fails, the current certificate stays active and the error is returned.
+// It returns a description of the new certificate.
+func (c *certStore) Reload(certFile, keyFile string) (string, error) {
+	cert, err := loadCertificate(certFile, keyFile)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	c.cert = cert
+	c.mu.Unlock()
+
+	return describeCertificate(cert), nil
+}
+
+// loadCertificate reads a PEM certificate/key pair and parses the leaf.
+func loadCertificate(certFile, keyFile string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	if cert.Leaf == nil && len(cert.Certificate) > 0 {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse TLS certificate: %w", err)
+		}
+		cert.Leaf = leaf
+	}
+	return &cert, nil
+}
+
+// describeCertificate returns a short summary of the leaf certificate for logging.
+func describeCertificate(cert *tls.Certificate) string {
+	if cert.Leaf == nil {
+		return "unknown certificate"
+	}
+	return fmt.Sprintf("subject=%q serial=%s expires=%s",
+		cert.Leaf.Subject.CommonName, cert.Leaf.SerialNumber, cert.Leaf.NotAfter.Format(time.RFC3339))
+}
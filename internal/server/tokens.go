@@ -0,0 +1,196 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/yellowman/netspeed/api"
+	"github.com/yellowman/netspeed/internal/config"
+	"github.com/yellowman/netspeed/internal/meta"
+	"github.com/yellowman/netspeed/internal/token"
+)
+
+// newTokenSigner builds the signer for the test_tokens settings. Without a
+// configured secret or key file a random key is generated, which only this
+// process can verify.
+func newTokenSigner(tt *config.TestTokens) (token.Signer, error) {
+	if tt.Algorithm == config.TokenAlgEd25519 {
+		var key ed25519.PrivateKey
+		var err error
+		if tt.KeyFile != "" {
+			key, err = token.LoadEd25519PrivateKey(tt.KeyFile)
+		} else {
+			_, key, err = ed25519.GenerateKey(rand.Reader)
+		}
+		if err != nil {
+			return nil, err
+		}
+		extra := make([]ed25519.PublicKey, 0, len(tt.PublicKeyFiles))
+		for _, path := range tt.PublicKeyFiles {
+			pub, err := token.LoadEd25519PublicKey(path)
+			if err != nil {
+				return nil, err
+			}
+			extra = append(extra, pub)
+		}
+		return token.NewEd25519(key, extra...)
+	}
+
+	secret := []byte(tt.Secret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+	return token.NewHMAC(secret)
+}
+
+// generatedTokenKey reports whether tt has no configured key material, so
+// the signer holds a random key that must survive reloads.
+func generatedTokenKey(tt *config.TestTokens) bool {
+	if tt.Algorithm == config.TokenAlgEd25519 {
+		return tt.KeyFile == ""
+	}
+	return tt.Secret == ""
+}
+
+// tokenSignerChanged reports whether the signer must be rebuilt on reload.
+// Key files are re-read on every reload, like the TLS certificate.
+func tokenSignerChanged(old, cur *config.TestTokens) bool {
+	same := old.Algorithm == cur.Algorithm &&
+		old.Secret == cur.Secret &&
+		old.KeyFile == cur.KeyFile &&
+		reflect.DeepEqual(old.PublicKeyFiles, cur.PublicKeyFiles)
+	return !same || !generatedTokenKey(cur)
+}
+
+// tokenError is the JSON body of a 401 response.
+type tokenError struct {
+	Error   string `json:"error"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// tokenRequired wraps a measurement endpoint so it only runs with a valid
+// test token while tokens are enabled. The request's bytes are charged to
+// the token's budget and settled against what was actually transferred.
+func (s *Server) tokenRequired(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := s.config()
+		if !cfg.TestTokens.Enabled || r.Method == http.MethodOptions {
+			next(w, r)
+			return
+		}
+
+		clientIP := meta.ClientIPFromRequest(r, cfg.TrustProxyHeaders)
+		ip, _ := netip.ParseAddr(clientIP)
+
+		claims, terr := s.tokens.Verify(tokenFromRequest(r), ip)
+		if terr != nil {
+			logger.Info("Token rejected", "client", clientIP, "path", r.URL.Path, "reason", terr.Reason)
+			writeTokenRejected(w, terr)
+			return
+		}
+		res, terr := s.tokens.Reserve(claims, expectedBytes(r))
+		if terr != nil {
+			logger.Info("Token rejected", "client", clientIP, "path", r.URL.Path, "token", claims.ID, "reason", terr.Reason)
+			writeTokenRejected(w, terr)
+			return
+		}
+
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		cw := &countingWriter{ResponseWriter: w}
+		next(cw, r)
+		res.Done(body.n + cw.n)
+	}
+}
+
+// tokenFromRequest returns the bearer token from the Authorization header,
+// or the token query parameter for clients that cannot set headers.
+func tokenFromRequest(r *http.Request) string {
+	if scheme, tok, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(tok)
+	}
+	return r.URL.Query().Get("token")
+}
+
+// writeTokenRejected sends a 401 with a machine-readable reason.
+func writeTokenRejected(w http.ResponseWriter, terr *token.Error) {
+	challenge := `Bearer realm="netspeedd"`
+	if terr.Reason != token.ReasonMissing {
+		challenge += `, error="invalid_token"`
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("WWW-Authenticate", challenge)
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(tokenError{
+		Error:   "invalid_token",
+		Reason:  terr.Reason,
+		Message: terr.Message,
+	})
+}
+
+// TestTokenResponse is the response for POST /api/tests.
+type TestTokenResponse = api.TestTokenResponse
+
+// handleTests handles POST /api/tests - issues a test token bound to the
+// client IP. Browsers may only ask from this node's own origin or one of
+// the configured test_tokens origins.
+func (s *Server) handleTests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cfg := s.config()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if !cfg.TestTokens.Enabled {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(tokenError{
+			Error:   "tokens_disabled",
+			Reason:  "tokens_disabled",
+			Message: "this server does not require test tokens",
+		})
+		return
+	}
+
+	clientIP := meta.ClientIPFromRequest(r, cfg.TrustProxyHeaders)
+	if origin := r.Header.Get("Origin"); origin != "" && !cfg.TestTokens.OriginAllowed(origin, r.Host) {
+		logger.Info("Test token refused", "client", clientIP, "origin", origin)
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(tokenError{
+			Error:   "forbidden",
+			Reason:  "origin_not_allowed",
+			Message: "test tokens are not issued to " + origin,
+		})
+		return
+	}
+
+	ip, _ := netip.ParseAddr(clientIP)
+	tok, claims, err := s.tokens.Issue(ip, cfg.TestTokens.ByteBudget, cfg.TestTokens.TTL)
+	if err != nil {
+		logger.Error("Failed to issue test token", "error", err)
+		http.Error(w, "failed to issue token", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info("Test token issued", "client", clientIP, "token", claims.ID, "bytes", claims.Bytes, "ttl", cfg.TestTokens.TTL)
+	json.NewEncoder(w).Encode(TestTokenResponse{
+		Token:     tok,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC(),
+		TTLSec:    int64(cfg.TestTokens.TTL / time.Second),
+		Bytes:     claims.Bytes,
+		Algorithm: s.tokens.Algorithm(),
+	})
+}
@@ -0,0 +1,163 @@
+package token
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often budgets of expired tokens are dropped.
+const sweepInterval = time.Minute
+
+// budget is the spending of one token.
+type budget struct {
+	used    int64
+	expires time.Time
+}
+
+// Authority issues tokens, verifies them and tracks how much of each
+// token's byte budget has been spent. It is safe for concurrent use.
+type Authority struct {
+	mu        sync.Mutex
+	signer    Signer
+	spent     map[string]*budget
+	lastSweep time.Time
+}
+
+// New creates an Authority that signs with signer.
+func New(signer Signer) *Authority {
+	return &Authority{
+		signer: signer,
+		spent:  make(map[string]*budget),
+	}
+}
+
+// SetSigner replaces the signing key. Tokens signed with the old key stop
+// verifying unless the new signer also accepts it; budgets are kept.
+func (a *Authority) SetSigner(signer Signer) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.signer = signer
+}
+
+// Algorithm returns the current signing algorithm.
+func (a *Authority) Algorithm() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.signer.Algorithm()
+}
+
+// Issue creates a token for ip allowing bytes of transfers until ttl from now.
+func (a *Authority) Issue(ip netip.Addr, bytes int64, ttl time.Duration) (string, Claims, error) {
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return "", Claims{}, err
+	}
+	now := time.Now()
+	c := Claims{
+		ID:        hex.EncodeToString(id),
+		IP:        ip.Unmap().String(),
+		Bytes:     bytes,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	}
+
+	a.mu.Lock()
+	signer := a.signer
+	a.mu.Unlock()
+
+	tok, err := encode(signer, c)
+	if err != nil {
+		return "", Claims{}, err
+	}
+	return tok, c, nil
+}
+
+// Verify checks tok's signature and expiry and that it was issued to ip.
+func (a *Authority) Verify(tok string, ip netip.Addr) (*Claims, *Error) {
+	if tok == "" {
+		return nil, reject(ReasonMissing, "a test token is required, get one from POST /api/tests")
+	}
+
+	a.mu.Lock()
+	signer := a.signer
+	a.mu.Unlock()
+
+	c, err := decode(signer, tok)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() >= c.ExpiresAt {
+		return nil, reject(ReasonExpired, "token expired at %s", time.Unix(c.ExpiresAt, 0).UTC().Format(time.RFC3339))
+	}
+	if c.IP != ip.Unmap().String() {
+		return nil, reject(ReasonIPMismatch, "token was issued to %s, not %s", c.IP, ip.Unmap())
+	}
+	return c, nil
+}
+
+// Reserve claims bytes of c's budget for a request, or a negative value if
+// the size is not known. Requests that move nothing always fit; requests of
+// unknown size fit while any budget is left. Done must be called on the
+// returned Reservation with the bytes actually transferred.
+func (a *Authority) Reserve(c *Claims, bytes int64) (*Reservation, *Error) {
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.sweep(now)
+	b := a.spent[c.ID]
+	if b == nil {
+		b = &budget{expires: time.Unix(c.ExpiresAt, 0)}
+		a.spent[c.ID] = b
+	}
+
+	var over bool
+	switch {
+	case bytes == 0:
+	case bytes < 0:
+		over = b.used >= c.Bytes
+	default:
+		over = bytes > c.Bytes-b.used
+	}
+	if over {
+		return nil, reject(ReasonBudget, "token byte budget exceeded (%d of %d bytes used)", b.used, c.Bytes)
+	}
+
+	bytes = max(bytes, 0)
+	b.used += bytes
+	return &Reservation{authority: a, budget: b, reserved: bytes}, nil
+}
+
+// sweep drops the budgets of expired tokens; they can no longer be used.
+func (a *Authority) sweep(now time.Time) {
+	if now.Sub(a.lastSweep) < sweepInterval {
+		return
+	}
+	a.lastSweep = now
+	for id, b := range a.spent {
+		if now.After(b.expires) {
+			delete(a.spent, id)
+		}
+	}
+}
+
+// Reservation is an admitted request's claim on a token's budget.
+type Reservation struct {
+	authority *Authority
+	budget    *budget
+	reserved  int64
+	once      sync.Once
+}
+
+// Done settles the reservation against the bytes actually transferred.
+func (r *Reservation) Done(actual int64) {
+	r.once.Do(func() {
+		r.authority.mu.Lock()
+		defer r.authority.mu.Unlock()
+		r.budget.used = max(r.budget.used+actual-r.reserved, 0)
+	})
+}
@@ -0,0 +1,166 @@
+// Package token issues and checks signed test tokens.
+//
+// A token authorizes one client to run measurements against this node for
+// a short time. It binds the client IP, a byte budget and an expiry, and is
+// signed with either HMAC-SHA256 or Ed25519 so the node (or any node that
+// shares the key) can check it without keeping state. The budget spent so
+// far is the only thing tracked server-side.
+//
+// The wire format is base64url(JSON claims) "." base64url(signature).
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Signing algorithms.
+const (
+	AlgHMAC    = "hmac"    // HMAC-SHA256 with a shared secret
+	AlgEd25519 = "ed25519" // Ed25519; nodes can verify with the public key only
+)
+
+// Reasons a token is rejected, as reported in Error.Reason.
+const (
+	ReasonMissing    = "missing_token"
+	ReasonMalformed  = "malformed_token"
+	ReasonSignature  = "invalid_signature"
+	ReasonExpired    = "token_expired"
+	ReasonIPMismatch = "ip_mismatch"
+	ReasonBudget     = "budget_exhausted"
+)
+
+// Error explains why a token was rejected.
+type Error struct {
+	Reason  string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func reject(reason, format string, args ...any) *Error {
+	return &Error{Reason: reason, Message: fmt.Sprintf(format, args...)}
+}
+
+// Claims are the contents of a token.
+type Claims struct {
+	ID        string `json:"jti"`
+	IP        string `json:"ip"`
+	Bytes     int64  `json:"bytes"` // byte budget across all requests
+	IssuedAt  int64  `json:"iat"`   // unix seconds
+	ExpiresAt int64  `json:"exp"`   // unix seconds
+}
+
+// Signer signs and verifies token payloads.
+type Signer interface {
+	Algorithm() string
+	Sign(payload []byte) []byte
+	Verify(payload, sig []byte) bool
+}
+
+// minSecretLen is the shortest HMAC secret accepted.
+const minSecretLen = 16
+
+type hmacSigner struct {
+	secret []byte
+}
+
+// NewHMAC returns a Signer using HMAC-SHA256 with secret.
+func NewHMAC(secret []byte) (Signer, error) {
+	if len(secret) < minSecretLen {
+		return nil, fmt.Errorf("hmac secret must be at least %d bytes", minSecretLen)
+	}
+	return &hmacSigner{secret: secret}, nil
+}
+
+func (s *hmacSigner) Algorithm() string { return AlgHMAC }
+
+func (s *hmacSigner) Sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func (s *hmacSigner) Verify(payload, sig []byte) bool {
+	return hmac.Equal(s.Sign(payload), sig)
+}
+
+type ed25519Signer struct {
+	key    ed25519.PrivateKey
+	verify []ed25519.PublicKey
+}
+
+// NewEd25519 returns a Signer that signs with key and accepts signatures
+// from key and from any of the extra public keys, e.g. those of other
+// nodes or of a central issuer.
+func NewEd25519(key ed25519.PrivateKey, extra ...ed25519.PublicKey) (Signer, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid ed25519 private key")
+	}
+	verify := []ed25519.PublicKey{key.Public().(ed25519.PublicKey)}
+	for _, pub := range extra {
+		if len(pub) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 public key")
+		}
+		verify = append(verify, pub)
+	}
+	return &ed25519Signer{key: key, verify: verify}, nil
+}
+
+func (s *ed25519Signer) Algorithm() string { return AlgEd25519 }
+
+func (s *ed25519Signer) Sign(payload []byte) []byte {
+	return ed25519.Sign(s.key, payload)
+}
+
+func (s *ed25519Signer) Verify(payload, sig []byte) bool {
+	for _, pub := range s.verify {
+		if ed25519.Verify(pub, payload, sig) {
+			return true
+		}
+	}
+	return false
+}
+
+var encoding = base64.RawURLEncoding
+
+// encode serializes and signs claims.
+func encode(signer Signer, c Claims) (string, error) {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(payload) + "." + encoding.EncodeToString(signer.Sign(payload)), nil
+}
+
+// decode checks the signature of tok and returns its claims.
+func decode(signer Signer, tok string) (*Claims, *Error) {
+	p, s, ok := strings.Cut(tok, ".")
+	if !ok {
+		return nil, reject(ReasonMalformed, "token is malformed")
+	}
+	payload, err := encoding.DecodeString(p)
+	if err != nil {
+		return nil, reject(ReasonMalformed, "token payload is not base64url")
+	}
+	sig, err := encoding.DecodeString(s)
+	if err != nil {
+		return nil, reject(ReasonMalformed, "token signature is not base64url")
+	}
+	if !signer.Verify(payload, sig) {
+		return nil, reject(ReasonSignature, "token signature is invalid")
+	}
+	var c Claims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return nil, reject(ReasonMalformed, "token payload is not valid JSON")
+	}
+	return &c, nil
+}
@@ -0,0 +1,110 @@
+// Package tracing sets up OpenTelemetry tracing for netspeedd.
+//
+// Spans are exported over OTLP/HTTP, so a local OpenTelemetry Collector (or
+// anything else that speaks OTLP on :4318) is enough to look at them. When
+// no endpoint is configured the global no-op provider stays in place and
+// starting spans costs next to nothing.
+//
+// Spans that belong to the same measurement carry the same netspeed.*
+// attributes: meas_id for /__down and /__up, test_id for the WebRTC packet
+// test, and turn.username to tie TURN authentications to the credentials
+// request that handed them out.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation is the tracer name used by every subsystem.
+const instrumentation = "github.com/yellowman/netspeed"
+
+// Correlation attributes.
+const (
+	MeasIDKey   = attribute.Key("netspeed.meas_id")
+	TestIDKey   = attribute.Key("netspeed.test_id")
+	TURNUserKey = attribute.Key("netspeed.turn.username")
+)
+
+// Config configures trace export.
+type Config struct {
+	// Endpoint is the OTLP/HTTP base URL, e.g. http://localhost:4318;
+	// empty disables tracing
+	Endpoint string
+	// SampleRatio is the fraction of new traces recorded (0..1); requests
+	// that arrive with a sampled parent are always recorded
+	SampleRatio float64
+	// ServiceVersion, Hostname and Colo describe this node
+	ServiceVersion string
+	Hostname       string
+	Colo           string
+}
+
+// Setup installs the global tracer provider and W3C trace context
+// propagation. The returned function flushes and stops the exporter; it is
+// a no-op if tracing is disabled.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	attrs := []attribute.KeyValue{
+		semconv.ServiceName("netspeedd"),
+		semconv.ServiceVersion(cfg.ServiceVersion),
+	}
+	if cfg.Hostname != "" {
+		attrs = append(attrs, semconv.HostName(cfg.Hostname))
+	}
+	if cfg.Colo != "" {
+		attrs = append(attrs, attribute.String("netspeed.colo", cfg.Colo))
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attrs...))
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+	return tp.Shutdown, nil
+}
+
+// Tracer returns the tracer for netspeedd spans.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
+
+// MeasID tags a span with a /__down or /__up measurement ID.
+func MeasID(id string) attribute.KeyValue {
+	return MeasIDKey.String(id)
+}
+
+// TestID tags a span with a packet-test ID.
+func TestID(id string) attribute.KeyValue {
+	return TestIDKey.String(id)
+}
+
+// TURNUser tags a span with a TURN username.
+func TURNUser(username string) attribute.KeyValue {
+	return TURNUserKey.String(username)
+}
@@ -2,19 +2,32 @@
 package turn
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
-	"log"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pion/turn/v2"
+	"github.com/yellowman/netspeed/internal/logging"
+	"github.com/yellowman/netspeed/internal/metrics"
+	"github.com/yellowman/netspeed/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	logger = logging.For(logging.TURN)
+	// authSampler picks the auth requests that are logged; every
+	// candidate pair of a packet test authenticates
+	authSampler = logging.Sampled(logging.TURNAuth)
 )
 
 // Server wraps a pion TURN server.
@@ -75,13 +88,15 @@ func New(cfg Config) (*Server, error) {
 		if relayIP == "" {
 			// Fallback to localhost - will only work for local testing
 			relayIP = "127.0.0.1"
-			log.Printf("Warning: No public IP configured for TURN, using localhost (only works locally)")
+			logger.Warn("No public IP configured for TURN, using localhost (only works locally)")
 		}
 	}
 
-	relayAddressGenerator := &turn.RelayAddressGeneratorStatic{
-		RelayAddress: net.ParseIP(relayIP),
-		Address:      "0.0.0.0",
+	relayAddressGenerator := &countingRelayGenerator{
+		RelayAddressGenerator: &turn.RelayAddressGeneratorStatic{
+			RelayAddress: net.ParseIP(relayIP),
+			Address:      "0.0.0.0",
+		},
 	}
 
 	// Create TURN server with COTURN-style time-limited credentials
@@ -91,27 +106,49 @@ func New(cfg Config) (*Server, error) {
 			// COTURN-style time-limited credentials
 			// Username format: <expiry_unix_timestamp>:<token>
 			// The credential/password is base64(HMAC-SHA1(secret, username))
-			log.Printf("TURN auth request: user=%s realm=%s from=%s", username, realm, srcAddr)
+			if authSampler.Allow() {
+				logger.Info("TURN auth request",
+					"user", username, "realm", realm, "from", srcAddr.String(), authSampler.Attr())
+			}
+
+			// The username ties this span to the /api/turn/credentials
+			// request that issued it
+			_, span := tracing.Tracer().Start(context.Background(), "turn.auth",
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					tracing.TURNUser(username),
+					attribute.String("netspeed.turn.realm", realm),
+					attribute.String("client.address", srcAddr.String()),
+				))
+			defer span.End()
+			result := func(r string) {
+				metrics.TURNAuth(r)
+				span.SetAttributes(attribute.String("netspeed.turn.auth_result", r))
+			}
 
 			// Validate expiry timestamp
 			parts := strings.SplitN(username, ":", 2)
 			if len(parts) != 2 {
-				log.Printf("TURN auth failed: invalid username format")
+				logger.Warn("TURN auth failed: invalid username format", "user", username, "from", srcAddr.String())
+				result("invalid")
 				return nil, false
 			}
 			expiry, err := strconv.ParseInt(parts[0], 10, 64)
 			if err != nil {
-				log.Printf("TURN auth failed: invalid expiry timestamp")
+				logger.Warn("TURN auth failed: invalid expiry timestamp", "user", username, "from", srcAddr.String())
+				result("invalid")
 				return nil, false
 			}
 			if time.Now().Unix() > expiry {
-				log.Printf("TURN auth failed: credentials expired")
+				logger.Info("TURN auth failed: credentials expired", "user", username, "from", srcAddr.String())
+				result("expired")
 				return nil, false
 			}
 
 			mac := hmac.New(sha1.New, []byte(cfg.Secret))
 			mac.Write([]byte(username))
 			password := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+			result("ok")
 			return turn.GenerateAuthKey(username, realm, password), true
 		},
 		PacketConnConfigs: []turn.PacketConnConfig{
@@ -159,7 +196,32 @@ func (s *Server) Close() error {
 
 // Start logs that the server is running (it starts automatically in New).
 func (s *Server) Start() {
-	log.Printf("Embedded TURN server listening on %s (realm: %s)", s.listenAddr, s.realm)
+	logger.Info("Embedded TURN server listening", "addr", s.listenAddr, "realm", s.realm)
+}
+
+// countingRelayGenerator counts the relay allocations made through it.
+type countingRelayGenerator struct {
+	turn.RelayAddressGenerator
+}
+
+func (g *countingRelayGenerator) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	conn, addr, err := g.RelayAddressGenerator.AllocatePacketConn(network, requestedPort)
+	if err != nil {
+		return nil, nil, err
+	}
+	metrics.TURNAllocationOpened()
+	return &allocatedConn{PacketConn: conn}, addr, nil
+}
+
+// allocatedConn is a relay socket that is counted until it is closed.
+type allocatedConn struct {
+	net.PacketConn
+	once sync.Once
+}
+
+func (c *allocatedConn) Close() error {
+	c.once.Do(metrics.TURNAllocationClosed)
+	return c.PacketConn.Close()
 }
 
 // getLocalIP returns the first non-loopback IPv4 address.
@@ -2,21 +2,31 @@
 package webrtc
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/pion/webrtc/v3"
+	"github.com/yellowman/netspeed/internal/logging"
+	"github.com/yellowman/netspeed/internal/metrics"
+	"github.com/yellowman/netspeed/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var logger = logging.For(logging.WebRTC)
+
 // Manager handles WebRTC peer connections for packet loss testing.
 type Manager struct {
 	mu       sync.RWMutex
 	sessions map[string]*Session
 	config   Config
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
 // Config holds WebRTC manager configuration.
@@ -46,6 +56,9 @@ type Session struct {
 	Stats          *SessionStats
 	done           chan struct{}
 	mu             sync.Mutex
+	// span covers the session from the offer until it is closed; state
+	// changes are recorded on it as events
+	span trace.Span
 }
 
 // SessionStats tracks packet statistics for a session.
@@ -76,6 +89,7 @@ func NewManager(cfg Config) *Manager {
 	m := &Manager{
 		sessions: make(map[string]*Session),
 		config:   cfg,
+		stop:     make(chan struct{}),
 	}
 
 	// Start cleanup goroutine
@@ -89,8 +103,13 @@ func (m *Manager) cleanupLoop() {
 	ticker := time.NewTicker(m.config.CleanupTicker)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		m.cleanupExpired()
+	for {
+		select {
+		case <-ticker.C:
+			m.cleanupExpired()
+		case <-m.stop:
+			return
+		}
 	}
 }
 
@@ -112,12 +131,12 @@ func (m *Manager) cleanupExpired() {
 		// 1. Session exceeds max lifetime (safety net), OR
 		// 2. Session has been idle too long (no packets received)
 		if sessionAge > m.config.MaxSessionTime {
-			log.Printf("Cleaning up session %s: exceeded max lifetime (%v)", id, sessionAge)
+			logger.Info("Cleaning up session: exceeded max lifetime", "testId", id, "age", sessionAge)
 			session.Close()
 			delete(m.sessions, id)
 		} else if idleTime > m.config.IdleTimeout {
-			log.Printf("Cleaning up session %s: idle timeout (%v since last activity, received %d packets)",
-				id, idleTime, session.Stats.TotalRecv)
+			logger.Info("Cleaning up session: idle timeout",
+				"testId", id, "idle", idleTime, "received", session.Stats.TotalRecv)
 			session.Close()
 			delete(m.sessions, id)
 		}
@@ -131,11 +150,24 @@ func (m *Manager) SetICEServers(servers []webrtc.ICEServer) {
 	m.config.ICEServers = servers
 }
 
-// HandleOffer processes an SDP offer and returns an answer.
-func (m *Manager) HandleOffer(offerSDP string, testProfile string) (answerSDP string, testID string, err error) {
+// HandleOffer processes an SDP offer and returns an answer. ctx carries
+// the trace of the signaling request the session spans are parented to.
+func (m *Manager) HandleOffer(ctx context.Context, offerSDP string, testProfile string) (answerSDP string, testID string, err error) {
 	// Generate test ID
 	testID = uuid.New().String()
 
+	ctx, span := tracing.Tracer().Start(ctx, "webrtc.HandleOffer", trace.WithAttributes(
+		tracing.TestID(testID),
+		attribute.String("netspeed.test_profile", testProfile),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
 	// Create peer connection configuration
 	config := webrtc.Configuration{
 		ICEServers: m.config.ICEServers,
@@ -147,8 +179,9 @@ func (m *Manager) HandleOffer(offerSDP string, testProfile string) (answerSDP st
 		return "", "", fmt.Errorf("failed to create peer connection: %w", err)
 	}
 
-	// Create session
+	// Create session; its span outlives the signaling request
 	now := time.Now()
+	_, sessionSpan := tracing.Tracer().Start(ctx, "webrtc.session", trace.WithAttributes(tracing.TestID(testID)))
 	session := &Session{
 		ID:             testID,
 		PeerConnection: peerConnection,
@@ -159,11 +192,13 @@ func (m *Manager) HandleOffer(offerSDP string, testProfile string) (answerSDP st
 			StartTime: now,
 		},
 		done: make(chan struct{}),
+		span: sessionSpan,
 	}
 
 	// Set up connection state handler
 	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
-		log.Printf("Session %s: connection state changed to %s", testID, state.String())
+		logger.Info("Connection state changed", "testId", testID, "state", state.String())
+		sessionSpan.AddEvent("connection_state", trace.WithAttributes(attribute.String("state", state.String())))
 		if state == webrtc.PeerConnectionStateFailed ||
 			state == webrtc.PeerConnectionStateClosed ||
 			state == webrtc.PeerConnectionStateDisconnected {
@@ -173,12 +208,18 @@ func (m *Manager) HandleOffer(offerSDP string, testProfile string) (answerSDP st
 
 	// Set up ICE connection state handler
 	peerConnection.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
-		log.Printf("Session %s: ICE connection state changed to %s", testID, state.String())
+		logger.Debug("ICE connection state changed", "testId", testID, "state", state.String())
+		sessionSpan.AddEvent("ice_connection_state", trace.WithAttributes(attribute.String("state", state.String())))
+	})
+
+	peerConnection.OnICEGatheringStateChange(func(state webrtc.ICEGathererState) {
+		sessionSpan.AddEvent("ice_gathering_state", trace.WithAttributes(attribute.String("state", state.String())))
 	})
 
 	// Set up data channel handler
 	peerConnection.OnDataChannel(func(dc *webrtc.DataChannel) {
-		log.Printf("Session %s: data channel opened: %s", testID, dc.Label())
+		logger.Debug("Data channel opened", "testId", testID, "label", dc.Label())
+		sessionSpan.AddEvent("data_channel", trace.WithAttributes(attribute.String("label", dc.Label())))
 
 		if dc.Label() == "packet-loss" {
 			session.DataChannel = dc
@@ -194,30 +235,34 @@ func (m *Manager) HandleOffer(offerSDP string, testProfile string) (answerSDP st
 
 	// Set the remote description
 	if err := peerConnection.SetRemoteDescription(offer); err != nil {
-		peerConnection.Close()
+		session.Close()
 		return "", "", fmt.Errorf("failed to set remote description: %w", err)
 	}
 
 	// Create answer
 	answer, err := peerConnection.CreateAnswer(nil)
 	if err != nil {
-		peerConnection.Close()
+		session.Close()
 		return "", "", fmt.Errorf("failed to create answer: %w", err)
 	}
 
 	// Set the local description
 	if err := peerConnection.SetLocalDescription(answer); err != nil {
-		peerConnection.Close()
+		session.Close()
 		return "", "", fmt.Errorf("failed to set local description: %w", err)
 	}
 
 	// Wait for ICE gathering to complete
+	_, gatherSpan := tracing.Tracer().Start(ctx, "webrtc.ice_gathering", trace.WithAttributes(tracing.TestID(testID)))
 	gatherComplete := webrtc.GatheringCompletePromise(peerConnection)
 	select {
 	case <-gatherComplete:
 		// ICE gathering complete
+		gatherSpan.End()
 	case <-time.After(10 * time.Second):
-		peerConnection.Close()
+		gatherSpan.SetStatus(codes.Error, "timeout")
+		gatherSpan.End()
+		session.Close()
 		return "", "", fmt.Errorf("ICE gathering timeout")
 	}
 
@@ -225,6 +270,7 @@ func (m *Manager) HandleOffer(offerSDP string, testProfile string) (answerSDP st
 	m.mu.Lock()
 	m.sessions[testID] = session
 	m.mu.Unlock()
+	metrics.WebRTCSessionCreated()
 
 	// Return the answer SDP
 	return peerConnection.LocalDescription().SDP, testID, nil
@@ -233,7 +279,7 @@ func (m *Manager) HandleOffer(offerSDP string, testProfile string) (answerSDP st
 // setupPacketLossChannel sets up handlers for the packet-loss data channel.
 func (m *Manager) setupPacketLossChannel(session *Session, dc *webrtc.DataChannel) {
 	dc.OnOpen(func() {
-		log.Printf("Session %s: packet-loss channel opened", session.ID)
+		logger.Info("Packet-loss channel opened", "testId", session.ID)
 		now := time.Now()
 		session.Stats.StartTime = now
 		// Update last activity when data channel opens
@@ -243,15 +289,14 @@ func (m *Manager) setupPacketLossChannel(session *Session, dc *webrtc.DataChanne
 	})
 
 	dc.OnClose(func() {
-		log.Printf("Session %s: packet-loss channel closed, received %d packets",
-			session.ID, session.Stats.TotalRecv)
+		logger.Info("Packet-loss channel closed", "testId", session.ID, "received", session.Stats.TotalRecv)
 	})
 
 	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
 		// Parse the packet message
 		var pkt PacketMessage
 		if err := json.Unmarshal(msg.Data, &pkt); err != nil {
-			log.Printf("Session %s: failed to parse packet: %v", session.ID, err)
+			logger.Debug("Failed to parse packet", "testId", session.ID, "error", err)
 			return
 		}
 
@@ -277,17 +322,17 @@ func (m *Manager) setupPacketLossChannel(session *Session, dc *webrtc.DataChanne
 		}
 		ackData, err := json.Marshal(ack)
 		if err != nil {
-			log.Printf("Session %s: failed to marshal ack: %v", session.ID, err)
+			logger.Warn("Failed to marshal ack", "testId", session.ID, "error", err)
 			return
 		}
 
 		if err := dc.Send(ackData); err != nil {
-			log.Printf("Session %s: failed to send ack: %v", session.ID, err)
+			logger.Debug("Failed to send ack", "testId", session.ID, "error", err)
 		}
 	})
 
 	dc.OnError(func(err error) {
-		log.Printf("Session %s: data channel error: %v", session.ID, err)
+		logger.Warn("Data channel error", "testId", session.ID, "error", err)
 	})
 }
 
@@ -299,6 +344,13 @@ func (m *Manager) GetSession(testID string) (*Session, bool) {
 	return session, ok
 }
 
+// SessionCount returns the number of open sessions.
+func (m *Manager) SessionCount() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.sessions)
+}
+
 // CloseSession closes and removes a session.
 func (m *Manager) CloseSession(testID string) {
 	m.mu.Lock()
@@ -326,6 +378,13 @@ func (s *Session) Close() {
 	if s.PeerConnection != nil {
 		s.PeerConnection.Close()
 	}
+	if s.span != nil {
+		s.Stats.mu.Lock()
+		recv := s.Stats.TotalRecv
+		s.Stats.mu.Unlock()
+		s.span.SetAttributes(attribute.Int("netspeed.packets_received", recv))
+		s.span.End()
+	}
 }
 
 // GetStats returns the current session statistics.
@@ -335,8 +394,10 @@ func (s *Session) GetStats() (totalRecv int, lastSeq int, duration time.Duration
 	return s.Stats.TotalRecv, s.Stats.LastSeq, time.Since(s.Stats.StartTime)
 }
 
-// Shutdown closes the manager and all active sessions.
+// Shutdown closes the manager and all active sessions, and stops the
+// cleanup goroutine. It is safe to call more than once.
 func (m *Manager) Shutdown() {
+	m.stopOnce.Do(func() { close(m.stop) })
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
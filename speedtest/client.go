@@ -0,0 +1,365 @@
+// Package speedtest is a Go client for netspeedd. It runs the browser speed
+// test from Go: the same latency probes, download and upload ladders,
+// loaded-latency runs and WebRTC packet-loss test as web/js/speedtest.js,
+// against the same endpoints.
+//
+// Client wraps one node. Its methods are the building blocks of a test,
+// each cancelled by its context and reporting progress through an optional
+// callback: LatencyProbe and UnloadedLatency, Download and Upload,
+// LoadedLatency and PacketLoss. Run strings them together into a full test
+// and computes the summary the web UI shows; RunTimed runs one direction,
+// or latency alone, for a fixed time instead:
+//
+//	c, err := speedtest.NewClient("https://speed.example.com", nil)
+//	if err != nil {
+//		return err
+//	}
+//	res, err := c.Run(ctx, speedtest.DefaultConfig())
+//	if err != nil {
+//		return err
+//	}
+//	fmt.Printf("%.0f Mbps down\n", res.Summary.DownloadMbps)
+//
+// The request and response bodies are the types of package api.
+package speedtest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yellowman/netspeed/api"
+)
+
+// StatusError is returned when a node answers with an unexpected HTTP
+// status.
+type StatusError struct {
+	Method string
+	Path   string
+	// StatusCode and Status are those of the response
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Method, e.Path, e.Status)
+}
+
+func statusError(req *http.Request, resp *http.Response) error {
+	return &StatusError{Method: req.Method, Path: req.URL.Path, StatusCode: resp.StatusCode, Status: resp.Status}
+}
+
+// Client talks to one netspeedd node.
+type Client struct {
+	base *url.URL
+	http *http.Client
+
+	// token is the test token for the measurement endpoints, fetched on
+	// first use; noToken records that the node does not issue them
+	mu      sync.Mutex
+	token   string
+	noToken bool
+}
+
+// NewClient returns a Client for the node at baseURL, e.g.
+// "https://speed.example.com". If httpClient is nil, a client that keeps
+// enough idle connections for the parallel phases of a test is used.
+func NewClient(baseURL string, httpClient *http.Client) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid server URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid server URL %q (want http(s)://host[:port])", baseURL)
+	}
+	if httpClient == nil {
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.MaxIdleConnsPerHost = 8
+		// Compression would skew the throughput numbers
+		tr.DisableCompression = true
+		httpClient = &http.Client{Transport: tr}
+	}
+	return &Client{base: u, http: httpClient}, nil
+}
+
+// URL returns the node's base URL.
+func (c *Client) URL() string {
+	return c.base.String()
+}
+
+// endpoint returns the absolute URL of path with query.
+func (c *Client) endpoint(path string, query url.Values) string {
+	u := *c.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// doJSON sends a request to path with in, if not nil, as its JSON body,
+// and decodes a 2xx response into out, if not nil.
+func (c *Client) doJSON(ctx context.Context, method, path string, in, out any) error {
+	return c.sendJSON(ctx, c.http.Do, method, path, in, out)
+}
+
+// measureJSON is doJSON for a measurement endpoint, which needs the test
+// token.
+func (c *Client) measureJSON(ctx context.Context, method, path string, in, out any) error {
+	do := func(req *http.Request) (*http.Response, error) {
+		// measure may send it twice, so each attempt gets a fresh body
+		return c.measure(ctx, func() (*http.Request, error) {
+			r := req.Clone(ctx)
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				r.Body = body
+			}
+			return r, nil
+		})
+	}
+	return c.sendJSON(ctx, do, method, path, in, out)
+}
+
+func (c *Client) sendJSON(ctx context.Context, do func(*http.Request) (*http.Response, error), method, path string, in, out any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint(path, nil), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return statusError(req, resp)
+	}
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// Meta returns what the node knows about this client (GET /meta).
+func (c *Client) Meta(ctx context.Context) (*api.ClientMeta, error) {
+	var m api.ClientMeta
+	if err := c.doJSON(ctx, http.MethodGet, "/meta", nil, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Locations returns the node's test locations (GET /locations).
+func (c *Client) Locations(ctx context.Context) ([]api.Location, error) {
+	var locs []api.Location
+	if err := c.doJSON(ctx, http.MethodGet, "/locations", nil, &locs); err != nil {
+		return nil, err
+	}
+	return locs, nil
+}
+
+// MeshNode returns the node's latest measurements to its peers (GET
+// /api/mesh/node).
+func (c *Client) MeshNode(ctx context.Context) (*api.MeshNode, error) {
+	var n api.MeshNode
+	if err := c.doJSON(ctx, http.MethodGet, "/api/mesh/node", nil, &n); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// TurnCredentials returns short-lived credentials for the node's TURN
+// servers (GET /api/turn/credentials). A node without TURN answers with a
+// StatusError for 503.
+func (c *Client) TurnCredentials(ctx context.Context) (*api.TurnCredentialsResponse, error) {
+	var creds api.TurnCredentialsResponse
+	if err := c.doJSON(ctx, http.MethodGet, "/api/turn/credentials", nil, &creds); err != nil {
+		return nil, err
+	}
+	return &creds, nil
+}
+
+// OfferPacketTest sends a WebRTC offer for the packet-loss data channel and
+// returns the node's answer (POST /api/packet-test/offer). PacketLoss does
+// the whole exchange; this is for callers with their own WebRTC stack.
+func (c *Client) OfferPacketTest(ctx context.Context, offer api.PacketTestOfferRequest) (*api.PacketTestOfferResponse, error) {
+	var answer api.PacketTestOfferResponse
+	if err := c.measureJSON(ctx, http.MethodPost, "/api/packet-test/offer", offer, &answer); err != nil {
+		return nil, err
+	}
+	return &answer, nil
+}
+
+// ReportPacketTest sends the outcome of a packet-loss test to the node for
+// its logs (POST /api/packet-test/report).
+func (c *Client) ReportPacketTest(ctx context.Context, report api.PacketTestReportRequest) error {
+	return c.doJSON(ctx, http.MethodPost, "/api/packet-test/report", report, nil)
+}
+
+// PushResult stores a result on the node under the monitoring agent that
+// key belongs to (POST /api/results/ingest). Pushing the same ID again
+// returns the result stored the first time, with Duplicate set.
+func (c *Client) PushResult(ctx context.Context, key string, push api.IngestRequest) (*api.IngestResponse, error) {
+	var resp api.IngestResponse
+	if err := c.sendJSON(ctx, c.withKey(key), http.MethodPost, "/api/results/ingest", push, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Heartbeat tells the node that the monitoring agent key belongs to is up
+// (POST /api/agents/heartbeat).
+func (c *Client) Heartbeat(ctx context.Context, key string, hb api.AgentHeartbeat) error {
+	return c.sendJSON(ctx, c.withKey(key), http.MethodPost, "/api/agents/heartbeat", hb, nil)
+}
+
+// NextJob returns the next job the node has for the monitoring agent key
+// belongs to, waiting up to wait for one (GET /api/agents/jobs). It returns
+// nil if none came.
+func (c *Client) NextJob(ctx context.Context, key string, wait time.Duration) (*api.AgentJob, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.endpoint("/api/agents/jobs", url.Values{"wait": {wait.String()}}), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.withKey(key)(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return nil, nil
+	default:
+		return nil, statusError(req, resp)
+	}
+	var job api.AgentJob
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		return nil, fmt.Errorf("GET /api/agents/jobs: %w", err)
+	}
+	return &job, nil
+}
+
+// ReportJob tells the node that the agent started job id, or that it
+// failed (POST /api/agents/jobs/{id}/status).
+func (c *Client) ReportJob(ctx context.Context, key, id string, rep api.JobReport) error {
+	return c.sendJSON(ctx, c.withKey(key), http.MethodPost, "/api/agents/jobs/"+url.PathEscape(id)+"/status", rep, nil)
+}
+
+// withKey returns a function that sends requests with an agent key.
+func (c *Client) withKey(key string) func(*http.Request) (*http.Response, error) {
+	return func(req *http.Request) (*http.Response, error) {
+		req.Header.Set("Authorization", "Bearer "+key)
+		return c.http.Do(req)
+	}
+}
+
+// TestToken returns the token the client uses on the measurement
+// endpoints, fetching one from POST /api/tests if need be, or "" if the
+// node does not require them.
+func (c *Client) TestToken(ctx context.Context) (string, error) {
+	return c.testToken(ctx)
+}
+
+// testToken returns the token for the measurement endpoints, or "" if the
+// node does not require one.
+func (c *Client) testToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" || c.noToken {
+		return c.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint("/api/tests", nil), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		c.noToken = true
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get test token: %w", statusError(req, resp))
+	}
+	var body api.TestTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to get test token: %w", err)
+	}
+	c.token = body.Token
+	return c.token, nil
+}
+
+// dropToken forgets token so the next request fetches a new one, unless
+// another request already has.
+func (c *Client) dropToken(token string) {
+	c.mu.Lock()
+	if c.token == token {
+		c.token = ""
+	}
+	c.mu.Unlock()
+}
+
+// measure sends a request to a measurement endpoint with the test token.
+// On a 401 (token expired or its budget spent) it gets a fresh token and
+// retries once; newReq is called again for the retry.
+func (c *Client) measure(ctx context.Context, newReq func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		token, err := c.testToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req, err := newReq()
+		if err != nil {
+			return nil, err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := c.http.Do(req)
+		if err != nil || resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		c.dropToken(token)
+	}
+}
+
+// newMeasID returns an ID in the format the web UI uses, so server logs
+// look the same for both.
+func newMeasID() string {
+	const alphabet = "0123456789abcdefghijklmnopqrstuvwxyz"
+	b := make([]byte, 9)
+	rand.Read(b)
+	for i := range b {
+		b[i] = alphabet[int(b[i])%len(alphabet)]
+	}
+	return fmt.Sprintf("%d-%s", time.Now().UnixMilli(), b)
+}
@@ -0,0 +1,186 @@
+package speedtest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LatencyProbe measures one round trip with an empty /__down request, from
+// the request being written to the first byte of the response. phase and
+// seq are passed on for the server's logs.
+func (c *Client) LatencyProbe(ctx context.Context, phase string, seq int) (LatencySample, error) {
+	q := url.Values{
+		"bytes":  {"0"},
+		"measId": {newMeasID()},
+		"during": {phase},
+		"seq":    {strconv.Itoa(seq)},
+	}
+	var t timing
+	start := time.Now()
+	resp, err := c.measure(ctx, func() (*http.Request, error) {
+		return http.NewRequestWithContext(t.trace(ctx), http.MethodGet, c.endpoint("/__down", q), nil)
+	})
+	if err != nil {
+		return LatencySample{}, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	end := time.Now()
+	if resp.StatusCode != http.StatusOK {
+		return LatencySample{}, fmt.Errorf("latency probe failed: %w", statusError(resp.Request, resp))
+	}
+
+	rtt := end.Sub(start)
+	if !t.wrote.IsZero() && t.firstByte.After(t.wrote) {
+		rtt = t.firstByte.Sub(t.wrote)
+	}
+	return LatencySample{TS: end.UnixMilli(), RTTMs: ms(rtt), Phase: phase}, nil
+}
+
+// UnloadedLatency runs probes latency probes on an idle connection and
+// calls progress, if set, with each sample. The first three run one at a
+// time; if they show a fast connection, or a slow one with enough bandwidth
+// that parallel probes will not queue behind each other, the rest run in
+// batches of five. Probes that fail are skipped; it is an error only if
+// none succeed.
+func (c *Client) UnloadedLatency(ctx context.Context, probes int, progress func(LatencySample)) ([]LatencySample, error) {
+	const (
+		initialProbes    = 3
+		batchSize        = 5
+		highLatencyMs    = 100
+		minBandwidthMbps = 2
+	)
+	var mu sync.Mutex
+	var samples []LatencySample
+	var lastErr error
+	probe := func(seq int) {
+		s, err := c.LatencyProbe(ctx, PhaseUnloaded, seq)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			lastErr = err
+			return
+		}
+		samples = append(samples, s)
+		if progress != nil {
+			progress(s)
+		}
+	}
+
+	seq := 0
+	for ; seq < min(initialProbes, probes) && ctx.Err() == nil; seq++ {
+		probe(seq)
+	}
+	if err := ctx.Err(); err != nil {
+		return samples, err
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("latency probes failed: %w", lastErr)
+	}
+
+	parallel := true
+	rtts := make([]float64, len(samples))
+	for i, s := range samples {
+		rtts[i] = s.RTTMs
+	}
+	slices.Sort(rtts)
+	if rtts[len(rtts)/2] >= highLatencyMs {
+		// High latency: satellite links can take parallel probes, slow
+		// DSL cannot
+		s, err := c.Download(ctx, Transfer{Bytes: 100e3, Profile: "bw-check"})
+		parallel = err == nil && s.Mbps >= minBandwidthMbps
+	}
+
+	for seq < probes && ctx.Err() == nil {
+		if !parallel {
+			probe(seq)
+			seq++
+			continue
+		}
+		var wg sync.WaitGroup
+		for end := min(seq+batchSize, probes); seq < end; seq++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				probe(seq)
+			}()
+		}
+		wg.Wait()
+	}
+	return samples, ctx.Err()
+}
+
+// LoadedLatencyConfig sets up a loaded latency run.
+type LoadedLatencyConfig struct {
+	// Direction is Download or Upload
+	Direction string
+	// Profile is the transfer that loads the line; it should be big enough
+	// to last until the last probe
+	Profile Profile
+	Probes  int
+	// Interval is the time between probes (default 200ms)
+	Interval time.Duration
+	// Progress, if set, is called with each sample, possibly from several
+	// goroutines at once
+	Progress func(LatencySample)
+}
+
+// LoadedLatency probes latency while a download or upload is running, one
+// probe every cfg.Interval from the start of the transfer. The transfer
+// itself is not measured. Probes that fail are skipped.
+func (c *Client) LoadedLatency(ctx context.Context, cfg LoadedLatencyConfig) ([]LatencySample, error) {
+	if cfg.Direction != Download && cfg.Direction != Upload {
+		return nil, fmt.Errorf("invalid direction %q", cfg.Direction)
+	}
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = 200 * time.Millisecond
+	}
+	t := Transfer{Bytes: cfg.Profile.Bytes, Profile: cfg.Profile.Name, During: cfg.Direction}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if cfg.Direction == Download {
+			c.Download(ctx, t)
+		} else {
+			c.Upload(ctx, t)
+		}
+	}()
+
+	var mu sync.Mutex
+	var samples []LatencySample
+	for seq := range cfg.Probes {
+		select {
+		case <-ctx.Done():
+		case <-time.After(interval):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, err := c.LatencyProbe(ctx, cfg.Direction, seq)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			samples = append(samples, s)
+			mu.Unlock()
+			if cfg.Progress != nil {
+				cfg.Progress(s)
+			}
+		}()
+	}
+	wg.Wait()
+	return samples, ctx.Err()
+}
@@ -0,0 +1,345 @@
+package speedtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/yellowman/netspeed/api"
+)
+
+// RTTStats summarizes the round trips of the packet-loss test.
+type RTTStats struct {
+	Min    float64 `json:"min"`
+	Median float64 `json:"median"`
+	P90    float64 `json:"p90"`
+}
+
+// PacketLossResult is the outcome of the packet-loss test. When the test
+// could not be run, or the connection broke rather than dropped packets,
+// Unavailable is set with a Reason and the numbers are not meaningful.
+type PacketLossResult struct {
+	Sent        int      `json:"sent"`
+	Received    int      `json:"received"`
+	LossPercent float64  `json:"lossPercent"`
+	RTTStatsMs  RTTStats `json:"rttStatsMs"`
+	JitterMs    float64  `json:"jitterMs"`
+	TestID      string   `json:"testId,omitempty"`
+	Unavailable bool     `json:"unavailable,omitempty"`
+	Reason      string   `json:"reason,omitempty"`
+}
+
+// PacketLossConfig sets how many packets the test sends and how fast.
+type PacketLossConfig struct {
+	Packets int
+	// Interval is the time between packets
+	Interval time.Duration
+	// ExtraWait is how long to wait for late acks after the last packet
+	ExtraWait time.Duration
+	// Progress, if set, is called after each packet with the number sent
+	// and acked so far
+	Progress func(sent, acked int)
+}
+
+// packetMessage and ackMessage are what goes over the data channel.
+type packetMessage struct {
+	Seq    int   `json:"seq"`
+	SentAt int64 `json:"sentAt"`
+	Size   int   `json:"size"`
+}
+
+type ackMessage struct {
+	Ack        *int  `json:"ack"`
+	ReceivedAt int64 `json:"receivedAt"`
+	SentAt     int64 `json:"sentAt"`
+}
+
+// unavailable is the result of a packet-loss test that could not be run.
+func unavailable(reason string) *PacketLossResult {
+	return &PacketLossResult{Unavailable: true, Reason: reason}
+}
+
+// PacketLoss runs the WebRTC packet-loss test: it opens an unordered,
+// unreliable data channel to the node (through its TURN servers if need
+// be), sends cfg.Packets packets and counts the acks. Failing to connect
+// is reported in the result rather than as an error; the error is only
+// set when ctx is done.
+func (c *Client) PacketLoss(ctx context.Context, cfg PacketLossConfig) (*PacketLossResult, error) {
+	creds, err := c.TurnCredentials(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return unavailable("TURN server not configured"), nil
+	}
+
+	var iceServers []webrtc.ICEServer
+	var stun, turn []string
+	for _, s := range creds.Servers {
+		switch {
+		case strings.HasPrefix(s, "stun:"), strings.HasPrefix(s, "stuns:"):
+			stun = append(stun, s)
+		case strings.HasPrefix(s, "turn:"), strings.HasPrefix(s, "turns:"):
+			turn = append(turn, s)
+		}
+	}
+	if len(stun) > 0 {
+		iceServers = append(iceServers, webrtc.ICEServer{URLs: stun})
+	}
+	if len(turn) > 0 {
+		iceServers = append(iceServers, webrtc.ICEServer{
+			URLs:       turn,
+			Username:   creds.Username,
+			Credential: creds.Credential,
+		})
+	}
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{ICEServers: iceServers})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create peer connection: %w", err)
+	}
+	defer pc.Close()
+
+	ordered := false
+	maxRetransmits := uint16(0)
+	dc, err := pc.CreateDataChannel("packet-loss", &webrtc.DataChannelInit{
+		Ordered:        &ordered,
+		MaxRetransmits: &maxRetransmits,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create data channel: %w", err)
+	}
+
+	waitOpen := watchOpen(pc, dc)
+	testID, reason, err := c.signal(ctx, pc)
+	if err != nil {
+		return nil, err
+	}
+	if reason != "" {
+		return unavailable(reason), nil
+	}
+
+	if reason, err := waitOpen(ctx); err != nil {
+		return nil, err
+	} else if reason != "" {
+		return unavailable(reason), nil
+	}
+
+	var mu sync.Mutex
+	acks := make(map[int]bool)
+	var rtts []float64
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		var ack ackMessage
+		if json.Unmarshal(msg.Data, &ack) != nil || ack.Ack == nil || ack.ReceivedAt == 0 {
+			return
+		}
+		mu.Lock()
+		acks[*ack.Ack] = true
+		if ack.SentAt != 0 {
+			rtts = append(rtts, float64(time.Now().UnixMilli()-ack.SentAt))
+		}
+		mu.Unlock()
+	})
+
+	ticker := time.NewTicker(cfg.Interval)
+	for seq := 0; seq < cfg.Packets; seq++ {
+		select {
+		case <-ctx.Done():
+			ticker.Stop()
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+		data, _ := json.Marshal(packetMessage{Seq: seq, SentAt: time.Now().UnixMilli(), Size: 1200})
+		// The channel may have closed; the missing acks will show it
+		dc.Send(data)
+		if cfg.Progress != nil {
+			mu.Lock()
+			acked := len(acks)
+			mu.Unlock()
+			cfg.Progress(seq+1, acked)
+		}
+	}
+	ticker.Stop()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(cfg.ExtraWait):
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	res := lossResult(cfg.Packets, acks, rtts)
+	if !res.Unavailable {
+		res.TestID = testID
+		c.reportPacketLoss(ctx, res)
+	}
+	return res, nil
+}
+
+// signal gathers ICE candidates and exchanges the offer and answer with the
+// node. A non-empty reason means the test cannot go ahead.
+func (c *Client) signal(ctx context.Context, pc *webrtc.PeerConnection) (testID, reason string, err error) {
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create offer: %w", err)
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		return "", "", fmt.Errorf("failed to set local description: %w", err)
+	}
+	select {
+	case <-gathered:
+	case <-ctx.Done():
+		return "", "", ctx.Err()
+	case <-time.After(10 * time.Second):
+		return "", "ICE gathering timeout", nil
+	}
+
+	answer, err := c.OfferPacketTest(ctx, api.PacketTestOfferRequest{
+		SDP:         pc.LocalDescription().SDP,
+		Type:        pc.LocalDescription().Type.String(),
+		TestProfile: "loss-basic",
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", "", ctx.Err()
+		}
+		return "", "Server rejected connection", nil
+	}
+	err = pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer.SDP})
+	if err != nil {
+		return "", "Server rejected connection", nil
+	}
+	return answer.TestID, "", nil
+}
+
+// watchOpen starts watching the connection, before signaling so no event
+// is missed, and returns a function that waits up to 15s for the data
+// channel to open. A connection that fails or stays disconnected for 2s
+// gives up early.
+func watchOpen(pc *webrtc.PeerConnection, dc *webrtc.DataChannel) func(ctx context.Context) (reason string, err error) {
+	opened := make(chan struct{})
+	failed := make(chan string, 1)
+	dc.OnOpen(func() { close(opened) })
+	pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		switch state {
+		case webrtc.ICEConnectionStateFailed:
+			select {
+			case failed <- "ICE connection failed":
+			default:
+			}
+		case webrtc.ICEConnectionStateDisconnected:
+			time.AfterFunc(2*time.Second, func() {
+				if s := pc.ICEConnectionState(); s == webrtc.ICEConnectionStateDisconnected || s == webrtc.ICEConnectionStateFailed {
+					select {
+					case failed <- "ICE connection disconnected":
+					default:
+					}
+				}
+			})
+		}
+	})
+
+	return func(ctx context.Context) (string, error) {
+		select {
+		case <-opened:
+			return "", nil
+		case reason := <-failed:
+			return reason, nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(15 * time.Second):
+			return "ICE connection timeout", nil
+		}
+	}
+}
+
+// lossResult computes the result from the acked sequence numbers and round
+// trips. Like the web UI, it reports a connection that died mid-test or
+// lost more than half the packets as unavailable, since that is not loss
+// in the usual sense.
+func lossResult(sent int, acks map[int]bool, rtts []float64) *PacketLossResult {
+	received := len(acks)
+	lossPercent := float64(sent-received) / float64(sent) * 100
+
+	if received == 0 {
+		r := unavailable("No responses received - connection failed")
+		r.Sent = sent
+		return r
+	}
+	if lossPercent > 10 {
+		late := sent * 8 / 10
+		var earlyAcks, lateAcks, maxSeq int
+		for seq := range acks {
+			if seq >= late {
+				lateAcks++
+			} else {
+				earlyAcks++
+			}
+			maxSeq = max(maxSeq, seq)
+		}
+		earlyPercent := float64(earlyAcks) / float64(late) * 100
+		latePercent := float64(lateAcks) / float64(sent-late) * 100
+		var reason string
+		if earlyPercent > 80 && latePercent < 50 {
+			reason = fmt.Sprintf("Connection died mid-test - last response at packet %d/%d", maxSeq, sent)
+		} else if lossPercent > 50 {
+			reason = fmt.Sprintf("Connection unstable - received only %d/%d responses", received, sent)
+		}
+		if reason != "" {
+			r := unavailable(reason)
+			r.Sent, r.Received = sent, received
+			return r
+		}
+	}
+
+	res := &PacketLossResult{Sent: sent, Received: received, LossPercent: lossPercent}
+	if len(rtts) > 0 {
+		slices.Sort(rtts)
+		res.RTTStatsMs = RTTStats{
+			Min:    rtts[0],
+			Median: rtts[len(rtts)/2],
+			P90:    rtts[len(rtts)*9/10],
+		}
+	}
+	if len(rtts) > 1 {
+		var mean float64
+		for _, v := range rtts {
+			mean += v
+		}
+		mean /= float64(len(rtts))
+		for _, v := range rtts {
+			res.JitterMs += abs(v - mean)
+		}
+		res.JitterMs /= float64(len(rtts))
+	}
+	return res
+}
+
+func abs(v float64) float64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// reportPacketLoss sends the result to the node for its logs. Failures are
+// ignored, as in the web UI.
+func (c *Client) reportPacketLoss(ctx context.Context, res *PacketLossResult) {
+	c.ReportPacketTest(ctx, api.PacketTestReportRequest{
+		TestID:      res.TestID,
+		Sent:        res.Sent,
+		Received:    res.Received,
+		LossPercent: res.LossPercent,
+		RTTMin:      res.RTTStatsMs.Min,
+		RTTMedian:   res.RTTStatsMs.Median,
+		RTTP90:      res.RTTStatsMs.P90,
+		JitterMs:    res.JitterMs,
+	})
+}
@@ -0,0 +1,416 @@
+package speedtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/yellowman/netspeed/api"
+)
+
+// Profile is a transfer size and how many times it is run.
+type Profile struct {
+	Name  string
+	Bytes int64
+	Runs  int
+}
+
+// DownloadProfiles and UploadProfiles are the transfer ladders of the web
+// UI, smallest first. The first two are the baselines every test runs; the
+// rest are picked by the speed the baselines measured.
+var (
+	DownloadProfiles = []Profile{
+		{"100kB", 100e3, 10},
+		{"1MB", 1e6, 8},
+		{"10MB", 10e6, 6},
+		{"25MB", 25e6, 4},
+		{"100MB", 100e6, 3},
+		{"250MB", 250e6, 2},
+		{"500MB", 500e6, 2},
+		{"1GB", 1e9, 2},
+		{"2GB", 2e9, 2},
+		{"5GB", 5e9, 2},
+		{"12GB", 12e9, 2},
+		{"50GB", 50e9, 2},
+		{"100GB", 100e9, 2},
+		{"125GB", 125e9, 2},
+	}
+	UploadProfiles = []Profile{
+		{"100kB", 100e3, 8},
+		{"1MB", 1e6, 6},
+		{"10MB", 10e6, 4},
+		{"25MB", 25e6, 4},
+		{"50MB", 50e6, 3},
+		{"100MB", 100e6, 2},
+		{"250MB", 250e6, 2},
+		{"500MB", 500e6, 2},
+		{"1GB", 1e9, 2},
+		{"2GB", 2e9, 2},
+		{"5GB", 5e9, 2},
+		{"12GB", 12e9, 2},
+		{"50GB", 50e9, 2},
+		{"100GB", 100e9, 2},
+		{"125GB", 125e9, 2},
+	}
+)
+
+// baselineProfiles is how many profiles at the start of each ladder always
+// run.
+const baselineProfiles = 2
+
+// Stages of a test, in the order Run goes through them.
+const (
+	StageMeta          = "meta"
+	StageLatency       = "latency"
+	StageWarmup        = "warmup"
+	StageDownload      = "download"
+	StageUpload        = "upload"
+	StageLoadedLatency = "loaded-latency"
+	StagePacketLoss    = "packet-loss"
+)
+
+// Progress receives events while Run is going. Any of the callbacks may be
+// nil. Latency may be called from several goroutines at once.
+type Progress struct {
+	Stage      func(stage string)
+	Throughput func(s ThroughputSample)
+	Latency    func(s LatencySample)
+	PacketLoss func(sent, acked int)
+}
+
+// Config tunes Run. The zero value of a field means its default from
+// DefaultConfig.
+type Config struct {
+	// LatencyProbes is the number of unloaded latency probes
+	LatencyProbes int
+	// LoadedLatencyProbes is the number of probes during each of the
+	// loaded download and upload
+	LoadedLatencyProbes int
+	// MaxTransferTime is the longest a single transfer may be expected to
+	// take for its profile to be picked
+	MaxTransferTime time.Duration
+	// DownloadBudget and UploadBudget bound the time spent on the profiles
+	// beyond the baselines
+	DownloadBudget time.Duration
+	UploadBudget   time.Duration
+	// SkipPacketLoss leaves out the WebRTC packet-loss test
+	SkipPacketLoss bool
+	PacketLoss     PacketLossConfig
+	Progress       Progress
+}
+
+// DefaultConfig returns the settings of the web UI.
+func DefaultConfig() Config {
+	return Config{
+		LatencyProbes:       20,
+		LoadedLatencyProbes: 5,
+		MaxTransferTime:     4 * time.Second,
+		DownloadBudget:      8 * time.Second,
+		UploadBudget:        8 * time.Second,
+		PacketLoss: PacketLossConfig{
+			Packets:   1000,
+			Interval:  10 * time.Millisecond,
+			ExtraWait: 3 * time.Second,
+		},
+	}
+}
+
+func (cfg Config) withDefaults() Config {
+	def := DefaultConfig()
+	if cfg.LatencyProbes <= 0 {
+		cfg.LatencyProbes = def.LatencyProbes
+	}
+	if cfg.LoadedLatencyProbes <= 0 {
+		cfg.LoadedLatencyProbes = def.LoadedLatencyProbes
+	}
+	if cfg.MaxTransferTime <= 0 {
+		cfg.MaxTransferTime = def.MaxTransferTime
+	}
+	if cfg.DownloadBudget <= 0 {
+		cfg.DownloadBudget = def.DownloadBudget
+	}
+	if cfg.UploadBudget <= 0 {
+		cfg.UploadBudget = def.UploadBudget
+	}
+	if cfg.PacketLoss.Packets <= 0 {
+		cfg.PacketLoss.Packets = def.PacketLoss.Packets
+	}
+	if cfg.PacketLoss.Interval <= 0 {
+		cfg.PacketLoss.Interval = def.PacketLoss.Interval
+	}
+	if cfg.PacketLoss.ExtraWait <= 0 {
+		cfg.PacketLoss.ExtraWait = def.PacketLoss.ExtraWait
+	}
+	if cfg.PacketLoss.Progress == nil {
+		cfg.PacketLoss.Progress = cfg.Progress.PacketLoss
+	}
+	return cfg
+}
+
+// Result is a complete test. It marshals to the same JSON as the web UI's
+// export, so it can be submitted to /api/results as is.
+type Result struct {
+	Meta              *api.ClientMeta    `json:"meta"`
+	Summary           Summary            `json:"summary"`
+	Quality           Quality            `json:"quality"`
+	ThroughputSamples []ThroughputSample `json:"throughputSamples"`
+	LatencySamples    []LatencySample    `json:"latencySamples"`
+	PacketLoss        *PacketLossResult  `json:"packetLoss"`
+	// StartTime and EndTime are Unix milliseconds
+	StartTime int64 `json:"startTime"`
+	EndTime   int64 `json:"endTime"`
+	// Locations are the node's test locations; the export leaves them out
+	Locations []api.Location `json:"-"`
+}
+
+// run is the state of one Run.
+type run struct {
+	c   *Client
+	cfg Config
+
+	mu  sync.Mutex
+	res *Result
+}
+
+// Run runs a full test the way the web UI does: unloaded latency, a
+// warmup, the download and upload ladders, latency under load and the
+// packet-loss test. Individual transfers and probes that fail are skipped,
+// as in the browser; Run only fails if ctx is done or a whole stage
+// produced nothing.
+func (c *Client) Run(ctx context.Context, cfg Config) (*Result, error) {
+	r := &run{c: c, cfg: cfg.withDefaults(), res: &Result{
+		ThroughputSamples: []ThroughputSample{},
+		LatencySamples:    []LatencySample{},
+		StartTime:         time.Now().UnixMilli(),
+	}}
+
+	r.stage(StageMeta)
+	m, err := c.Meta(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client info: %w", err)
+	}
+	r.res.Meta = m
+	// Locations are informational; a node without them can still be tested
+	r.res.Locations, _ = c.Locations(ctx)
+
+	steps := []struct {
+		stage string
+		fn    func(context.Context) error
+	}{
+		{StageLatency, r.unloadedLatency},
+		{StageWarmup, r.warmup},
+		{StageDownload, func(ctx context.Context) error { return r.ladder(ctx, Download) }},
+		{StageUpload, func(ctx context.Context) error { return r.ladder(ctx, Upload) }},
+		{StageLoadedLatency, r.loadedLatency},
+		{StagePacketLoss, r.packetLoss},
+	}
+	for _, step := range steps {
+		if step.stage == StagePacketLoss && r.cfg.SkipPacketLoss {
+			continue
+		}
+		r.stage(step.stage)
+		if err := step.fn(ctx); err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	r.res.EndTime = time.Now().UnixMilli()
+	r.res.Summary = Summarize(r.res.ThroughputSamples, r.res.LatencySamples, r.res.PacketLoss)
+	r.res.Quality = Grade(r.res.Summary)
+	return r.res, nil
+}
+
+func (r *run) stage(name string) {
+	if r.cfg.Progress.Stage != nil {
+		r.cfg.Progress.Stage(name)
+	}
+}
+
+func (r *run) addThroughput(s ThroughputSample) {
+	r.res.ThroughputSamples = append(r.res.ThroughputSamples, s)
+	if r.cfg.Progress.Throughput != nil {
+		r.cfg.Progress.Throughput(s)
+	}
+}
+
+// addLatency records a probe; loaded probes finish concurrently.
+func (r *run) addLatency(s LatencySample) {
+	r.mu.Lock()
+	r.res.LatencySamples = append(r.res.LatencySamples, s)
+	r.mu.Unlock()
+	if r.cfg.Progress.Latency != nil {
+		r.cfg.Progress.Latency(s)
+	}
+}
+
+// unloadedLatency probes an idle connection.
+func (r *run) unloadedLatency(ctx context.Context) error {
+	_, err := r.c.UnloadedLatency(ctx, r.cfg.LatencyProbes, r.addLatency)
+	return err
+}
+
+// warmup opens and warms a handful of connections with small parallel
+// downloads and uploads, so the measured transfers do not alternate between
+// warm and cold ones. Failures are ignored.
+func (r *run) warmup(ctx context.Context) error {
+	const conns = 6
+	for _, dir := range []string{Download, Upload} {
+		var wg sync.WaitGroup
+		for i := range conns {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r.transfer(ctx, dir, Transfer{Bytes: profiles(dir)[0].Bytes, Profile: "warmup", Run: i})
+			}()
+		}
+		wg.Wait()
+	}
+	return nil
+}
+
+// profiles returns the ladder for direction dir.
+func profiles(dir string) []Profile {
+	if dir == Upload {
+		return UploadProfiles
+	}
+	return DownloadProfiles
+}
+
+// transfer runs one download or upload.
+func (r *run) transfer(ctx context.Context, dir string, t Transfer) (ThroughputSample, error) {
+	if dir == Download {
+		return r.c.Download(ctx, t)
+	}
+	return r.c.Upload(ctx, t)
+}
+
+// ladder runs the baseline profiles in direction dir, estimates the
+// sustained speed from the 1MB runs, and then runs the larger profiles
+// that fit the time limits.
+func (r *run) ladder(ctx context.Context, dir string) error {
+	all, budget := profiles(dir), r.cfg.DownloadBudget
+	if dir == Upload {
+		budget = r.cfg.UploadBudget
+	}
+
+	var baseline []float64
+	var lastErr error
+	ok := 0
+	for i, p := range all[:baselineProfiles] {
+		for run := 0; run < p.Runs && ctx.Err() == nil; run++ {
+			s, err := r.transfer(ctx, dir, Transfer{Bytes: p.Bytes, Profile: p.Name, Run: run})
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			ok++
+			r.addThroughput(s)
+			if i == baselineProfiles-1 {
+				baseline = append(baseline, s.Mbps)
+			}
+		}
+	}
+	if ok == 0 {
+		if lastErr == nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%s failed: %w", dir, lastErr)
+	}
+
+	speed := median(baseline)
+	start := time.Now()
+	for _, p := range selectProfiles(all, dir, speed, r.cfg.MaxTransferTime)[baselineProfiles:] {
+		if ctx.Err() != nil {
+			break
+		}
+		// Skip a batch that will not fit in what is left of the budget
+		if transferTime(p.Bytes, speed)*time.Duration(p.Runs) > budget-time.Since(start) {
+			continue
+		}
+		for run := 0; run < p.Runs && ctx.Err() == nil; run++ {
+			if s, err := r.transfer(ctx, dir, Transfer{Bytes: p.Bytes, Profile: p.Name, Run: run}); err == nil {
+				r.addThroughput(s)
+			}
+		}
+	}
+	return nil
+}
+
+// transferTime estimates how long n bytes take at speedMbps.
+func transferTime(n int64, speedMbps float64) time.Duration {
+	if speedMbps <= 0 {
+		return time.Duration(1<<63 - 1)
+	}
+	return time.Duration(float64(n) * 8 / (speedMbps * 1e6) * float64(time.Second))
+}
+
+// selectProfiles returns the baselines of all plus each larger profile
+// expected to take no longer than maxTime at speedMbps. For uploads, 50MB
+// is dropped when 100MB is picked, which gives better data.
+func selectProfiles(all []Profile, dir string, speedMbps float64, maxTime time.Duration) []Profile {
+	picked := slices.Clone(all[:baselineProfiles])
+	for _, p := range all[baselineProfiles:] {
+		if transferTime(p.Bytes, speedMbps) <= maxTime {
+			picked = append(picked, p)
+		}
+	}
+	if dir == Upload && slices.ContainsFunc(picked, func(p Profile) bool { return p.Name == "100MB" }) {
+		picked = slices.DeleteFunc(picked, func(p Profile) bool { return p.Name == "50MB" })
+	}
+	return picked
+}
+
+// largestProfile is the biggest profile run in direction dir, used to load
+// the line for the loaded latency probes.
+func (r *run) largestProfile(dir string) Profile {
+	all := profiles(dir)
+	largest := all[baselineProfiles-1]
+	for _, s := range r.res.ThroughputSamples {
+		if s.Direction != dir || s.SizeBytes <= largest.Bytes {
+			continue
+		}
+		for _, p := range all {
+			if p.Name == s.Profile {
+				largest = p
+			}
+		}
+	}
+	return largest
+}
+
+// loadedLatency probes latency while the largest profile downloads, then
+// while it uploads.
+func (r *run) loadedLatency(ctx context.Context) error {
+	for _, dir := range []string{Download, Upload} {
+		_, err := r.c.LoadedLatency(ctx, LoadedLatencyConfig{
+			Direction: dir,
+			Profile:   r.largestProfile(dir),
+			Probes:    r.cfg.LoadedLatencyProbes,
+			Progress:  r.addLatency,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// packetLoss runs the packet-loss test. A test that could not connect is
+// recorded as unavailable rather than failing the run.
+func (r *run) packetLoss(ctx context.Context) error {
+	res, err := r.c.PacketLoss(ctx, r.cfg.PacketLoss)
+	if err != nil {
+		if errors.Is(err, ctx.Err()) {
+			return err
+		}
+		res = unavailable(err.Error())
+	}
+	r.res.PacketLoss = res
+	return nil
+}
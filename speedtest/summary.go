@@ -0,0 +1,155 @@
+package speedtest
+
+import (
+	"math"
+	"slices"
+)
+
+// Summary holds the headline numbers of a test. The JSON names match the
+// summary object the web UI submits.
+type Summary struct {
+	DownloadMbps      float64 `json:"downloadMbps"`
+	UploadMbps        float64 `json:"uploadMbps"`
+	LatencyUnloadedMs float64 `json:"latencyUnloadedMs"`
+	LatencyDownloadMs float64 `json:"latencyDownloadMs"`
+	LatencyUploadMs   float64 `json:"latencyUploadMs"`
+	JitterMs          float64 `json:"jitterMs"`
+	PacketLossPercent float64 `json:"packetLossPercent"`
+}
+
+// Quality grades.
+const (
+	Great = "Great"
+	Good  = "Good"
+	Okay  = "Okay"
+	Poor  = "Poor"
+)
+
+// Quality grades a connection for common uses.
+type Quality struct {
+	VideoStreaming string `json:"videoStreaming"`
+	Gaming         string `json:"gaming"`
+	VideoChatting  string `json:"videoChatting"`
+}
+
+// minSampleMs is the shortest transfer counted in the summary; shorter ones
+// are below what the timers can resolve reliably.
+const minSampleMs = 10
+
+// Summarize computes the summary the web UI shows. Speeds are the 90th
+// percentile of the transfers. Unloaded latency is the median of the
+// probes after the first two, which pay for connection setup, with
+// outliers removed; jitter is the spread between its 90th percentile and
+// median. Loaded latency is the 90th percentile of the loaded probes.
+func Summarize(throughput []ThroughputSample, latency []LatencySample, loss *PacketLossResult) Summary {
+	var dl, ul, unloaded, latDown, latUp []float64
+	for _, s := range throughput {
+		if s.DurationMs < minSampleMs {
+			continue
+		}
+		if s.Direction == Download {
+			dl = append(dl, s.Mbps)
+		} else {
+			ul = append(ul, s.Mbps)
+		}
+	}
+	for _, s := range latency {
+		switch s.Phase {
+		case PhaseUnloaded:
+			unloaded = append(unloaded, s.RTTMs)
+		case PhaseDownload:
+			latDown = append(latDown, s.RTTMs)
+		case PhaseUpload:
+			latUp = append(latUp, s.RTTMs)
+		}
+	}
+	if len(unloaded) > 2 {
+		unloaded = unloaded[2:]
+	} else {
+		unloaded = nil
+	}
+	unloaded = filterOutliers(unloaded)
+
+	s := Summary{
+		DownloadMbps:      percentile(dl, 90),
+		UploadMbps:        percentile(ul, 90),
+		LatencyUnloadedMs: percentile(unloaded, 50),
+		LatencyDownloadMs: percentile(latDown, 90),
+		LatencyUploadMs:   percentile(latUp, 90),
+	}
+	if len(unloaded) > 0 {
+		s.JitterMs = percentile(unloaded, 90) - percentile(unloaded, 50)
+	}
+	if loss != nil {
+		s.PacketLossPercent = loss.LossPercent
+	}
+	return s
+}
+
+// Grade grades s with the web UI's thresholds.
+func Grade(s Summary) Quality {
+	type limits struct{ mbps, latency, jitter, loss float64 }
+	grade := func(mbps float64, tiers [3]limits) string {
+		for i, t := range tiers {
+			if mbps >= t.mbps && s.LatencyUnloadedMs <= t.latency && s.JitterMs <= t.jitter && s.PacketLossPercent <= t.loss {
+				return [3]string{Great, Good, Okay}[i]
+			}
+		}
+		return Poor
+	}
+	return Quality{
+		VideoStreaming: grade(s.DownloadMbps, [3]limits{{50, 25, 5, 0.5}, {20, 50, 15, 1.5}, {10, 80, 30, 3}}),
+		Gaming:         grade(s.DownloadMbps, [3]limits{{25, 20, 3, 0.1}, {15, 40, 10, 0.5}, {5, 80, 20, 2}}),
+		// Video calls need the upload as much as the download
+		VideoChatting: grade(min(s.DownloadMbps, s.UploadMbps), [3]limits{{10, 30, 5, 0.5}, {5, 50, 15, 1}, {2, 100, 30, 3}}),
+	}
+}
+
+// percentile returns the nearest-rank p-th percentile of values, or 0.
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := slices.Sorted(slices.Values(values))
+	idx := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	return sorted[max(0, idx)]
+}
+
+// median returns the median of values, averaging the middle two of an even
+// count, or 0.
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := slices.Sorted(slices.Values(values))
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// filterOutliers drops values more than 1.5 IQR outside the quartiles. It
+// needs at least four values, and keeps them all if it would drop more
+// than half.
+func filterOutliers(values []float64) []float64 {
+	if len(values) < 4 {
+		return values
+	}
+	sorted := slices.Sorted(slices.Values(values))
+	q1 := sorted[len(sorted)/4]
+	q3 := sorted[len(sorted)*3/4]
+	iqr := q3 - q1
+	lower, upper := q1-1.5*iqr, q3+1.5*iqr
+
+	var kept []float64
+	for _, v := range values {
+		if v >= lower && v <= upper {
+			kept = append(kept, v)
+		}
+	}
+	if float64(len(kept)) < float64(len(values))*0.5 {
+		return values
+	}
+	return kept
+}
@@ -0,0 +1,175 @@
+package speedtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// TimedConfig sets up a timed test. The zero value of a field means its
+// default.
+type TimedConfig struct {
+	// Direction is Download or Upload, or empty for latency alone
+	Direction string
+	// Profile is the size of each transfer (default the 25MB profile)
+	Profile Profile
+	// Duration is how long the test transfers or probes (default 10s)
+	Duration time.Duration
+	// MaxBytes, if positive, ends the transfers early once they have
+	// moved this much
+	MaxBytes int64
+	// LatencyProbes is the number of unloaded probes before the
+	// transfers (default 10)
+	LatencyProbes int
+	// Interval is the time between latency probes during the test
+	// (default 250ms)
+	Interval time.Duration
+	Progress Progress
+}
+
+// ProfileByName returns the profile of direction dir called name.
+func ProfileByName(dir, name string) (Profile, bool) {
+	for _, p := range profiles(dir) {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Profile{}, false
+}
+
+// RunTimed runs a test for a fixed time instead of over the profile
+// ladder: after a few unloaded latency probes, it transfers cfg.Profile in
+// cfg.Direction back to back for cfg.Duration, or until cfg.MaxBytes have
+// moved, while probing latency. The transfer still going when time is up is
+// not counted. With no direction, it only probes latency, on an idle
+// connection, for cfg.Duration.
+func (c *Client) RunTimed(ctx context.Context, cfg TimedConfig) (*Result, error) {
+	if cfg.Direction != Download && cfg.Direction != Upload && cfg.Direction != "" {
+		return nil, fmt.Errorf("invalid direction %q", cfg.Direction)
+	}
+	if cfg.Profile.Bytes <= 0 {
+		cfg.Profile, _ = ProfileByName(Download, "25MB")
+	}
+	if cfg.Duration <= 0 {
+		cfg.Duration = 10 * time.Second
+	}
+	if cfg.LatencyProbes <= 0 {
+		cfg.LatencyProbes = 10
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 250 * time.Millisecond
+	}
+	r := &run{c: c, cfg: Config{Progress: cfg.Progress}, res: &Result{
+		ThroughputSamples: []ThroughputSample{},
+		LatencySamples:    []LatencySample{},
+		StartTime:         time.Now().UnixMilli(),
+	}}
+
+	r.stage(StageMeta)
+	m, err := c.Meta(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client info: %w", err)
+	}
+	r.res.Meta = m
+
+	if cfg.Direction == "" {
+		r.stage(StageLatency)
+		if err := r.probeFor(ctx, PhaseUnloaded, cfg.Duration, cfg.Interval); err != nil {
+			return nil, err
+		}
+	} else {
+		r.stage(StageLatency)
+		if _, err := c.UnloadedLatency(ctx, cfg.LatencyProbes, r.addLatency); err != nil {
+			return nil, err
+		}
+		r.stage(cfg.Direction)
+		if err := r.timedTransfers(ctx, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	r.res.EndTime = time.Now().UnixMilli()
+	r.res.Summary = Summarize(r.res.ThroughputSamples, r.res.LatencySamples, nil)
+	r.res.Quality = Grade(r.res.Summary)
+	return r.res, nil
+}
+
+// timedTransfers runs transfers back to back until cfg.Duration is up,
+// with latency probes alongside.
+func (r *run) timedTransfers(ctx context.Context, cfg TimedConfig) error {
+	tctx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r.probeFor(tctx, cfg.Direction, cfg.Duration, cfg.Interval)
+	}()
+
+	var lastErr error
+	var moved int64
+	for run := 0; tctx.Err() == nil && (cfg.MaxBytes <= 0 || moved < cfg.MaxBytes); run++ {
+		s, err := r.transfer(tctx, cfg.Direction, Transfer{Bytes: cfg.Profile.Bytes, Profile: cfg.Profile.Name, Run: run, During: cfg.Direction})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		moved += s.SizeBytes
+		r.addThroughput(s)
+	}
+	cancel()
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(r.res.ThroughputSamples) == 0 {
+		if lastErr == nil || errors.Is(lastErr, context.DeadlineExceeded) {
+			return fmt.Errorf("%s failed: no %s transfer finished within %s", cfg.Direction, cfg.Profile.Name, cfg.Duration)
+		}
+		return fmt.Errorf("%s failed: %w", cfg.Direction, lastErr)
+	}
+	return nil
+}
+
+// probeFor sends a latency probe in phase every interval for d. Probes
+// that fail are skipped; it fails only if none succeeded.
+func (r *run) probeFor(ctx context.Context, phase string, d, interval time.Duration) error {
+	pctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	ok := 0
+	var mu sync.Mutex
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for seq := 0; ; seq++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, err := r.c.LatencyProbe(pctx, phase, seq)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			ok++
+			mu.Unlock()
+			r.addLatency(s)
+		}()
+		select {
+		case <-pctx.Done():
+			wg.Wait()
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if ok == 0 && phase == PhaseUnloaded {
+				return errors.New("latency failed: no probe succeeded")
+			}
+			return nil
+		case <-tick.C:
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package speedtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Directions of a ThroughputSample.
+const (
+	Download = "download"
+	Upload   = "upload"
+)
+
+// Latency phases: probes on an idle connection, or while a download or
+// upload is running.
+const (
+	PhaseUnloaded = "unloaded"
+	PhaseDownload = "download"
+	PhaseUpload   = "upload"
+)
+
+// ThroughputSample is one download or upload, as in the web UI's results.
+type ThroughputSample struct {
+	// TS is when the transfer finished, in Unix milliseconds
+	TS         int64   `json:"ts"`
+	Direction  string  `json:"direction"`
+	SizeBytes  int64   `json:"sizeBytes"`
+	DurationMs float64 `json:"durationMs"`
+	Mbps       float64 `json:"mbps"`
+	Profile    string  `json:"profile"`
+	RunIndex   int     `json:"runIndex"`
+}
+
+// LatencySample is one latency probe.
+type LatencySample struct {
+	TS    int64   `json:"ts"`
+	RTTMs float64 `json:"rttMs"`
+	Phase string  `json:"phase"`
+}
+
+// timing records when a request went out and its response started to
+// arrive.
+type timing struct {
+	wrote     time.Time
+	firstByte time.Time
+}
+
+func (t *timing) trace(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteRequest:         func(httptrace.WroteRequestInfo) { t.wrote = time.Now() },
+		GotFirstResponseByte: func() { t.firstByte = time.Now() },
+	})
+}
+
+// ms converts d to fractional milliseconds.
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// mbps is the speed of n bytes in durationMs.
+func mbps(n int64, durationMs float64) float64 {
+	return float64(n) * 8 / (durationMs / 1000) / 1e6
+}
+
+// Transfer is one sized download or upload.
+type Transfer struct {
+	Bytes int64
+	// Profile and Run label the transfer in its sample and in the node's
+	// logs, e.g. "25MB" and 0 for the first 25MB run
+	Profile string
+	Run     int
+	// During is PhaseDownload or PhaseUpload for a transfer that loads the
+	// line while latency is probed, and empty otherwise
+	During string
+	// Progress, if set, is called as the body moves with the number of
+	// bytes so far
+	Progress func(bytes int64)
+}
+
+func (t *Transfer) query() url.Values {
+	q := url.Values{
+		"measId":  {newMeasID()},
+		"profile": {t.Profile},
+		"run":     {strconv.Itoa(t.Run)},
+	}
+	if t.During != "" {
+		q.Set("during", t.During)
+	}
+	return q
+}
+
+// progressReader calls fn with the running total of bytes read through it.
+type progressReader struct {
+	r  io.Reader
+	n  int64
+	fn func(int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.n += int64(n)
+		p.fn(p.n)
+	}
+	return n, err
+}
+
+// withProgress wraps r to report to fn, if set.
+func withProgress(r io.Reader, fn func(int64)) io.Reader {
+	if fn == nil {
+		return r
+	}
+	return &progressReader{r: r, fn: fn}
+}
+
+// Download fetches t.Bytes from /__down. The duration is the time the body
+// took to arrive, or the whole request for bodies too small to time.
+func (c *Client) Download(ctx context.Context, t Transfer) (ThroughputSample, error) {
+	q := t.query()
+	q.Set("bytes", strconv.FormatInt(t.Bytes, 10))
+	var tm timing
+	start := time.Now()
+	resp, err := c.measure(ctx, func() (*http.Request, error) {
+		return http.NewRequestWithContext(tm.trace(ctx), http.MethodGet, c.endpoint("/__down", q), nil)
+	})
+	if err != nil {
+		return ThroughputSample{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ThroughputSample{}, fmt.Errorf("download failed: %w", statusError(resp.Request, resp))
+	}
+	received, err := io.Copy(io.Discard, withProgress(resp.Body, t.Progress))
+	end := time.Now()
+	if err != nil {
+		return ThroughputSample{}, fmt.Errorf("download failed: %w", err)
+	}
+
+	d := end.Sub(start)
+	if body := end.Sub(tm.firstByte); !tm.firstByte.IsZero() && body >= time.Millisecond {
+		d = body
+	} else if !tm.wrote.IsZero() {
+		d = end.Sub(tm.wrote)
+	}
+	return ThroughputSample{
+		TS:         end.UnixMilli(),
+		Direction:  Download,
+		SizeBytes:  received,
+		DurationMs: ms(d),
+		Mbps:       mbps(received, ms(d)),
+		Profile:    t.Profile,
+		RunIndex:   t.Run,
+	}, nil
+}
+
+// zeros is an endless source of zero bytes for upload bodies.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// Upload posts t.Bytes to /__up. The duration is the node's own
+// measurement from its Server-Timing header when there is one, and the
+// time until the response otherwise. The size is what the node says it
+// read, which is less than t.Bytes if that is over its max_bytes.
+func (c *Client) Upload(ctx context.Context, t Transfer) (ThroughputSample, error) {
+	q := t.query()
+	var tm timing
+	start := time.Now()
+	resp, err := c.measure(ctx, func() (*http.Request, error) {
+		body := withProgress(io.LimitReader(zeros{}, t.Bytes), t.Progress)
+		req, err := http.NewRequestWithContext(tm.trace(ctx), http.MethodPost, c.endpoint("/__up", q), body)
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = t.Bytes
+		req.Header.Set("Content-Type", "application/octet-stream")
+		return req, nil
+	})
+	if err != nil {
+		return ThroughputSample{}, err
+	}
+	var body struct {
+		Bytes int64 `json:"bytes"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	end := time.Now()
+	if resp.StatusCode != http.StatusOK {
+		return ThroughputSample{}, fmt.Errorf("upload failed: %w", statusError(resp.Request, resp))
+	}
+	size := t.Bytes
+	if body.Bytes > 0 && body.Bytes < size {
+		size = body.Bytes
+	}
+
+	durationMs := serverTiming(resp.Header)
+	if durationMs <= 0 {
+		d := end.Sub(start)
+		if !tm.firstByte.IsZero() {
+			d = tm.firstByte.Sub(start)
+		}
+		durationMs = ms(d)
+	}
+	return ThroughputSample{
+		TS:         end.UnixMilli(),
+		Direction:  Upload,
+		SizeBytes:  size,
+		DurationMs: durationMs,
+		Mbps:       mbps(size, durationMs),
+		Profile:    t.Profile,
+		RunIndex:   t.Run,
+	}, nil
+}
+
+// serverTiming returns the "app" duration of a Server-Timing header in
+// milliseconds, or 0.
+func serverTiming(h http.Header) float64 {
+	for _, v := range h.Values("Server-Timing") {
+		for _, metric := range strings.Split(v, ",") {
+			params := strings.Split(strings.TrimSpace(metric), ";")
+			if params[0] != "app" {
+				continue
+			}
+			for _, p := range params[1:] {
+				if dur, ok := strings.CutPrefix(strings.TrimSpace(p), "dur="); ok {
+					f, _ := strconv.ParseFloat(dur, 64)
+					return f
+				}
+			}
+		}
+	}
+	return 0
+}
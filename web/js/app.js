@@ -18,6 +18,7 @@
         uploadSamples: [],
         latencySamples: [],
         testStartTime: null,
+        sharedResultId: null,
         timingWarningShown: false,
         timingFallbackCount: 0,
         mapRendered: false
@@ -35,7 +36,7 @@
         setupTheme();
 
         // Check if viewing shared results
-        const isSharedView = checkForSharedResults();
+        const isSharedView = checkForSharedResults() || checkForStoredResults();
 
         // Load server info (unless viewing shared results)
         if (!isSharedView) {
@@ -400,6 +401,7 @@
         state.latencySamples = [];
         state.summary = null;
         state.quality = null;
+        state.sharedResultId = null;
         state.packetLoss = null;
         state.networkScore = null;
         state.lossPattern = null;
@@ -1889,6 +1891,142 @@
         return true;
     }
 
+    /**
+     * Check URL for a stored result ID (?id=) and display it once loaded
+     */
+    function checkForStoredResults() {
+        const id = new URLSearchParams(window.location.search).get('id');
+        if (!id) {
+            return false;
+        }
+
+        fetch(`/api/results/${encodeURIComponent(id)}`)
+            .then(response => {
+                if (!response.ok) throw new Error(`HTTP ${response.status}`);
+                return response.json();
+            })
+            .then(stored => {
+                const view = stored.result?.view;
+                if (!view) throw new Error('result has no view');
+                displaySharedResults(view);
+            })
+            .catch(err => {
+                console.error('Failed to load stored results:', err);
+                showError('Shared result not found');
+                loadInitialData();
+            });
+        return true;
+    }
+
+    /**
+     * Build the shared-results view of the current test: the shape
+     * decodeResultsFromURL returns, at full precision
+     */
+    function buildSharedView() {
+        const pl = state.packetLoss;
+        const lp = state.lossPattern;
+        const bw = state.bandwidthEstimate;
+        const dc = state.dataChannelStats;
+        const ns = state.networkScore;
+        const serverLoc = state.locations?.find(l => l.iata === state.meta?.colo);
+        const latency = phase => state.latencySamples.filter(s => s.phase === phase).map(s => s.rttMs);
+        const latencyDownload = latency('download');
+        const latencyUpload = latency('upload');
+
+        return {
+            downloadMbps: state.summary.downloadMbps,
+            uploadMbps: state.summary.uploadMbps,
+            latencyMs: state.summary.latencyUnloadedMs,
+            latencyDownloadMs: latencyDownload.length > 0 ? Charts.median(latencyDownload) : 0,
+            latencyUploadMs: latencyUpload.length > 0 ? Charts.median(latencyUpload) : 0,
+            jitterMs: state.summary.jitterMs,
+            packetLossPercent: state.summary.packetLossPercent,
+            networkScore: ns?.overall || 0,
+            networkScoreComponents: {
+                bandwidth: ns?.components?.bandwidth || 0,
+                latency: ns?.components?.latency || 0,
+                stability: ns?.components?.stability || 0,
+                reliability: ns?.components?.reliability || 0
+            },
+            timestamp: Date.now(),
+            server: state.meta?.server?.city || null,
+            packetLoss: {
+                sent: pl?.sent || 0,
+                received: pl?.received || 0,
+                rttMin: pl?.rttStatsMs?.min || 0,
+                rttMedian: pl?.rttStatsMs?.median || 0,
+                rttP90: pl?.rttStatsMs?.p90 || 0,
+                rttJitter: pl?.jitterMs || 0,
+                lossPercent: state.summary.packetLossPercent
+            },
+            coords: {
+                clientLat: state.meta?.latitude || 0,
+                clientLon: state.meta?.longitude || 0,
+                serverLat: serverLoc?.lat || 0,
+                serverLon: serverLoc?.lon || 0
+            },
+            quality: state.quality || {},
+            downloadSamples: state.downloadSamples.map(s => s.mbps),
+            uploadSamples: state.uploadSamples.map(s => s.mbps),
+            latencyUnloaded: latency('unloaded'),
+            latencyDownload: latencyDownload,
+            latencyUpload: latencyUpload,
+            dataChannelStats: {
+                connectionType: dc?.connectionType || 'unknown',
+                protocol: dc?.protocol?.toLowerCase() === 'tcp' ? 'tcp' : 'udp',
+                currentRoundTripTime: dc?.currentRoundTripTime || 0
+            },
+            lossPattern: {
+                type: lp?.type || 'none',
+                burstCount: lp?.burstCount || 0,
+                maxBurstLength: lp?.maxBurstLength || 0,
+                avgBurstLength: lp?.avgBurstLength || 0,
+                lossDistribution: lp?.lossDistribution || []
+            },
+            bandwidthEstimate: {
+                downloadTrend: bw?.downloadTrend || 'stable',
+                uploadTrend: bw?.uploadTrend || 'stable',
+                downloadPeakMbps: bw?.downloadPeakMbps || 0,
+                uploadPeakMbps: bw?.uploadPeakMbps || 0,
+                downloadSustainedMbps: bw?.downloadSustainedMbps || 0,
+                uploadSustainedMbps: bw?.uploadSustainedMbps || 0,
+                downloadVariability: bw?.downloadVariability || 0,
+                uploadVariability: bw?.uploadVariability || 0
+            },
+            testConfidence: {
+                overall: state.testConfidence?.overall || 'low'
+            }
+        };
+    }
+
+    /**
+     * Store the current result on the server (POST /api/results) and
+     * return its ID, or null if the server does not store results
+     */
+    async function storeResults() {
+        if (state.sharedResultId) return state.sharedResultId;
+
+        const doc = JSON.parse(SpeedTest.exportResults());
+        // The link is public; the server keeps the address to itself
+        if (doc.meta) delete doc.meta.clientIp;
+        doc.view = buildSharedView();
+
+        try {
+            const response = await fetch('/api/results', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify(doc)
+            });
+            if (!response.ok) return null;
+            const created = await response.json();
+            state.sharedResultId = created.id;
+            return created.id;
+        } catch (e) {
+            console.warn('Failed to store results, sharing in the URL instead:', e);
+            return null;
+        }
+    }
+
     /**
      * Display shared results in read-only mode
      */
@@ -2139,17 +2277,23 @@
     }
 
     /**
-     * Share results with encoded URL
+     * Share results as a short link to the stored result, or with the
+     * results encoded in the URL if the server does not store them
      */
     async function shareResults() {
         if (!state.summary) return;
 
-        const encoded = encodeResultsForURL();
-        if (!encoded) return;
-
         // Build share URL
         const baseUrl = window.location.origin + window.location.pathname;
-        const shareUrl = `${baseUrl}?r=${encoded}`;
+        let shareUrl;
+        const id = await storeResults();
+        if (id) {
+            shareUrl = `${baseUrl}?id=${encodeURIComponent(id)}`;
+        } else {
+            const encoded = encodeResultsForURL();
+            if (!encoded) return;
+            shareUrl = `${baseUrl}?r=${encoded}`;
+        }
 
         const shareText = `Download: ${state.summary.downloadMbps.toFixed(1)} Mbps | ` +
                          `Upload: ${state.summary.uploadMbps.toFixed(1)} Mbps | ` +
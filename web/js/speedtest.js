@@ -158,6 +158,7 @@ const SpeedTest = (function() {
     let isPaused = false;
     let timingFallbackCount = 0;
     let resourceTimingUsed = false;
+    let testTokenPromise = null;
 
     // Results storage
     let results = {
@@ -214,6 +215,50 @@ const SpeedTest = (function() {
         return response.json();
     }
 
+    /**
+     * Get a test token from the server, or null if it doesn't require one
+     */
+    async function fetchTestToken() {
+        const response = await fetch('/api/tests', { method: 'POST', cache: 'no-store' });
+        if (response.status === 404) return null;
+        if (!response.ok) throw new Error(`Failed to get test token: ${response.status}`);
+        const body = await response.json();
+        return body.token;
+    }
+
+    /**
+     * Share one token request between parallel transfers
+     */
+    function getTestToken() {
+        if (!testTokenPromise) {
+            testTokenPromise = fetchTestToken().catch(err => {
+                testTokenPromise = null;
+                throw err;
+            });
+        }
+        return testTokenPromise;
+    }
+
+    /**
+     * fetch() for the measurement endpoints. Sends the test token if the
+     * server wants one, and on a 401 (token expired or budget spent)
+     * gets a fresh token and retries once.
+     */
+    async function measurementFetch(url, options = {}) {
+        for (let attempt = 0; ; attempt++) {
+            const pending = getTestToken();
+            const token = await pending;
+            const headers = { ...options.headers };
+            if (token) headers['Authorization'] = `Bearer ${token}`;
+
+            const response = await fetch(url, { ...options, headers });
+            if (response.status !== 401 || attempt > 0) return response;
+
+            // Only the first rejected request drops the shared token
+            if (testTokenPromise === pending) testTokenPromise = null;
+        }
+    }
+
     /**
      * Get Resource Timing entry for a URL (for precise timing)
      * Waits briefly for the entry to be recorded if not immediately available
@@ -249,7 +294,7 @@ const SpeedTest = (function() {
         // Capture start time for manual fallback timing
         const manualStart = performance.now();
 
-        const response = await fetch(url, {
+        const response = await measurementFetch(url, {
             cache: 'no-store',
             signal: abortController?.signal
         });
@@ -329,7 +374,7 @@ const SpeedTest = (function() {
         // Capture start time for manual fallback timing
         const manualStart = performance.now();
 
-        const response = await fetch(url, {
+        const response = await measurementFetch(url, {
             method: 'POST',
             body: payload,
             headers: { 'Content-Type': 'application/octet-stream' },
@@ -404,7 +449,7 @@ const SpeedTest = (function() {
         const url = `/__down?bytes=0&measId=${measId}&during=${phase}&seq=${seq}`;
 
         const manualStart = performance.now();
-        const response = await fetch(url, {
+        const response = await measurementFetch(url, {
             cache: 'no-store',
             signal: abortController?.signal
         });
@@ -495,7 +540,7 @@ const SpeedTest = (function() {
             const bytes = 100 * 1000; // 100KB
             const url = `/__down?bytes=${bytes}&measId=bw-check-${Date.now()}`;
             const start = performance.now();
-            const response = await fetch(url, { cache: 'no-store', signal: abortController?.signal });
+            const response = await measurementFetch(url, { cache: 'no-store', signal: abortController?.signal });
             if (!response.ok) return 0;
             await response.arrayBuffer();
             const durationMs = performance.now() - start;
@@ -1008,7 +1053,7 @@ const SpeedTest = (function() {
             });
 
             // Exchange SDP with server
-            const offerResponse = await fetch('/api/packet-test/offer', {
+            const offerResponse = await measurementFetch('/api/packet-test/offer', {
                 method: 'POST',
                 headers: { 'Content-Type': 'application/json' },
                 credentials: 'include',
@@ -1915,6 +1960,7 @@ const SpeedTest = (function() {
         abortController = new AbortController();
         timingFallbackCount = 0;
         resourceTimingUsed = false;
+        testTokenPromise = null;
 
         // Increase Resource Timing buffer to handle all our requests
         // Default is 150-250 entries which may not be enough